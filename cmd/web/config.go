@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"strings"
+	"time"
+)
+
+const envPrefix = "SNIPPETBOX_"
+
+// uiDir is where the templates are read from in the development mode
+const uiDir = "./ui"
+
+type config struct {
+	Addr          string        `toml:"addr"`
+	DSN           string        `toml:"dsn"`
+	ShutdownDelay time.Duration `toml:"shutdown_delay"`
+	Dev           bool          `toml:"dev"`
+	Migrate       bool          `toml:"migrate"`
+
+	Log struct {
+		Level  string `toml:"level"`
+		Format string `toml:"format"`
+	} `toml:"log"`
+
+	TLS struct {
+		Enabled      bool   `toml:"enabled"`
+		Cert         string `toml:"cert"`
+		Key          string `toml:"key"`
+		RedirectAddr string `toml:"redirect_addr"`
+
+		// the certificate and key files are not used when the certificates are obtained with ACME
+		ACME struct {
+			Domains      stringList `toml:"domains"`
+			Email        string     `toml:"email"`
+			CacheDir     string     `toml:"cache_dir"`
+			DirectoryURL string     `toml:"directory_url"`
+		} `toml:"acme"`
+	} `toml:"tls"`
+
+	Timeouts struct {
+		Read  time.Duration `toml:"read"`
+		Write time.Duration `toml:"write"`
+		Idle  time.Duration `toml:"idle"`
+		Query time.Duration `toml:"query"`
+	} `toml:"timeouts"`
+
+	Session struct {
+		Lifetime time.Duration `toml:"lifetime"`
+	} `toml:"session"`
+
+	Password struct {
+		Hasher     string `toml:"hasher"`
+		BcryptCost int    `toml:"bcrypt_cost"`
+		Argon2     struct {
+			Memory      uint `toml:"memory"`
+			Iterations  uint `toml:"iterations"`
+			Parallelism uint `toml:"parallelism"`
+		} `toml:"argon2"`
+		BreachList string `toml:"breach_list"`
+	} `toml:"password"`
+
+	Metrics struct {
+		Addr     string `toml:"addr"`
+		User     string `toml:"user"`
+		Password string `toml:"password"`
+	} `toml:"metrics"`
+
+	Trace struct {
+		Exporter string `toml:"exporter"`
+		File     string `toml:"file"`
+	} `toml:"trace"`
+}
+
+func defaultConfig() config {
+	var cfg config
+
+	cfg.Addr = ":8080"
+	cfg.DSN = "file:db.sqlite"
+
+	cfg.Log.Level = "info"
+	cfg.Log.Format = "text"
+
+	cfg.TLS.Enabled = true
+	cfg.TLS.Cert = "./tls/cert.pem"
+	cfg.TLS.Key = "./tls/key.pem"
+	cfg.TLS.ACME.CacheDir = "./tls/acme"
+	cfg.TLS.ACME.DirectoryURL = acme.LetsEncryptURL
+
+	cfg.Timeouts.Read = 5 * time.Second
+	cfg.Timeouts.Write = 10 * time.Second
+	cfg.Timeouts.Idle = time.Minute
+	cfg.Timeouts.Query = 3 * time.Second
+
+	cfg.Session.Lifetime = 12 * time.Hour
+
+	cfg.Password.Hasher = "argon2id"
+	cfg.Password.BcryptCost = 12
+	cfg.Password.Argon2.Memory = uint(password.DefaultArgon2id.Memory)
+	cfg.Password.Argon2.Iterations = uint(password.DefaultArgon2id.Iterations)
+	cfg.Password.Argon2.Parallelism = uint(password.DefaultArgon2id.Parallelism)
+
+	cfg.Trace.Exporter = "none"
+	cfg.Trace.File = "./traces.jsonl"
+
+	return cfg
+}
+
+// bindFlags registers a flag for every config value. The environment variable of a flag is its upper-cased name
+// with the SNIPPETBOX_ prefix, e.g. -metrics-addr is SNIPPETBOX_METRICS_ADDR
+func bindFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "HTTP network address")
+	fs.StringVar(&cfg.DSN, "dsn", cfg.DSN, "Data source name, postgres:// or postgresql:// for PostgreSQL, memory: to keep the data in memory, SQLite otherwise")
+	fs.DurationVar(&cfg.ShutdownDelay, "shutdown-delay", cfg.ShutdownDelay, "Time between failing the readiness check and closing the listeners on shutdown")
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "Development mode: the templates are parsed from the ./ui directory on every request")
+	fs.BoolVar(&cfg.Migrate, "migrate", cfg.Migrate, "Upgrade the database schema with the migrations of sql/migrations on start")
+
+	fs.StringVar(&cfg.Log.Level, "loglevel", cfg.Log.Level, "Logger level: debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "logformat", cfg.Log.Format, "Log format: json or text")
+
+	fs.BoolVar(&cfg.TLS.Enabled, "secure", cfg.TLS.Enabled, "Use HTTPS server")
+	fs.StringVar(&cfg.TLS.Cert, "cert", cfg.TLS.Cert, "TLS certificate file")
+	fs.StringVar(&cfg.TLS.Key, "key", cfg.TLS.Key, "TLS key file")
+	fs.StringVar(&cfg.TLS.RedirectAddr, "redirect-addr", cfg.TLS.RedirectAddr, "HTTP network address redirecting to HTTPS and serving the ACME http-01 challenges, disabled if empty")
+	fs.Var(&cfg.TLS.ACME.Domains, "acme-domains", "Comma separated domains to obtain the certificates for with ACME, the certificate files are used if empty")
+	fs.StringVar(&cfg.TLS.ACME.Email, "acme-email", cfg.TLS.ACME.Email, "Contact email of the ACME account")
+	fs.StringVar(&cfg.TLS.ACME.CacheDir, "acme-cache-dir", cfg.TLS.ACME.CacheDir, "Directory for the ACME account key and the certificates")
+	fs.StringVar(&cfg.TLS.ACME.DirectoryURL, "acme-directory", cfg.TLS.ACME.DirectoryURL, "ACME directory URL")
+
+	fs.DurationVar(&cfg.Timeouts.Read, "read-timeout", cfg.Timeouts.Read, "Maximum duration for reading the entire request")
+	fs.DurationVar(&cfg.Timeouts.Write, "write-timeout", cfg.Timeouts.Write, "Maximum duration before timing out the writes of the response")
+	fs.DurationVar(&cfg.Timeouts.Idle, "idle-timeout", cfg.Timeouts.Idle, "Keep-alive connection timeout")
+	fs.DurationVar(&cfg.Timeouts.Query, "query-timeout", cfg.Timeouts.Query, "Maximum duration of a single database query, the request fails with 503 after it")
+
+	fs.DurationVar(&cfg.Session.Lifetime, "session-lifetime", cfg.Session.Lifetime, "Lifetime of a user session")
+
+	fs.StringVar(&cfg.Password.Hasher, "password-hasher", cfg.Password.Hasher, "Hasher of the new passwords: argon2id or bcrypt, the old hashes are upgraded on login")
+	fs.IntVar(&cfg.Password.BcryptCost, "password-cost", cfg.Password.BcryptCost, "bcrypt cost of the password hashes")
+	fs.UintVar(&cfg.Password.Argon2.Memory, "argon2-memory", cfg.Password.Argon2.Memory, "argon2id memory in KiB")
+	fs.UintVar(&cfg.Password.Argon2.Iterations, "argon2-iterations", cfg.Password.Argon2.Iterations, "argon2id number of passes over the memory")
+	fs.UintVar(&cfg.Password.Argon2.Parallelism, "argon2-parallelism", cfg.Password.Argon2.Parallelism, "argon2id number of threads")
+	fs.StringVar(&cfg.Password.BreachList, "breach-list", cfg.Password.BreachList, "File with the passwords to reject on signup and password change, one per line. The built-in list of the most common ones is used if empty")
+
+	fs.StringVar(&cfg.Metrics.Addr, "metrics-addr", cfg.Metrics.Addr, "Metrics HTTP network address, the metrics are disabled if empty")
+	fs.StringVar(&cfg.Metrics.User, "metrics-user", cfg.Metrics.User, "Basic auth user for the metrics, no authentication if empty")
+	fs.StringVar(&cfg.Metrics.Password, "metrics-password", cfg.Metrics.Password, "Basic auth password for the metrics")
+
+	fs.StringVar(&cfg.Trace.Exporter, "trace-exporter", cfg.Trace.Exporter, "Where to export the trace spans: none, stdout or file")
+	fs.StringVar(&cfg.Trace.File, "trace-file", cfg.Trace.File, "File for the trace spans when -trace-exporter=file")
+}
+
+// loadConfig applies the defaults, the config file, the environment and the flags, each one overriding the previous
+func loadConfig(args []string, getenv func(string) string) (cfg config, printConfig bool, err error) {
+	// the flags are parsed first to find the config file, but applied last
+	parsed := defaultConfig()
+
+	fs := flag.NewFlagSet("web", flag.ContinueOnError)
+	bindFlags(fs, &parsed)
+
+	configFile := fs.String("config", getenv(envPrefix+"CONFIG"), "Path to a TOML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the resulting config with the secrets redacted and exit")
+
+	err = fs.Parse(args)
+	if err != nil {
+		return config{}, false, err
+	}
+
+	cfg = defaultConfig()
+
+	if *configFile != "" {
+		meta, err := toml.DecodeFile(*configFile, &cfg)
+		if err != nil {
+			return config{}, false, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return config{}, false, fmt.Errorf("config file %s: unknown key %s", *configFile, undecoded[0])
+		}
+	}
+
+	target := flag.NewFlagSet("web", flag.ContinueOnError)
+	bindFlags(target, &cfg)
+
+	var errs []error
+
+	target.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+
+		if value := getenv(name); value != "" {
+			if err := target.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", name, err))
+			}
+		}
+	})
+
+	fs.Visit(func(f *flag.Flag) {
+		if target.Lookup(f.Name) != nil {
+			target.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return config{}, false, err
+	}
+
+	return cfg, printConfig, nil
+}
+
+var (
+	logLevels       = []string{"debug", "info", "warn", "error"}
+	logFormats      = []string{"json", "text"}
+	traceExporters  = []string{"none", "stdout", "file"}
+	passwordHashers = []string{"argon2id", "bcrypt"}
+)
+
+func (cfg config) validate() error {
+	var errs []error
+
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("addr is required"))
+	}
+
+	if cfg.DSN == "" {
+		errs = append(errs, errors.New("dsn is required"))
+	}
+
+	if cfg.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("shutdown delay can't be negative"))
+	}
+
+	if cfg.Dev {
+		if _, err := os.Stat(filepath.Join(uiDir, "html")); err != nil {
+			errs = append(errs, fmt.Errorf("development mode requires the templates in %s, run the server from the repository root", uiDir))
+		}
+	}
+
+	if !slices.Contains(logLevels, cfg.Log.Level) {
+		errs = append(errs, fmt.Errorf("unknown log level %s, use one of %s", cfg.Log.Level, strings.Join(logLevels, ", ")))
+	}
+
+	if !slices.Contains(logFormats, cfg.Log.Format) {
+		errs = append(errs, fmt.Errorf("unknown log format %s, use one of %s", cfg.Log.Format, strings.Join(logFormats, ", ")))
+	}
+
+	if cfg.acmeEnabled() {
+		if !cfg.TLS.Enabled {
+			errs = append(errs, errors.New("acme requires TLS to be enabled"))
+		}
+
+		if cfg.TLS.ACME.CacheDir == "" {
+			errs = append(errs, errors.New("acme cache dir is required"))
+		}
+
+		if cfg.TLS.ACME.DirectoryURL == "" {
+			errs = append(errs, errors.New("acme directory url is required"))
+		}
+	} else if cfg.TLS.Enabled {
+		if _, err := os.Stat(cfg.TLS.Cert); err != nil {
+			errs = append(errs, fmt.Errorf("certificate file %s does not exist", cfg.TLS.Cert))
+		}
+
+		if _, err := os.Stat(cfg.TLS.Key); err != nil {
+			errs = append(errs, fmt.Errorf("key file %s does not exist", cfg.TLS.Key))
+		}
+	}
+
+	if cfg.TLS.RedirectAddr != "" && !cfg.TLS.Enabled {
+		errs = append(errs, errors.New("redirect addr requires TLS to be enabled"))
+	}
+
+	if cfg.Timeouts.Read <= 0 || cfg.Timeouts.Write <= 0 || cfg.Timeouts.Idle <= 0 {
+		errs = append(errs, errors.New("server timeouts must be positive"))
+	}
+
+	if cfg.Timeouts.Query <= 0 {
+		errs = append(errs, errors.New("query timeout must be positive"))
+	}
+
+	if cfg.Session.Lifetime <= 0 {
+		errs = append(errs, errors.New("session lifetime must be positive"))
+	}
+
+	if !slices.Contains(passwordHashers, cfg.Password.Hasher) {
+		errs = append(errs, fmt.Errorf("unknown password hasher %s, use one of %s", cfg.Password.Hasher, strings.Join(passwordHashers, ", ")))
+	}
+
+	if cfg.Password.BcryptCost < bcrypt.MinCost || cfg.Password.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("password cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
+	// argon2 takes uint32 memory and uint8 threads
+	if cfg.Password.Argon2.Memory < 8*cfg.Password.Argon2.Parallelism || cfg.Password.Argon2.Memory > math.MaxUint32 {
+		errs = append(errs, errors.New("argon2 memory must be at least 8 KiB per thread"))
+	}
+
+	if cfg.Password.Argon2.Iterations < 1 || cfg.Password.Argon2.Iterations > math.MaxUint32 {
+		errs = append(errs, errors.New("argon2 iterations must be positive"))
+	}
+
+	if cfg.Password.Argon2.Parallelism < 1 || cfg.Password.Argon2.Parallelism > math.MaxUint8 {
+		errs = append(errs, fmt.Errorf("argon2 parallelism must be between 1 and %d", math.MaxUint8))
+	}
+
+	if cfg.Metrics.User != "" && cfg.Metrics.Password == "" {
+		errs = append(errs, errors.New("metrics password is required when the metrics user is set"))
+	}
+
+	if !slices.Contains(traceExporters, cfg.Trace.Exporter) {
+		errs = append(errs, fmt.Errorf("unknown trace exporter %s, use one of %s", cfg.Trace.Exporter, strings.Join(traceExporters, ", ")))
+	}
+
+	if cfg.Trace.Exporter == "file" && cfg.Trace.File == "" {
+		errs = append(errs, errors.New("trace file is required for the file exporter"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg config) acmeEnabled() bool {
+	return len(cfg.TLS.ACME.Domains) > 0
+}
+
+// passwordHasher makes the hashes of the new passwords, the validated config has the parameters in range
+func (cfg config) passwordHasher() password.Hasher {
+	if cfg.Password.Hasher == "bcrypt" {
+		return &password.Bcrypt{Cost: cfg.Password.BcryptCost}
+	}
+
+	return &password.Argon2id{
+		Memory:      uint32(cfg.Password.Argon2.Memory),
+		Iterations:  uint32(cfg.Password.Argon2.Iterations),
+		Parallelism: uint8(cfg.Password.Argon2.Parallelism),
+		SaltLength:  password.DefaultArgon2id.SaltLength,
+		KeyLength:   password.DefaultArgon2id.KeyLength,
+	}
+}
+
+const redacted = "REDACTED"
+
+// redacted returns a copy of the config that is safe to print
+func (cfg config) redacted() config {
+	if cfg.Metrics.Password != "" {
+		cfg.Metrics.Password = redacted
+	}
+
+	cfg.DSN = redactDSN(cfg.DSN)
+
+	return cfg
+}
+
+// dsnPasswordParams are the DSN parameters that hold a password
+var dsnPasswordParams = []string{"password", "sslpassword"}
+
+// dsnPasswordRe matches a password in the key/value form of a DSN, host=localhost password='my secret'
+var dsnPasswordRe = regexp.MustCompile(`(?i)(\b(?:ssl)?password\s*=\s*)('(?:\\.|[^'])*'|\S*)`)
+
+// redactDSN masks the passwords of the URL form of a DSN, in the userinfo and in the query,
+// and of the key/value form
+func redactDSN(dsn string) string {
+	if !strings.Contains(dsn, "://") && !strings.Contains(dsn, "?") {
+		return dsnPasswordRe.ReplaceAllString(dsn, "${1}"+redacted)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		// the password can't be told apart from the rest
+		return redacted
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+
+	query := u.Query()
+	for key := range query {
+		if slices.Contains(dsnPasswordParams, strings.ToLower(key)) {
+			query.Set(key, redacted)
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+func (cfg config) print(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(cfg.redacted())
+}
+
+// stringList is a comma separated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
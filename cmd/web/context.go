@@ -3,3 +3,8 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const isAdminContextKey = contextKey("isAdmin")
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+const userSessionIDContextKey = contextKey("userSessionID")
+const requestIDContextKey = contextKey("requestID")
+const routeContextKey = contextKey("route")
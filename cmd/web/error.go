@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -107,5 +109,29 @@ func NewInternalError() ApiError {
 	return ApiError{
 		Code:    ErrorInternal,
 		Message: "Internal Error",
+		Details: make([]interface{}, 0),
 	}
 }
+
+func NewUnavailableError() ApiError {
+	return ApiError{
+		Code:    ErrorUnavailable,
+		Message: "Service Unavailable, try again later",
+		Details: make([]interface{}, 0),
+	}
+}
+
+// toApiError returns the API error from the chain. A query that ran out of time is reported as unavailable,
+// everything else is an internal error
+func toApiError(err error) ApiError {
+	var apiError ApiError
+	if errors.As(err, &apiError) {
+		return apiError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewUnavailableError()
+	}
+
+	return NewInternalError()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"html/template"
+	"regexp"
+	"snippetbox.doichevkostia.dev/internal/models"
+)
+
+// the highlighted code has the classes of chroma instead of the inline styles, which the CSP blocks.
+// The classes are in /static/css/chroma.css, written with chromaFormatter.WriteCSS for the chromaStyle
+var chromaFormatter = chromahtml.New(chromahtml.WithClasses(true))
+
+var chromaStyle = styles.Get("github")
+
+// markdown leaves out the raw HTML of the content, the sanitizer removes anything else that slips through
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(highlighting.WithFormatOptions(chromahtml.WithClasses(true))),
+	),
+)
+
+// contentPolicy is the allowlist of the rendered content: the elements of the markdown without any images, scripts,
+// forms or event handlers. The links are limited to http, https and mailto
+var contentPolicy = newContentPolicy()
+
+func newContentPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote", "ul", "ol", "li",
+		"pre", "code", "span", "em", "strong", "del", "table", "thead", "tbody", "tr", "th", "td")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	// the classes of chroma, see chromaFormatter
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9 -]+$`)).OnElements("pre", "code", "span")
+
+	return p
+}
+
+// renderContent renders the content of the snippet in its format. Only the sanitized HTML is returned as template.HTML,
+// the plain content is left to the escaping of the template
+func renderContent(s models.Snippet) (template.HTML, error) {
+	var buf bytes.Buffer
+
+	switch s.Format {
+	case models.FormatMarkdown:
+		err := markdown.Convert([]byte(s.Content), &buf)
+		if err != nil {
+			return "", err
+		}
+	case models.FormatCode:
+		lexer := lexers.Analyse(s.Content)
+		if lexer == nil {
+			lexer = lexers.Fallback
+		}
+
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, s.Content)
+		if err != nil {
+			return "", err
+		}
+
+		err = chromaFormatter.Format(&buf, chromaStyle, iterator)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+
+	return template.HTML(contentPolicy.SanitizeBytes(buf.Bytes())), nil
+}
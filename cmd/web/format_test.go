@@ -0,0 +1,157 @@
+package main
+
+import (
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestRenderContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   models.Format
+		content  string
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:    "Plain",
+			format:  models.FormatPlain,
+			content: "<b>left to the template</b>",
+		},
+		{
+			name:    "Markdown",
+			format:  models.FormatMarkdown,
+			content: "# Notes\n\n*An old* **silent** pond\n\n- frog",
+			want:    []string{"<h1>Notes</h1>", "<em>An old</em>", "<strong>silent</strong>", "<li>frog</li>"},
+		},
+		{
+			name:     "Script",
+			format:   models.FormatMarkdown,
+			content:  "<script>alert('pond')</script>\n\ntext <script>alert('pond')</script>",
+			want:     []string{"<p>text alert(&#39;pond&#39;)</p>"}, // the text stays, not the tags
+			wantNone: []string{"<script"},
+		},
+		{
+			name:     "Event handler",
+			format:   models.FormatMarkdown,
+			content:  "<img src=x onerror=alert(1)>\n\n<p onclick=\"alert(1)\">pond</p>",
+			wantNone: []string{"onerror", "onclick", "<img"},
+		},
+		{
+			name:     "JavaScript link",
+			format:   models.FormatMarkdown,
+			content:  "[pond](javascript:alert(1)) [frog](JaVaScRiPt:alert(1)) <javascript:alert(1)>",
+			want:     []string{"<p>pond frog javascript:alert(1)</p>"}, // the text without the links
+			wantNone: []string{"href"},
+		},
+		{
+			name:     "Link",
+			format:   models.FormatMarkdown,
+			content:  "[pond](https://example.com/pond)",
+			want:     []string{`href="https://example.com/pond"`, `rel="nofollow noopener"`},
+			wantNone: []string{"<script"},
+		},
+		{
+			name:    "Fenced code",
+			format:  models.FormatMarkdown,
+			content: "```go\nfunc main() {}\n```",
+			want:    []string{`class="chroma"`, `<span class="kd">func</span>`},
+		},
+		{
+			name:     "Fenced script",
+			format:   models.FormatMarkdown,
+			content:  "```html\n<script>alert(1)</script>\n```",
+			want:     []string{`<span class="nt">script</span>`},
+			wantNone: []string{"<script"},
+		},
+		{
+			name:    "Code",
+			format:  models.FormatCode,
+			content: "package main\n\nfunc main() {}\n",
+			want:    []string{`<pre class="chroma">`, `<span class="line">`, `main</span>`},
+		},
+		{
+			name:     "Code with script",
+			format:   models.FormatCode,
+			content:  "<script>alert(1)</script>",
+			want:     []string{"&lt;script&gt;"},
+			wantNone: []string{"<script", "style="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := renderContent(models.Snippet{Format: tt.format, Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tt.want) == 0 && len(tt.wantNone) == 0 {
+				assert.Equal(t, string(html), "")
+			}
+
+			for _, want := range tt.want {
+				assert.StringContains(t, string(html), want)
+			}
+
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(string(html), unwanted) {
+					t.Errorf("got %q; want without %q", html, unwanted)
+				}
+			}
+		})
+	}
+}
+
+// TestContentPolicy checks the sanitizer on its own, the renderers already leave out most of the raw HTML
+func TestContentPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Script",
+			input: `<p>pond<script>alert(1)</script></p>`,
+			want:  `<p>pond</p>`,
+		},
+		{
+			name:  "Event handler",
+			input: `<span class="k" onmouseover="alert(1)">func</span>`,
+			want:  `<span class="k">func</span>`,
+		},
+		{
+			name:  "JavaScript link",
+			input: `<a href="javascript:alert(1)">pond</a>`,
+			want:  `pond`,
+		},
+		{
+			name:  "Encoded JavaScript link",
+			input: `<a href="&#106;avascript:alert(1)">pond</a>`,
+			want:  `pond`,
+		},
+		{
+			name:  "Style",
+			input: `<pre class="chroma" style="background: url(javascript:alert(1))">code</pre>`,
+			want:  `<pre class="chroma">code</pre>`,
+		},
+		{
+			name:  "Class injection",
+			input: `<span class="k&quot; onclick=&quot;alert(1)">func</span>`,
+			want:  `<span>func</span>`,
+		},
+		{
+			name:  "Iframe",
+			input: `<iframe src="https://example.com"></iframe><form action="/user/logout"><button>Go</button></form>`,
+			want:  `Go`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, contentPolicy.Sanitize(tt.input), tt.want)
+		})
+	}
+}
@@ -1,73 +1,1310 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/google/uuid"
+	"io"
 	"net/http"
+	"runtime/debug"
+	"slices"
 	"snippetbox.doichevkostia.dev/internal/models"
 	"snippetbox.doichevkostia.dev/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// queryTag returns the normalized tag of the ?tag= query that filters a listing, it is empty when the listing isn't
+// filtered
+func queryTag(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+}
+
 func (app *application) home(w http.ResponseWriter, r *http.Request) error {
-	snippets, err := app.snippets.Latest()
+	tag := queryTag(r)
+
+	snippets, err := app.snippets.Latest(r.Context(), tag)
+	if err != nil {
+		return err
+	}
+
+	mostStarred, err := app.stars.MostStarred(r.Context(), 7)
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Tag = tag
+	data.MostStarred = mostStarred
+
+	return app.render(w, r, http.StatusOK, "home.gohtml", data)
+}
+
+// pathSnippet returns the snippet with the id from the path. The private snippet of another user is not found,
+// so that its existence isn't revealed
+func (app *application) pathSnippet(r *http.Request) (models.Snippet, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return models.Snippet{}, NewBadRequestError("invalid UUID", nil)
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.Snippet{}, NewNotFoundError("No snippet with provided id", nil)
+		} else {
+			return models.Snippet{}, err
+		}
+	}
+
+	if !snippet.VisibleTo(app.authenticatedUserID(r)) {
+		return models.Snippet{}, NewNotFoundError("No snippet with provided id", nil)
+	}
+
+	return snippet, nil
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) error {
+	snippet, err := app.pathSnippet(r)
+	if err != nil {
+		return err
+	}
+
+	app.recordView(r, snippet)
+
+	return app.renderSnippet(w, r, http.StatusOK, snippet, commentForm{})
+}
+
+// recordView counts the view of the snippet in the background, the views of the owner are not counted
+func (app *application) recordView(r *http.Request, snippet models.Snippet) {
+	if snippet.UserID != app.authenticatedUserID(r) {
+		app.viewRecorder.record(r, snippet.ID)
+	}
+}
+
+// renderSnippet shows the snippet with its comments, the form is the comment form with the errors
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, status int, snippet models.Snippet, formData commentForm) error {
+	comments, err := app.comments.ForSnippet(r.Context(), snippet.ID)
+	if err != nil {
+		return err
+	}
+
+	content, err := renderContent(snippet)
+	if err != nil {
+		return err
+	}
+
+	author, err := app.users.Get(r.Context(), snippet.UserID)
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.User = author
+	data.Content = content
+	data.Comments = models.ThreadComments(comments)
+	data.Form = formData
+
+	// the form to add the snippet to one of the own collections
+	if data.IsAuthenticated {
+		data.Collections, err = app.collections.ForUser(r.Context(), data.UserID)
+		if err != nil {
+			return err
+		}
+
+		data.Starred, err = app.stars.Starred(r.Context(), data.UserID, snippet.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the owner sees the views
+	if data.IsAuthenticated && snippet.UserID == data.UserID {
+		stats, err := app.views.Stats(r.Context(), snippet.ID, viewChartDays)
+		if err != nil {
+			return err
+		}
+
+		chart := newViewChart(stats)
+		data.ViewChart = &chart
+	}
+
+	return app.render(w, r, status, "view.gohtml", data)
+}
+
+// snippetDownload responds with the files of the snippet as a zip archive
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) error {
+	snippet, err := app.pathSnippet(r)
+	if err != nil {
+		return err
+	}
+
+	if len(snippet.Files) == 0 {
+		return NewNotFoundError("The snippet has no files", nil)
+	}
+
+	app.recordView(r, snippet)
+
+	// the archive is built before the response, so that an error can still be written
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range snippet.Files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Filename,
+			Method:   zip.Deflate,
+			Modified: snippet.CreateTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(fw, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%s.zip"`, snippet.ID))
+	w.WriteHeader(http.StatusOK)
+
+	buf.WriteTo(w)
+	return nil
+}
+
+// snippetFork copies the snippet into the account of the user, the copy expires after the default expiry of the user
+func (app *application) snippetFork(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(r.PathValue("id"))
+
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	forkID, err := app.snippets.Fork(r.Context(), user.ID, id, user.DefaultExpires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No snippet with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "Snippet successfully forked!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", forkID), http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = snippetCreateForm{
+		Format:     models.FormatPlain,
+		Files:      []snippetFileForm{{Language: "text"}},
+		Expires:    user.DefaultExpires,
+		Visibility: models.VisibilityPublic,
+	}
+
+	return app.render(w, r, http.StatusOK, "create.gohtml", data)
+}
+
+type snippetCreateForm struct {
+	Title               string            `form:"title"`
+	Content             string            `form:"content"`
+	Format              models.Format     `form:"format"`
+	Tags                string            `form:"tags"`  // separated by commas or spaces
+	Files               []snippetFileForm `form:"files"` // files[0].filename, files[0].language, ...
+	Expires             int               `form:"expires"`
+	Visibility          models.Visibility `form:"visibility"`
+	validator.Validator `form:"-"`
+}
+
+type snippetFileForm struct {
+	Filename string `form:"filename"`
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// Formats are the options of the format of the content
+func (f snippetCreateForm) Formats() []models.Format {
+	return models.Formats
+}
+
+// Languages are the options of the language of a file
+func (f snippetCreateForm) Languages() []string {
+	return fileLanguages
+}
+
+const (
+	maxFiles          = 10
+	maxFilenameLength = 100
+)
+
+// fileLanguages are the languages of the files, "text" is the plain text
+var fileLanguages = []string{
+	"text", "c", "cpp", "csharp", "css", "dockerfile", "go", "html", "java", "javascript", "json",
+	"markdown", "python", "ruby", "rust", "shell", "sql", "toml", "typescript", "yaml",
+}
+
+// checkFiles drops the rows of the form left blank and validates the rest, the errors are under files[i].field
+func (f *snippetCreateForm) checkFiles() {
+	files := make([]snippetFileForm, 0, len(f.Files))
+	for _, file := range f.Files {
+		if validator.NotBlank(file.Filename) || validator.NotBlank(file.Content) {
+			file.Filename = strings.TrimSpace(file.Filename)
+			files = append(files, file)
+		}
+	}
+
+	f.Files = files
+
+	f.CheckField(validator.MaxCount(f.Files, maxFiles), "files", fmt.Sprintf("A snippet cannot have more than %d files", maxFiles))
+
+	seen := make(map[string]bool, len(f.Files))
+
+	for i, file := range f.Files {
+		key := func(field string) string {
+			return fmt.Sprintf("files[%d].%s", i, field)
+		}
+
+		f.CheckField(validator.NotBlank(file.Filename), key("filename"), "This field can't be blank")
+		f.CheckField(validator.MaxChars(file.Filename, maxFilenameLength), key("filename"), fmt.Sprintf("This field cannot be more than %d characters long", maxFilenameLength))
+		// the filenames are the paths in the zip download, so they can't point to a directory
+		f.CheckField(!strings.ContainsAny(file.Filename, `/\`) && file.Filename != "." && file.Filename != "..", key("filename"), "This field cannot contain / or \\")
+		f.CheckField(!seen[strings.ToLower(file.Filename)], key("filename"), "The files must have different names")
+		f.CheckField(validator.PermittedValue(file.Language, fileLanguages...), key("language"), "This field must be one of the listed languages")
+		f.CheckField(validator.NotBlank(file.Content), key("content"), "This field can't be blank")
+
+		seen[strings.ToLower(file.Filename)] = true
+	}
+}
+
+// snippetFiles converts the validated files of the form
+func (f *snippetCreateForm) snippetFiles() []models.SnippetFile {
+	files := make([]models.SnippetFile, len(f.Files))
+	for i, file := range f.Files {
+		files[i] = models.SnippetFile{
+			Filename: file.Filename,
+			Language: file.Language,
+			Content:  file.Content,
+		}
+	}
+
+	return files
+}
+
+const (
+	maxTags      = 5
+	maxTagLength = 30
+	// tagChars are allowed in the tags after the normalization, the tags are a part of the /tags/{tag} path
+	tagChars = "abcdefghijklmnopqrstuvwxyz0123456789-_+"
+)
+
+// parseTags splits the tags input, the result is normalized
+func parseTags(input string) []string {
+	return models.NormalizeTags(strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+}
+
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) error {
+	var formData snippetCreateForm
+	err := app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	formData.CheckField(validator.NotBlank(formData.Title), "title", "This field can't be blank")
+	formData.CheckField(validator.MaxChars(formData.Title, 100), "title", "This field cannot be more than 100 characters long")
+	formData.checkFiles()
+	// the content describes the files, a snippet without the files is only the content
+	if len(formData.Files) == 0 {
+		formData.CheckField(validator.NotBlank(formData.Content), "content", "This field can't be blank")
+	}
+	// the forms without the field are the plain text, the way the snippets were before the formats
+	formData.Format = cmp.Or(formData.Format, models.FormatPlain)
+	formData.CheckField(validator.PermittedValue(formData.Format, models.Formats...), "format", "This field must be plain, markdown or code")
+	formData.CheckField(validator.PermittedValue(formData.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365")
+	// the forms without the field are public, the way the snippets were before the visibility
+	formData.Visibility = cmp.Or(formData.Visibility, models.VisibilityPublic)
+	formData.CheckField(validator.PermittedValue(formData.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted, or private")
+
+	tags := parseTags(formData.Tags)
+
+	formData.CheckField(validator.MaxCount(tags, maxTags), "tags", fmt.Sprintf("This field cannot have more than %d tags", maxTags))
+	for _, tag := range tags {
+		formData.CheckField(validator.AllowedChars(tag, tagChars), "tags", "Tags can only contain letters, digits, -, _ and +")
+		formData.CheckField(validator.MaxChars(tag, maxTagLength), "tags", fmt.Sprintf("A tag cannot be more than %d characters long", maxTagLength))
+	}
+
+	if !formData.Valid() {
+		if len(formData.Files) == 0 {
+			formData.Files = append(formData.Files, snippetFileForm{Language: "text"})
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = formData
+		return app.render(w, r, http.StatusUnprocessableEntity, "create.gohtml", data)
+	}
+
+	id, err := app.snippets.Insert(r.Context(), app.authenticatedUserID(r), formData.Title, formData.Content, formData.Format, formData.Visibility, tags, formData.snippetFiles(), formData.Expires)
+
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "Snippet successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.String()), http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) error {
+	tag := strings.ToLower(strings.TrimSpace(r.PathValue("tag")))
+
+	snippets, err := app.snippets.Latest(r.Context(), tag)
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+
+	return app.render(w, r, http.StatusOK, "tag.gohtml", data)
+}
+
+// tagSearch is the autocomplete of the tags input, it responds with the most used tags that start with the prefix
+func (app *application) tagSearch(w http.ResponseWriter, r *http.Request) error {
+	tags, err := app.tags.Search(r.Context(), r.URL.Query().Get("prefix"), 10)
+	if err != nil {
+		return err
+	}
+
+	result := make([]map[string]any, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, map[string]any{
+			"name":  tag.Name,
+			"count": tag.Count,
+		})
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]any{
+		"tags": result,
+	})
+}
+
+type commentForm struct {
+	Content             string `form:"content"`
+	ParentID            string `form:"parentID"` // empty for a top level comment
+	validator.Validator `form:"-"`
+
+	// Target is the form on the page with the errors: "new", "reply:<comment id>" or "edit:<comment id>"
+	Target string `form:"-"`
+}
+
+const maxCommentLength = 2000
+
+func (f *commentForm) check() {
+	f.CheckField(validator.NotBlank(f.Content), "content", "This field can't be blank")
+	f.CheckField(validator.MaxChars(f.Content, maxCommentLength), "content", fmt.Sprintf("This field cannot be more than %d characters long", maxCommentLength))
+}
+
+func (app *application) decodeCommentForm(r *http.Request) (commentForm, error) {
+	var formData commentForm
+	err := app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return commentForm{}, NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return commentForm{}, err
+		}
+	}
+
+	formData.check()
+
+	return formData, nil
+}
+
+func (app *application) commentCreatePost(w http.ResponseWriter, r *http.Request) error {
+	snippet, err := app.pathSnippet(r)
+	if err != nil {
+		return err
+	}
+
+	formData, err := app.decodeCommentForm(r)
+	if err != nil {
+		return err
+	}
+
+	var parentID uuid.NullUUID
+	formData.Target = "new"
+
+	if formData.ParentID != "" {
+		parentID.UUID, err = uuid.Parse(formData.ParentID)
+		if err != nil {
+			return NewBadRequestError("invalid form", []FieldViolation{{Field: "parentID", Description: "invalid UUID"}})
+		}
+
+		parentID.Valid = true
+		formData.Target = "reply:" + formData.ParentID
+	}
+
+	if !formData.Valid() {
+		return app.renderSnippet(w, r, http.StatusUnprocessableEntity, snippet, formData)
+	}
+
+	id, err := app.comments.Insert(r.Context(), app.authenticatedUserID(r), snippet.ID, parentID, formData.Content)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No comment with provided parent id", nil)
+		} else if errors.Is(err, models.ErrCommentsDisabled) {
+			return NewApiError(ErrorFailedPrecondition, errors.New("the comments are turned off for this snippet"), nil)
+		} else {
+			return err
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s#comment-%s", snippet.ID, id), http.StatusSeeOther)
+	return nil
+}
+
+// pathComment returns the comment with the id from the path and its snippet
+func (app *application) pathComment(r *http.Request) (models.Comment, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return models.Comment{}, NewBadRequestError("invalid UUID", nil)
+	}
+
+	comment, err := app.comments.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.Comment{}, NewNotFoundError("No comment with provided id", nil)
+		} else {
+			return models.Comment{}, err
+		}
+	}
+
+	return comment, nil
+}
+
+func (app *application) commentEditPost(w http.ResponseWriter, r *http.Request) error {
+	comment, err := app.pathComment(r)
+	if err != nil {
+		return err
+	}
+
+	formData, err := app.decodeCommentForm(r)
+	if err != nil {
+		return err
+	}
+
+	formData.Target = "edit:" + comment.ID.String()
+
+	if !formData.Valid() {
+		snippet, err := app.snippets.Get(r.Context(), comment.SnippetID)
+		if err != nil {
+			return err
+		}
+
+		return app.renderSnippet(w, r, http.StatusUnprocessableEntity, snippet, formData)
+	}
+
+	err = app.comments.Update(r.Context(), app.authenticatedUserID(r), comment.ID, formData.Content)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No comment with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s#comment-%s", comment.SnippetID, comment.ID), http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) error {
+	comment, err := app.pathComment(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.comments.Delete(r.Context(), app.authenticatedUserID(r), comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No comment with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The comment was deleted")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", comment.SnippetID), http.StatusSeeOther)
+	return nil
+}
+
+// snippetStarPost stars the snippet or takes the star back. The form of the page is redirected back to the snippet,
+// the script gets the new state as JSON
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	starred, stars, err := app.stars.Toggle(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No snippet with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	if !acceptsHTML(r) {
+		return writeJSON(w, http.StatusOK, map[string]any{"starred": starred, "stars": stars})
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id), http.StatusSeeOther)
+	return nil
+}
+
+// userStars lists the snippets the user starred
+func (app *application) userStars(w http.ResponseWriter, r *http.Request) error {
+	tag := queryTag(r)
+
+	snippets, err := app.stars.ForUser(r.Context(), app.authenticatedUserID(r), tag)
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Tag = tag
+
+	return app.render(w, r, http.StatusOK, "stars.gohtml", data)
+}
+
+const profilePageSize = 10
+
+// userProfile shows the public profile with the non-expired snippets of the user. The profiles of the suspended users
+// are hidden as if they didn't exist
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.profileUser(r)
+	if err != nil {
+		return err
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			return NewBadRequestError("invalid page", nil)
+		}
+	}
+
+	tag := queryTag(r)
+
+	// one more than the page tells if there is a next one
+	snippets, err := app.snippets.ForUser(r.Context(), user.ID, tag, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Tag = tag
+	data.Pagination = pagination{Page: page}
+
+	if page > 1 {
+		data.Pagination.Prev = page - 1
+	}
+
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		data.Pagination.Next = page + 1
+	}
+
+	data.Snippets = snippets
+
+	return app.render(w, r, http.StatusOK, "profile.gohtml", data)
+}
+
+// userAvatar serves the avatar of the profile. The type of the image was detected on the upload
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.profileUser(r)
+	if err != nil {
+		return err
+	}
+
+	avatar, err := app.users.Avatar(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("The user has no avatar", nil)
+		} else {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", avatar.ContentType)
+	// the avatar can be replaced any time, the browsers revalidate it with the modification time
+	w.Header().Set("Cache-Control", "no-cache")
+
+	http.ServeContent(w, r, "", avatar.UpdateTime, bytes.NewReader(avatar.Data))
+	return nil
+}
+
+// profileUser is the user of the name in the path, the suspended users are not found
+func (app *application) profileUser(r *http.Request) (models.User, error) {
+	user, err := app.users.ByName(r.Context(), r.PathValue("name"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.User{}, NewNotFoundError("No user with provided name", nil)
+		} else {
+			return models.User{}, err
+		}
+	}
+
+	if user.Suspended {
+		return models.User{}, NewNotFoundError("No user with provided name", nil)
+	}
+
+	return user, nil
+}
+
+type snippetCommentsForm struct {
+	Enabled bool `form:"enabled"`
+}
+
+// snippetCommentsPost turns the comments of the own snippet on or off
+func (app *application) snippetCommentsPost(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	var formData snippetCommentsForm
+	err = app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	err = app.snippets.SetCommentsEnabled(r.Context(), app.authenticatedUserID(r), id, formData.Enabled)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No snippet with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	if formData.Enabled {
+		app.sessionManager.Put(r.Context(), "toast", "The comments were turned on")
+	} else {
+		app.sessionManager.Put(r.Context(), "toast", "The comments were turned off")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id), http.StatusSeeOther)
+	return nil
+}
+
+type collectionForm struct {
+	Name                string            `form:"name"`
+	Visibility          models.Visibility `form:"visibility"`
+	validator.Validator `form:"-"`
+}
+
+const duplicateCollectionMessage = "You already have a collection with this name"
+
+func (f *collectionForm) check() {
+	f.CheckField(validator.NotBlank(f.Name), "name", "This field can't be blank")
+	f.CheckField(validator.MaxChars(f.Name, 100), "name", "This field cannot be more than 100 characters long")
+	f.CheckField(validator.PermittedValue(f.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted, or private")
+}
+
+func (app *application) collectionList(w http.ResponseWriter, r *http.Request) error {
+	collections, err := app.collections.ForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+	data.Form = collectionForm{
+		Visibility: models.VisibilityPrivate,
+	}
+
+	return app.render(w, r, http.StatusOK, "collections.gohtml", data)
+}
+
+func (app *application) collectionCreatePost(w http.ResponseWriter, r *http.Request) error {
+	var formData collectionForm
+	err := app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	formData.check()
+
+	userID := app.authenticatedUserID(r)
+
+	var id uuid.UUID
+	if formData.Valid() {
+		id, err = app.collections.Insert(r.Context(), userID, formData.Name, formData.Visibility)
+		if errors.Is(err, models.ErrDuplicateName) {
+			formData.AddFieldError("name", duplicateCollectionMessage)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if !formData.Valid() {
+		collections, err := app.collections.ForUser(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+
+		data := app.newTemplateData(r)
+		data.Collections = collections
+		data.Form = formData
+		return app.render(w, r, http.StatusUnprocessableEntity, "collections.gohtml", data)
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "Collection successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%s", id), http.StatusSeeOther)
+	return nil
+}
+
+// ownCollection returns the collection from the path if it belongs to the authenticated user,
+// the collections of the others are not found
+func (app *application) ownCollection(r *http.Request) (models.Collection, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return models.Collection{}, NewBadRequestError("invalid UUID", nil)
+	}
+
+	collection, err := app.collections.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.Collection{}, NewNotFoundError("No collection with provided id", nil)
+		} else {
+			return models.Collection{}, err
+		}
+	}
+
+	if collection.UserID != app.authenticatedUserID(r) {
+		return models.Collection{}, NewNotFoundError("No collection with provided id", nil)
+	}
+
+	return collection, nil
+}
+
+// renderCollection shows the collection with its snippets, the form is the rename form of the owner.
+// The snippets are filtered by the tag in the query string, the private ones only by their owner
+func (app *application) renderCollection(w http.ResponseWriter, r *http.Request, status int, collection models.Collection, formData collectionForm) error {
+	tag := queryTag(r)
+
+	snippets, err := app.collections.Snippets(r.Context(), collection.ID, tag)
+	if err != nil {
+		return err
+	}
+
+	// a public collection can hold the unlisted snippets of other users, they aren't listed to everyone
+	userID := app.authenticatedUserID(r)
+	snippets = slices.DeleteFunc(snippets, func(s models.Snippet) bool {
+		return !s.ListedTo(userID)
+	})
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.Snippets = snippets
+	data.Tag = tag
+	data.Form = formData
+
+	return app.render(w, r, status, "collection.gohtml", data)
+}
+
+func (app *application) collectionView(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	collection, err := app.collections.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No collection with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	// a private collection is not found rather than forbidden, so that its existence isn't revealed
+	if !collection.VisibleTo(app.authenticatedUserID(r)) {
+		return NewNotFoundError("No collection with provided id", nil)
+	}
+
+	return app.renderCollection(w, r, http.StatusOK, collection, collectionForm{
+		Name:       collection.Name,
+		Visibility: collection.Visibility,
+	})
+}
+
+func (app *application) collectionRenamePost(w http.ResponseWriter, r *http.Request) error {
+	collection, err := app.ownCollection(r)
+	if err != nil {
+		return err
+	}
+
+	var formData collectionForm
+	err = app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	formData.check()
+
+	if formData.Valid() {
+		err = app.collections.Update(r.Context(), collection.UserID, collection.ID, formData.Name, formData.Visibility)
+		if errors.Is(err, models.ErrDuplicateName) {
+			formData.AddFieldError("name", duplicateCollectionMessage)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No collection with provided id", nil)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if !formData.Valid() {
+		return app.renderCollection(w, r, http.StatusUnprocessableEntity, collection, formData)
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The collection was renamed")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%s", collection.ID), http.StatusSeeOther)
+	return nil
+}
+
+type collectionReorderForm struct {
+	Positions map[string]int `form:"position"` // snippet id to its new position
+}
+
+func (app *application) collectionReorderPost(w http.ResponseWriter, r *http.Request) error {
+	collection, err := app.ownCollection(r)
+	if err != nil {
+		return err
+	}
+
+	var formData collectionReorderForm
+	err = app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	snippetIDs := make([]uuid.UUID, 0, len(formData.Positions))
+
+	for key := range formData.Positions {
+		snippetID, err := uuid.Parse(key)
+		if err != nil {
+			return NewBadRequestError("invalid UUID", nil)
+		}
+
+		snippetIDs = append(snippetIDs, snippetID)
+	}
+
+	// the same positions keep an arbitrary, but stable order
+	slices.SortFunc(snippetIDs, func(a, b uuid.UUID) int {
+		if c := cmp.Compare(formData.Positions[a.String()], formData.Positions[b.String()]); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.String(), b.String())
+	})
+
+	err = app.collections.Reorder(r.Context(), collection.UserID, collection.ID, snippetIDs)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No snippet with provided id in the collection", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The order was saved")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%s", collection.ID), http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) collectionDeletePost(w http.ResponseWriter, r *http.Request) error {
+	collection, err := app.ownCollection(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.collections.Delete(r.Context(), collection.UserID, collection.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No collection with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The collection was deleted, its snippets are kept")
+
+	http.Redirect(w, r, "/collections", http.StatusSeeOther)
+	return nil
+}
+
+type collectionSnippetAddForm struct {
+	CollectionID string `form:"collectionID"`
+}
+
+// collectionSnippetAddPost adds the snippet from the path to the collection chosen on the snippet page
+func (app *application) collectionSnippetAddPost(w http.ResponseWriter, r *http.Request) error {
+	snippetID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	var formData collectionSnippetAddForm
+	err = app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	collectionID, err := uuid.Parse(formData.CollectionID)
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	err = app.collections.AddSnippet(r.Context(), app.authenticatedUserID(r), collectionID, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No collection or snippet with provided id", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The snippet was added to the collection")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", snippetID), http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) collectionSnippetRemovePost(w http.ResponseWriter, r *http.Request) error {
+	collection, err := app.ownCollection(r)
+	if err != nil {
+		return err
+	}
+
+	snippetID, err := uuid.Parse(r.PathValue("snippetID"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	err = app.collections.RemoveSnippet(r.Context(), collection.UserID, collection.ID, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No snippet with provided id in the collection", nil)
+		} else {
+			return err
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "The snippet was removed from the collection")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%s", collection.ID), http.StatusSeeOther)
+	return nil
+}
+
+const breachedPasswordMessage = "This password is too common, choose another one"
+
+type userSignUpForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) error {
+	data := app.newTemplateData(r)
+	data.Form = userSignUpForm{}
+
+	return app.render(w, r, http.StatusOK, "signup.gohtml", data)
+}
+
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) error {
+	var formData userSignUpForm
+	err := app.decodePostForm(r, &formData)
+
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	formData.CheckField(validator.NotBlank(formData.Name), "name", "This field cannot be blank")
+	formData.CheckField(validator.NotBlank(formData.Email), "email", "This field cannot be blank")
+	formData.CheckField(validator.Matches(formData.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	formData.CheckField(validator.NotBlank(formData.Password), "password", "This field cannot be blank")
+	formData.CheckField(validator.MinChars(formData.Password, 8), "password", "This field must be at least 8 characters long")
+	formData.CheckField(!app.breachList.Contains(formData.Password), "password", breachedPasswordMessage)
+
+	if !formData.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = formData
+		return app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+	}
+
+	id, err := app.users.Insert(r.Context(), formData.Name, formData.Email, formData.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			formData.AddFieldError("email", "Email address is already in use")
+		} else if errors.Is(err, models.ErrDuplicateName) {
+			formData.AddFieldError("name", "Name is already in use")
+		} else {
+			return err
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = formData
+		return app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+	}
+
+	_, err = app.startUserSession(r, id)
+	if err != nil {
+		return err
+	}
+
+	app.metrics.sessionsStarted.WithLabelValues("signup").Inc()
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	return nil
+}
+
+type userLoginForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) error {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	return app.render(w, r, http.StatusOK, "login.gohtml", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) error {
+	var formData userLoginForm
+
+	err := app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		} else {
+			return err
+		}
+	}
+
+	formData.CheckField(validator.NotBlank(formData.Email), "email", "This field cannot be blank")
+	formData.CheckField(validator.Matches(formData.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	formData.CheckField(validator.NotBlank(formData.Password), "password", "This field cannot be blank")
+
+	if !formData.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = formData
+		return app.render(w, r, http.StatusUnprocessableEntity, "login.gohtml", data)
+	}
+
+	id, err := app.users.Authenticate(r.Context(), formData.Email, formData.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.metrics.logins.WithLabelValues("failure").Inc()
+
+			formData.AddGeneralError("Invalid credentials")
+			data := app.newTemplateData(r)
+			data.Form = formData
+			return app.render(w, r, http.StatusUnauthorized, "login.gohtml", data)
+		} else {
+			return err
+		}
+	}
+
+	sessionID, err := app.startUserSession(r, id)
 	if err != nil {
 		return err
 	}
 
-	app.sessionManager.Put(r.Context(), "TEST", "TEST")
+	app.metrics.logins.WithLabelValues("success").Inc()
+	app.metrics.sessionsStarted.WithLabelValues("password").Inc()
 
-	data := app.newTemplateData(r)
-	data.Snippets = snippets
+	if formData.RememberMe {
+		err = app.remember(w, r, id, sessionID)
+		if err != nil {
+			return err
+		}
+	}
 
-	app.render(w, r, http.StatusOK, "home.gohtml", data)
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 	return nil
 }
 
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) error {
-	id, err := uuid.Parse(r.PathValue("id"))
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) error {
+	err := app.endUserSession(w, r)
+	if err != nil {
+		return err
+	}
+
+	app.metrics.sessionsRevoked.WithLabelValues("logout").Inc()
+	app.sessionManager.Put(r.Context(), "toast", "Successful logout")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) error {
+	sessions, err := app.userSessions.ForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	data := app.newTemplateData(r)
+	data.UserSessions = sessions
+
+	return app.render(w, r, http.StatusOK, "sessions.gohtml", data)
+}
 
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		return NewBadRequestError("invalid UUID", nil)
 	}
 
-	snippet, err := app.snippets.Get(id)
+	if id == app.userSessionID(r) {
+		return app.userLogoutPost(w, r)
+	}
+
+	err = app.userSessions.Delete(r.Context(), app.authenticatedUserID(r), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			http.NotFound(w, r)
-			return NewNotFoundError("No snippet with provided id", nil)
+			return NewNotFoundError("No session with provided id", nil)
 		} else {
 			return err
 		}
 	}
 
-	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	err = app.rememberTokens.DeleteForSession(r.Context(), id)
+	if err != nil {
+		return err
+	}
 
-	app.render(w, r, http.StatusOK, "view.gohtml", data)
+	app.metrics.sessionsRevoked.WithLabelValues("user").Inc()
+	app.sessionManager.Put(r.Context(), "toast", "The device was logged out")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
 	return nil
 }
 
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) error {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
-		Expires: 365,
+func (app *application) accountSessionsRevokeAllPost(w http.ResponseWriter, r *http.Request) error {
+	// the current session is among the revoked ones, endUserSession finds no record of it left to delete
+	n, err := app.logoutEverywhere(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	err = app.endUserSession(w, r)
+	if err != nil {
+		return err
 	}
 
-	app.render(w, r, http.StatusOK, "create.gohtml", data)
+	app.metrics.sessionsRevoked.WithLabelValues("user").Add(float64(n))
+	app.sessionManager.Put(r.Context(), "toast", "You were logged out on all devices")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 	return nil
 }
 
-type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
-	validator.Validator `form:"-"`
+type accountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"currentPassword"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
 }
 
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) error {
-	var formData snippetCreateForm
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) error {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+	return app.render(w, r, http.StatusOK, "password.gohtml", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) error {
+	var formData accountPasswordUpdateForm
+
 	err := app.decodePostForm(r, &formData)
 	if err != nil {
 		var decodeErrors form.DecodeErrors
@@ -78,49 +1315,71 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	formData.CheckField(validator.NotBlank(formData.Title), "title", "This field can't be blank")
-	formData.CheckField(validator.MaxChars(formData.Title, 100), "title", "This field cannot be more than 100 characters long")
-	formData.CheckField(validator.NotBlank(formData.Content), "content", "This field can't be blank")
-	formData.CheckField(validator.PermittedValue(formData.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365")
+	formData.CheckField(validator.NotBlank(formData.CurrentPassword), "currentPassword", "This field cannot be blank")
+	formData.CheckField(validator.NotBlank(formData.NewPassword), "newPassword", "This field cannot be blank")
+	formData.CheckField(validator.MinChars(formData.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	formData.CheckField(!app.breachList.Contains(formData.NewPassword), "newPassword", breachedPasswordMessage)
+	formData.CheckField(formData.NewPassword == formData.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
 
 	if !formData.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = formData
-		app.render(w, r, http.StatusUnprocessableEntity, "create.gohtml", data)
-		return nil
+		return app.render(w, r, http.StatusUnprocessableEntity, "password.gohtml", data)
 	}
 
-	id, err := app.snippets.Insert(formData.Title, formData.Content, formData.Expires)
+	userID := app.authenticatedUserID(r)
+
+	err = app.users.UpdatePassword(r.Context(), userID, formData.CurrentPassword, formData.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			formData.AddFieldError("currentPassword", "Current password is incorrect")
+
+			data := app.newTemplateData(r)
+			data.Form = formData
+			return app.render(w, r, http.StatusUnprocessableEntity, "password.gohtml", data)
+		} else {
+			return err
+		}
+	}
 
+	// whoever knew the old password is logged out, only this session stays
+	n, err := app.logoutElsewhere(r.Context(), userID, app.userSessionID(r))
 	if err != nil {
 		return err
 	}
 
-	app.sessionManager.Put(r.Context(), "toast", "Snippet successfully created!")
+	app.metrics.sessionsRevoked.WithLabelValues("password_change").Add(float64(n))
+	clearRememberCookie(w)
 
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.String()), http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), "toast", "Your password has been updated, the other devices were logged out")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
 	return nil
 }
 
-type userSignUpForm struct {
-	Name                string `form:"name"`
-	Email               string `form:"email"`
-	Password            string `form:"password"`
+type accountPreferencesForm struct {
+	Expires             int `form:"expires"`
 	validator.Validator `form:"-"`
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) error {
+func (app *application) accountPreferences(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userSignUpForm{}
+	data.Form = accountPreferencesForm{
+		Expires: user.DefaultExpires,
+	}
 
-	app.render(w, r, http.StatusOK, "signup.gohtml", data)
-	return nil
+	return app.render(w, r, http.StatusOK, "preferences.gohtml", data)
 }
 
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) error {
-	var formData userSignUpForm
-	err := app.decodePostForm(r, &formData)
+func (app *application) accountPreferencesPost(w http.ResponseWriter, r *http.Request) error {
+	var formData accountPreferencesForm
 
+	err := app.decodePostForm(r, &formData)
 	if err != nil {
 		var decodeErrors form.DecodeErrors
 		if errors.As(err, &decodeErrors) {
@@ -130,121 +1389,353 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) e
 		}
 	}
 
-	formData.CheckField(validator.NotBlank(formData.Name), "name", "This field cannot be blank")
-	formData.CheckField(validator.NotBlank(formData.Email), "email", "This field cannot be blank")
-	formData.CheckField(validator.Matches(formData.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	formData.CheckField(validator.NotBlank(formData.Password), "password", "This field cannot be blank")
-	formData.CheckField(validator.MinChars(formData.Password, 8), "password", "This field must be at least 8 characters long")
+	formData.CheckField(validator.PermittedValue(formData.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365")
 
 	if !formData.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = formData
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
-		return nil
+		return app.render(w, r, http.StatusUnprocessableEntity, "preferences.gohtml", data)
 	}
 
-	id, err := app.users.Insert(formData.Name, formData.Email, formData.Password)
+	err = app.users.UpdateDefaultExpires(r.Context(), app.authenticatedUserID(r), formData.Expires)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			formData.AddFieldError("email", "Email address is already in use")
+		return err
+	}
 
-			data := app.newTemplateData(r)
-			data.Form = formData
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
-			return nil
+	app.sessionManager.Put(r.Context(), "toast", "Your preferences have been saved")
+
+	http.Redirect(w, r, "/account/preferences", http.StatusSeeOther)
+	return nil
+}
+
+const (
+	maxBioLength  = 500
+	maxAvatarSize = 256 << 10
+	// maxAvatarRequest leaves room for the rest of the multipart form, the larger requests are cut off before the CSRF check
+	maxAvatarRequest = maxAvatarSize + 16<<10
+)
+
+// avatarTypes are the detected types of the images accepted as the avatars. SVG is not one of them, it can carry scripts
+var avatarTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type accountProfileForm struct {
+	Bio                 string `form:"bio"`
+	validator.Validator `form:"-"`
+}
+
+// accountProfile edits the public profile, the bio and the avatar have separate forms
+func (app *application) accountProfile(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	return app.renderAccountProfile(w, r, http.StatusOK, user, accountProfileForm{Bio: user.Bio})
+}
+
+func (app *application) renderAccountProfile(w http.ResponseWriter, r *http.Request, status int, user models.User, formData accountProfileForm) error {
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = formData
+
+	return app.render(w, r, status, "profile-edit.gohtml", data)
+}
+
+func (app *application) accountProfilePost(w http.ResponseWriter, r *http.Request) error {
+	var formData accountProfileForm
+
+	err := app.decodePostForm(r, &formData)
+	if err != nil {
+		var decodeErrors form.DecodeErrors
+		if errors.As(err, &decodeErrors) {
+			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
 		} else {
 			return err
 		}
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	formData.Bio = strings.TrimSpace(formData.Bio)
+	formData.CheckField(validator.MaxChars(formData.Bio, maxBioLength), "bio", fmt.Sprintf("This field cannot be more than %d characters long", maxBioLength))
+
+	if !formData.Valid() {
+		user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			return err
+		}
+
+		return app.renderAccountProfile(w, r, http.StatusUnprocessableEntity, user, formData)
+	}
+
+	err = app.users.UpdateBio(r.Context(), app.authenticatedUserID(r), formData.Bio)
 	if err != nil {
 		return err
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id.String())
+	app.sessionManager.Put(r.Context(), "toast", "Your profile has been saved")
 
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+	return nil
+}
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+// accountAvatarPost replaces the avatar with the uploaded image, the type is detected from the content
+func (app *application) accountAvatarPost(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	formData := accountProfileForm{Bio: user.Bio}
 
+	image, contentType, err := readAvatar(r)
+	if err != nil {
+		var violation avatarError
+		if errors.As(err, &violation) {
+			formData.AddFieldError("avatar", string(violation))
+			return app.renderAccountProfile(w, r, http.StatusUnprocessableEntity, user, formData)
+		} else {
+			return err
+		}
+	}
+
+	err = app.users.SetAvatar(r.Context(), user.ID, contentType, image)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "Your avatar has been updated")
+
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
 	return nil
 }
 
-type userLoginForm struct {
-	Email               string `form:"email"`
-	Password            string `form:"password"`
-	validator.Validator `form:"-"`
+// avatarError is the message of an invalid upload for the form
+type avatarError string
+
+func (e avatarError) Error() string {
+	return string(e)
 }
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) error {
-	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, r, http.StatusOK, "login.gohtml", data)
+// readAvatar reads the image of the "avatar" field of the multipart form
+func readAvatar(r *http.Request) ([]byte, string, error) {
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+			return nil, "", avatarError("Choose an image")
+		} else {
+			return nil, "", err
+		}
+	}
+
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(image) > maxAvatarSize {
+		return nil, "", avatarError(fmt.Sprintf("The image cannot be larger than %d KB", maxAvatarSize>>10))
+	}
+
+	contentType := http.DetectContentType(image)
+	if !slices.Contains(avatarTypes, contentType) {
+		return nil, "", avatarError("The image must be a PNG, JPEG, GIF or WebP")
+	}
+
+	return image, contentType, nil
+}
+
+func (app *application) accountAvatarDeletePost(w http.ResponseWriter, r *http.Request) error {
+	err := app.users.DeleteAvatar(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "toast", "Your avatar has been removed")
+
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
 	return nil
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) error {
-	var formData userLoginForm
+func (app *application) adminUserSessions(w http.ResponseWriter, r *http.Request) error {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
 
-	err := app.decodePostForm(r, &formData)
+	user, err := app.users.Get(r.Context(), userID)
 	if err != nil {
-		var decodeErrors form.DecodeErrors
-		if errors.As(err, &decodeErrors) {
-			return NewBadRequestError("invalid form", FormErrorsToFieldViolation(decodeErrors))
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No user with provided id", nil)
 		} else {
 			return err
 		}
 	}
 
-	formData.CheckField(validator.NotBlank(formData.Email), "email", "This field cannot be blank")
-	formData.CheckField(validator.Matches(formData.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	formData.CheckField(validator.NotBlank(formData.Password), "password", "This field cannot be blank")
+	sessions, err := app.userSessions.ForUser(r.Context(), userID)
+	if err != nil {
+		return err
+	}
 
-	if !formData.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = formData
-		app.render(w, r, http.StatusUnprocessableEntity, "login.gohtml", data)
-		return nil
+	data := app.newTemplateData(r)
+	data.User = user
+	data.UserSessions = sessions
+
+	return app.render(w, r, http.StatusOK, "admin-sessions.gohtml", data)
+}
+
+func (app *application) adminUserSessionRevokePost(w http.ResponseWriter, r *http.Request) error {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
 	}
 
-	id, err := app.users.Authenticate(formData.Email, formData.Password)
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			formData.AddGeneralError("Invalid credentials")
-			data := app.newTemplateData(r)
-			data.Form = formData
-			app.render(w, r, http.StatusUnauthorized, "login.gohtml", data)
-			return nil
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	err = app.userSessions.Delete(r.Context(), userID, sessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return NewNotFoundError("No session with provided id", nil)
 		} else {
 			return err
 		}
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	err = app.rememberTokens.DeleteForSession(r.Context(), sessionID)
 	if err != nil {
 		return err
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id.String())
+	app.metrics.sessionsRevoked.WithLabelValues("admin").Inc()
 
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	app.logger.InfoContext(r.Context(), "admin forced a logout", "admin", app.authenticatedUserID(r), "user", userID, "session", sessionID)
+	app.sessionManager.Put(r.Context(), "toast", "The device was logged out")
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%s/sessions", userID), http.StatusSeeOther)
 	return nil
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) error {
-	err := app.sessionManager.RenewToken(r.Context())
+func (app *application) adminUserSessionsRevokeAllPost(w http.ResponseWriter, r *http.Request) error {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return NewBadRequestError("invalid UUID", nil)
+	}
+
+	n, err := app.logoutEverywhere(r.Context(), userID)
 	if err != nil {
 		return err
 	}
 
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "toast", "Successful logout")
+	app.metrics.sessionsRevoked.WithLabelValues("admin").Add(float64(n))
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	app.logger.InfoContext(r.Context(), "admin forced a logout on all devices", "admin", app.authenticatedUserID(r), "user", userID)
+	app.sessionManager.Put(r.Context(), "toast", "The user was logged out on all devices")
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%s/sessions", userID), http.StatusSeeOther)
 	return nil
 }
 
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) error {
+	return NewNotFoundError("", nil)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("pong"))
 }
+
+// healthz tells that the process is alive. It doesn't check the dependencies,
+// a restart wouldn't help when the database is down
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+const readinessTimeout = 2 * time.Second
+
+// readyz tells whether the instance can serve the traffic. The errors are only logged,
+// the endpoint is public and the driver errors can tell too much about the database
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"status": "shutting down",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{
+		"database": "ok",
+		"schema":   "ok",
+	}
+	status := http.StatusOK
+
+	err := app.health.Ping(ctx)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "readiness check failed", "check", "database", "error", err.Error())
+		checks["database"] = "unavailable"
+		checks["schema"] = "unknown"
+		status = http.StatusServiceUnavailable
+	} else {
+		version, err := app.health.SchemaVersion(ctx)
+		if err != nil {
+			app.logger.WarnContext(r.Context(), "readiness check failed", "check", "schema", "error", err.Error())
+			checks["schema"] = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else if version != models.SchemaVersion {
+			checks["schema"] = fmt.Sprintf("version %d, expected %d", version, models.SchemaVersion)
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	result := "ok"
+	if status != http.StatusOK {
+		result = "unavailable"
+	}
+
+	writeJSON(w, status, map[string]any{
+		"status": result,
+		"checks": checks,
+	})
+}
+
+// buildTime can be set with -ldflags "-X main.buildTime=...", otherwise the time of the VCS commit is reported
+var buildTime string
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Modified  bool   `json:"modified"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+func version(w http.ResponseWriter, r *http.Request) {
+	info := versionInfo{
+		Version:   "unknown",
+		BuildTime: buildTime,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Version = bi.Main.Version
+		info.GoVersion = bi.GoVersion
+
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
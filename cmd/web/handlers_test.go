@@ -1,12 +1,26 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
 	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/models/memory"
 	"snippetbox.doichevkostia.dev/internal/models/mocks"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestPing(t *testing.T) {
@@ -22,6 +36,118 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, string(body), "pong")
 }
 
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	app.health = &mocks.HealthModel{PingErr: errors.New("database is locked")}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// liveness doesn't depend on the database
+	code, _, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		health       *mocks.HealthModel
+		shuttingDown bool
+		wantCode     int
+		wantBody     string
+	}{
+		{
+			name:     "Ready",
+			health:   &mocks.HealthModel{},
+			wantCode: http.StatusOK,
+			wantBody: `"status":"ok"`,
+		},
+		{
+			name:     "Database unavailable",
+			health:   &mocks.HealthModel{PingErr: errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `"database":"unavailable"`,
+		},
+		{
+			name:     "Schema version unavailable",
+			health:   &mocks.HealthModel{VersionErr: errors.New(`pq: relation "schema_version" does not exist`)},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `"schema":"unavailable"`,
+		},
+		{
+			name:     "Schema mismatch",
+			health:   &mocks.HealthModel{Version: models.SchemaVersion + 1},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: fmt.Sprintf(`"schema":"version %d, expected %d"`, models.SchemaVersion+1, models.SchemaVersion),
+		},
+		{
+			name:         "Shutting down",
+			health:       &mocks.HealthModel{},
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantBody:     `"status":"shutting down"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.health = tt.health
+			app.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+			// the driver errors are only logged
+			assert.Equal(t, strings.Contains(body, "10.0.0.5") || strings.Contains(body, "relation"), false)
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/version")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+	var info versionInfo
+	err := json.Unmarshal([]byte(body), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, info.GoVersion, runtime.Version())
+}
+
+func TestHome(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/")
+
+	assert.Equal(t, code, http.StatusOK)
+
+	// an anonymous visit doesn't start a session
+	for _, cookie := range header.Values("Set-Cookie") {
+		if strings.HasPrefix(cookie, app.sessionManager.Cookie.Name+"=") {
+			t.Errorf("unexpected session cookie %q", cookie)
+		}
+	}
+}
+
 func TestSnippetView(t *testing.T) {
 	app := newTestApplication(t)
 
@@ -40,6 +166,12 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name:     "Files",
+			urlPath:  fmt.Sprintf("/snippet/view/%s", mocks.SnippetID),
+			wantCode: http.StatusOK,
+			wantBody: "A frog jumps into the pond",
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  fmt.Sprintf("/snippet/view/%s", uuid.New()),
@@ -65,6 +197,11 @@ func TestSnippetView(t *testing.T) {
 			urlPath:  "/snippet/view/",
 			wantCode: http.StatusNotFound,
 		},
+		{
+			name:     "Private ID",
+			urlPath:  fmt.Sprintf("/snippet/view/%s", mocks.PrivateSnippetID),
+			wantCode: http.StatusNotFound,
+		},
 	}
 
 	for _, tt := range tests {
@@ -78,4 +215,1827 @@ func TestSnippetView(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Private ID of the owner", func(t *testing.T) {
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		code, _, body := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.PrivateSnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Dear diary...")
+	})
+
+	t.Run("Private ID of another user", func(t *testing.T) {
+		ts.login(t, "admin@example.com", "pa$$word")
+
+		code, _, _ := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.PrivateSnippetID))
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestCollections(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	viewPath := fmt.Sprintf("/collection/view/%s", mocks.CollectionID)
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/collections")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		// the collection is private
+		code, _, _ = ts.get(t, viewPath)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("List", func(t *testing.T) {
+		code, _, body := ts.get(t, "/collections")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Haiku")
+	})
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := ts.get(t, viewPath)
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "An old silent pond")
+		assert.StringContains(t, body, "Save order")
+	})
+
+	t.Run("Add to collection form", func(t *testing.T) {
+		code, _, body := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Add to collection")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "Create blank name",
+			urlPath:  "/collection/create",
+			form:     url.Values{"name": {" "}, "visibility": {"private"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name:     "Create unknown visibility",
+			urlPath:  "/collection/create",
+			form:     url.Values{"name": {"Go"}, "visibility": {"secret"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal public, unlisted, or private",
+		},
+		{
+			name:     "Create duplicate name",
+			urlPath:  "/collection/create",
+			form:     url.Values{"name": {"Dupe"}, "visibility": {"private"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: duplicateCollectionMessage,
+		},
+		{
+			name:     "Create",
+			urlPath:  "/collection/create",
+			form:     url.Values{"name": {"Go"}, "visibility": {"public"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Rename to duplicate name",
+			urlPath:  fmt.Sprintf("/collection/%s/rename", mocks.CollectionID),
+			form:     url.Values{"name": {"Dupe"}, "visibility": {"private"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: duplicateCollectionMessage,
+		},
+		{
+			name:         "Rename",
+			urlPath:      fmt.Sprintf("/collection/%s/rename", mocks.CollectionID),
+			form:         url.Values{"name": {"Poems"}, "visibility": {"unlisted"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: viewPath,
+		},
+		{
+			name:     "Rename someone else's collection",
+			urlPath:  fmt.Sprintf("/collection/%s/rename", uuid.New()),
+			form:     url.Values{"name": {"Poems"}, "visibility": {"private"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Reorder",
+			urlPath:      fmt.Sprintf("/collection/%s/reorder", mocks.CollectionID),
+			form:         url.Values{fmt.Sprintf("position[%s]", mocks.SnippetID): {"1"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: viewPath,
+		},
+		{
+			name:     "Reorder snippet not in the collection",
+			urlPath:  fmt.Sprintf("/collection/%s/reorder", mocks.CollectionID),
+			form:     url.Values{fmt.Sprintf("position[%s]", uuid.New()): {"1"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Reorder invalid snippet id",
+			urlPath:  fmt.Sprintf("/collection/%s/reorder", mocks.CollectionID),
+			form:     url.Values{"position[foo]": {"1"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Add snippet",
+			urlPath:      fmt.Sprintf("/snippet/collections/%s", mocks.SnippetID),
+			form:         url.Values{"collectionID": {mocks.CollectionID.String()}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: fmt.Sprintf("/snippet/view/%s", mocks.SnippetID),
+		},
+		{
+			name:     "Add snippet to someone else's collection",
+			urlPath:  fmt.Sprintf("/snippet/collections/%s", mocks.SnippetID),
+			form:     url.Values{"collectionID": {uuid.NewString()}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Remove snippet",
+			urlPath:      fmt.Sprintf("/collection/%s/snippets/%s/remove", mocks.CollectionID, mocks.SnippetID),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: viewPath,
+		},
+		{
+			name:         "Delete",
+			urlPath:      fmt.Sprintf("/collection/%s/delete", mocks.CollectionID),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/collections",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			for key, values := range tt.form {
+				form[key] = values
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCollectionListsOnlyPublicSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	snippets := memory.NewSnippetModel()
+	collections := memory.NewCollectionModel(snippets)
+	app.snippets = snippets
+	app.collections = collections
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	insert := func(userID uuid.UUID, title string, visibility models.Visibility) uuid.UUID {
+		id, err := snippets.Insert(ctx, userID, title, "content", models.FormatPlain, visibility, nil, nil, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	collectionID, err := collections.Insert(ctx, mocks.UserID, "Poems", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, snippetID := range []uuid.UUID{
+		insert(mocks.UserID, "Public poem", models.VisibilityPublic),
+		insert(mocks.UserID, "Unlisted poem of alice", models.VisibilityUnlisted),
+		insert(mocks.AdminID, "Unlisted poem of admin", models.VisibilityUnlisted),
+	} {
+		err = collections.AddSnippet(ctx, mocks.UserID, collectionID, snippetID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viewPath := fmt.Sprintf("/collection/view/%s", collectionID)
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, _, body := ts.get(t, viewPath)
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Public poem")
+		assert.Equal(t, strings.Contains(body, "Unlisted poem"), false)
+	})
+
+	t.Run("Owner of a snippet", func(t *testing.T) {
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		code, _, body := ts.get(t, viewPath)
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Public poem")
+		assert.StringContains(t, body, "Unlisted poem of alice")
+		assert.Equal(t, strings.Contains(body, "Unlisted poem of admin"), false)
+	})
+}
+
+func TestTags(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Tag page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/tags/Haiku")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Snippets tagged haiku")
+		assert.StringContains(t, body, "An old silent pond")
+	})
+
+	t.Run("Unknown tag", func(t *testing.T) {
+		code, _, body := ts.get(t, "/tags/unknown")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There are no snippets with this tag.")
+	})
+
+	t.Run("Autocomplete", func(t *testing.T) {
+		code, _, body := ts.get(t, "/tags?prefix=ha")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, body, `{"tags":[{"count":1,"name":"haiku"}]}`)
+	})
+
+	t.Run("Autocomplete without matches", func(t *testing.T) {
+		code, _, body := ts.get(t, "/tags?prefix=zzz")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, body, `{"tags":[]}`)
+	})
+
+	t.Run("Home filtered by tag", func(t *testing.T) {
+		code, _, body := ts.get(t, "/?tag=Haiku")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Tagged haiku")
+		assert.StringContains(t, body, "An old silent pond")
+
+		code, _, body = ts.get(t, "/?tag=unknown")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There's nothing to see here... yet!")
+	})
+
+	t.Run("Collection filtered by tag", func(t *testing.T) {
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		code, _, body := ts.get(t, fmt.Sprintf("/collection/view/%s?tag=unknown", mocks.CollectionID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "There are no snippets in this collection.")
+	})
+}
+
+func TestSnippetCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		form     url.Values // overrides the fields of a valid form
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			form:     url.Values{"tags": {"Go, http  go"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "No tags",
+			form:     url.Values{"tags": {""}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Too many tags",
+			form:     url.Values{"tags": {"a b c d e f"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot have more than 5 tags",
+		},
+		{
+			name:     "Tag with not allowed characters",
+			form:     url.Values{"tags": {"c#"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Tags can only contain letters, digits, -, _ and &#43;",
+		},
+		{
+			name:     "Too long tag",
+			form:     url.Values{"tags": {strings.Repeat("a", 31)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "A tag cannot be more than 30 characters long",
+		},
+		{
+			name:     "Markdown",
+			form:     url.Values{"format": {"markdown"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Unknown format",
+			form:     url.Values{"format": {"html"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be plain, markdown or code",
+		},
+		{
+			name:     "Private",
+			form:     url.Values{"visibility": {"private"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Unknown visibility",
+			form:     url.Values{"visibility": {"secret"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal public, unlisted, or private",
+		},
+		{
+			name:     "Blank content",
+			form:     url.Values{"content": {""}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name: "Files without content",
+			form: url.Values{
+				"content":           {""},
+				"files[0].filename": {"Dockerfile"},
+				"files[0].language": {"dockerfile"},
+				"files[0].content":  {"FROM golang"},
+				"files[1].filename": {"compose.yaml"},
+				"files[1].language": {"yaml"},
+				"files[1].content":  {"services:"},
+				"files[2].filename": {""},
+				"files[2].language": {"text"},
+				"files[2].content":  {""},
+			},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name: "File without name",
+			form: url.Values{
+				"files[0].language": {"go"},
+				"files[0].content":  {"package main"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name: "File with a path",
+			form: url.Values{
+				"files[0].filename": {"../main.go"},
+				"files[0].language": {"go"},
+				"files[0].content":  {"package main"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot contain / or \\",
+		},
+		{
+			name: "Files with the same name",
+			form: url.Values{
+				"files[0].filename": {"main.go"},
+				"files[0].language": {"go"},
+				"files[0].content":  {"package main"},
+				"files[1].filename": {"MAIN.go"},
+				"files[1].language": {"go"},
+				"files[1].content":  {"package main"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The files must have different names",
+		},
+		{
+			name: "File with unknown language",
+			form: url.Values{
+				"files[0].filename": {"main.cob"},
+				"files[0].language": {"cobol"},
+				"files[0].content":  {"DISPLAY"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be one of the listed languages",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"title":      {"An old silent pond"},
+				"content":    {"An old silent pond..."},
+				"expires":    {"7"},
+				"csrf_token": {csrfToken},
+			}
+			for key, values := range tt.form {
+				form[key] = values
+			}
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetDownload(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Zip", func(t *testing.T) {
+		code, header, body := ts.get(t, fmt.Sprintf("/snippet/download/%s", mocks.SnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/zip")
+
+		zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(zr.File), 1)
+		assert.Equal(t, zr.File[0].Name, "frog.txt")
+
+		f, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, string(content), "A frog jumps into the pond")
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.get(t, fmt.Sprintf("/snippet/download/%s", uuid.New()))
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	forkPath := fmt.Sprintf("/snippet/fork/%s", mocks.SnippetID)
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, forkPath)
+		assert.StringContains(t, body, "Forks: 0")
+	})
+
+	t.Run("Fork", func(t *testing.T) {
+		code, header, _ := ts.postForm(t, forkPath, url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.StringContains(t, header.Get("Location"), "/snippet/view/")
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, fmt.Sprintf("/snippet/fork/%s", uuid.New()), url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Private ID of the owner", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, fmt.Sprintf("/snippet/fork/%s", mocks.PrivateSnippetID), url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Private ID of another user", func(t *testing.T) {
+		csrfToken := ts.login(t, "admin@example.com", "pa$$word")
+
+		code, _, _ := ts.postForm(t, fmt.Sprintf("/snippet/fork/%s", mocks.PrivateSnippetID), url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestStars(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	starPath := fmt.Sprintf("/snippet/star/%s", mocks.SnippetID)
+
+	t.Run("Home", func(t *testing.T) {
+		code, _, body := ts.get(t, "/")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Most Starred This Week")
+		assert.StringContains(t, body, "<td>2</td>")
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/user/stars")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Stars: <span class='stars'>2</span>")
+		assert.StringContains(t, body, "<button>Star</button>")
+	})
+
+	t.Run("Fetch", func(t *testing.T) {
+		code, _, body := ts.postForm(t, starPath, url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, body, `{"starred":true,"stars":3}`)
+	})
+
+	t.Run("Form", func(t *testing.T) {
+		r, err := http.NewRequest(http.MethodPost, ts.URL+starPath, strings.NewReader(url.Values{"csrf_token": {csrfToken}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+
+		rs, err := ts.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer rs.Body.Close()
+
+		assert.Equal(t, rs.StatusCode, http.StatusSeeOther)
+		assert.Equal(t, rs.Header.Get("Location"), fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, fmt.Sprintf("/snippet/star/%s", uuid.New()), url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("No stars", func(t *testing.T) {
+		code, _, body := ts.get(t, "/user/stars")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "You haven't starred any snippets yet.")
+	})
+}
+
+func TestUserStars(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "admin@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/user/stars")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "An old silent pond")
+
+	code, _, body = ts.get(t, "/user/stars?tag=unknown")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Tagged unknown")
+	assert.StringContains(t, body, "You haven't starred any snippets yet.")
+
+	code, _, body = ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<button>Unstar</button>")
+}
+
+func TestSnippetViews(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	viewPath := fmt.Sprintf("/snippet/view/%s", mocks.SnippetID)
+
+	code, _, body := ts.get(t, viewPath)
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, "<svg"), false)
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body = ts.get(t, viewPath)
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Views: 3")
+	assert.StringContains(t, body, "<svg viewBox='0 0 600 100'")
+	assert.StringContains(t, body, "<rect x='580' y='0' width='18' height='100'>")
+}
+
+func TestAccountPreferences(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("Default expiry", func(t *testing.T) {
+		for _, urlPath := range []string{"/account/preferences", "/snippet/create"} {
+			code, _, body := ts.get(t, urlPath)
+
+			assert.Equal(t, code, http.StatusOK)
+			assert.StringContains(t, body, "checked> One Week")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		expires  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			expires:  "365",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Not permitted",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal 1, 7, or 365",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"expires":    {tt.expires},
+				"csrf_token": {csrfToken},
+			}
+
+			code, _, body := ts.postForm(t, "/account/preferences", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "Valid",
+			urlPath:  "/u/Alice",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<h2>Alice</h2>", "Writes haiku", "src='/u/Alice/avatar'", "An old silent pond"},
+		},
+		{
+			name:     "Case",
+			urlPath:  "/u/aLICE",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<h2>Alice</h2>"},
+		},
+		{
+			name:     "Next page",
+			urlPath:  "/u/Alice?page=2",
+			wantCode: http.StatusOK,
+			wantBody: []string{"There are no snippets here.", "<a href='?page=1'>Newer</a>"},
+		},
+		{
+			name:     "Tagged",
+			urlPath:  "/u/Alice?tag=haiku",
+			wantCode: http.StatusOK,
+			wantBody: []string{"Tagged haiku", "An old silent pond"},
+		},
+		{
+			name:     "Unknown tag",
+			urlPath:  "/u/Alice?tag=unknown",
+			wantCode: http.StatusOK,
+			wantBody: []string{"There are no snippets here."},
+		},
+		{
+			name:     "Next page with tag",
+			urlPath:  "/u/Alice?page=2&tag=haiku",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<a href='?page=1&tag=haiku'>Newer</a>"},
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/u/Alice?page=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Unknown",
+			urlPath:  "/u/Bob",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Suspended",
+			urlPath:  "/u/Mallory",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Avatar of a suspended user",
+			urlPath:  "/u/Mallory/avatar",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "No avatar",
+			urlPath:  "/u/Admin/avatar",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+
+	t.Run("Avatar", func(t *testing.T) {
+		code, header, body := ts.get(t, "/u/Alice/avatar")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "image/png")
+		assert.Equal(t, body, string(mocks.AvatarPNG))
+	})
+
+	t.Run("Author links", func(t *testing.T) {
+		code, _, body := ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "By <a href='/u/Alice'>Alice</a>")
+		assert.StringContains(t, body, "<a href='/u/Admin'>Admin</a>")
+	})
+}
+
+func TestProfilePath(t *testing.T) {
+	assert.Equal(t, profilePath("Alice"), "/u/Alice")
+	assert.Equal(t, profilePath("a/b?c#d e"), "/u/a%2Fb%3Fc%23d%20e")
+}
+
+func TestAccountProfile(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/profile")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Writes haiku</textarea>")
+
+	t.Run("Bio", func(t *testing.T) {
+		form := url.Values{"bio": {"Writes tanka"}, "csrf_token": {csrfToken}}
+		code, _, _ := ts.postForm(t, "/account/profile", form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		form = url.Values{"bio": {strings.Repeat("a", maxBioLength+1)}, "csrf_token": {csrfToken}}
+		code, _, body := ts.postForm(t, "/account/profile", form)
+
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "This field cannot be more than 500 characters long")
+	})
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			data:     png,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Not an image",
+			data:     []byte("<svg xmlns='http://www.w3.org/2000/svg'><script>alert(1)</script></svg>"),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The image must be a PNG, JPEG, GIF or WebP",
+		},
+		{
+			name:     "Too large",
+			data:     append(png, make([]byte, maxAvatarSize)...),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The image cannot be larger than 256 KB",
+		},
+		{
+			// the body is cut off before the CSRF check
+			name:     "Too large request",
+			data:     append(png, make([]byte, maxAvatarRequest)...),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.postFile(t, "/account/avatar", url.Values{"csrf_token": {csrfToken}}, "avatar", "avatar.png", tt.data)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, "/account/avatar/delete", url.Values{"csrf_token": {csrfToken}})
+
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestComments(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	viewPath := fmt.Sprintf("/snippet/view/%s", mocks.SnippetID)
+	commentsPath := fmt.Sprintf("/snippet/comments/%s", mocks.SnippetID)
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, _, body := ts.get(t, viewPath)
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "A frog jumps in")
+		assert.StringContains(t, body, "to comment.")
+	})
+
+	t.Run("Escaped", func(t *testing.T) {
+		_, _, body := ts.get(t, viewPath)
+
+		assert.StringContains(t, body, "&lt;script&gt;alert(&#39;pond&#39;)&lt;/script&gt;")
+
+		if strings.Contains(body, "<script>alert") {
+			t.Error("the comment is not escaped")
+		}
+	})
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("Owner", func(t *testing.T) {
+		_, _, body := ts.get(t, viewPath)
+
+		assert.StringContains(t, body, "Turn off comments")
+		assert.StringContains(t, body, fmt.Sprintf("/comment/%s/edit", mocks.CommentID))
+		// the owner of the snippet deletes the comments of the others, but doesn't edit them
+		assert.StringContains(t, body, fmt.Sprintf("/comment/%s/delete", mocks.OtherCommentID))
+
+		if strings.Contains(body, fmt.Sprintf("/comment/%s/edit", mocks.OtherCommentID)) {
+			t.Error("the comment of another user can be edited")
+		}
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "Create",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {"An old silent pond"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Create blank",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {" "}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name:     "Create too long",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {strings.Repeat("a", 2001)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 2000 characters long",
+		},
+		{
+			name:     "Create on non-existent snippet",
+			urlPath:  fmt.Sprintf("/snippet/comments/%s", uuid.New()),
+			form:     url.Values{"content": {"Hello"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Reply",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {"Hello"}, "parentID": {mocks.CommentID.String()}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Reply blank",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {""}, "parentID": {mocks.CommentID.String()}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name:     "Reply to invalid parent",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {"Hello"}, "parentID": {"foo"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Reply to non-existent parent",
+			urlPath:  commentsPath,
+			form:     url.Values{"content": {"Hello"}, "parentID": {uuid.NewString()}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Edit",
+			urlPath:      fmt.Sprintf("/comment/%s/edit", mocks.CommentID),
+			form:         url.Values{"content": {"Changed"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: fmt.Sprintf("%s#comment-%s", viewPath, mocks.CommentID),
+		},
+		{
+			name:     "Edit blank",
+			urlPath:  fmt.Sprintf("/comment/%s/edit", mocks.CommentID),
+			form:     url.Values{"content": {""}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank",
+		},
+		{
+			name:     "Edit comment of another user",
+			urlPath:  fmt.Sprintf("/comment/%s/edit", mocks.OtherCommentID),
+			form:     url.Values{"content": {"Changed"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Delete comment on own snippet",
+			urlPath:      fmt.Sprintf("/comment/%s/delete", mocks.OtherCommentID),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: viewPath,
+		},
+		{
+			name:     "Delete non-existent comment",
+			urlPath:  fmt.Sprintf("/comment/%s/delete", uuid.New()),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Turn off",
+			urlPath:      fmt.Sprintf("/snippet/comments/%s/enabled", mocks.SnippetID),
+			form:         url.Values{"enabled": {"false"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: viewPath,
+		},
+		{
+			name:     "Turn off on snippet of another user",
+			urlPath:  fmt.Sprintf("/snippet/comments/%s/enabled", uuid.New()),
+			form:     url.Values{"enabled": {"false"}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			for key, values := range tt.form {
+				form[key] = values
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUserSignup(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		userName string
+		email    string
+		password string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission",
+			userName: "Bob",
+			email:    "bob@example.com",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Duplicate email",
+			userName: "Bob",
+			email:    "dupe@example.com",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Email address is already in use",
+		},
+		{
+			name:     "Duplicate name",
+			userName: "Dupe",
+			email:    "bob@example.com",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Name is already in use",
+		},
+		{
+			name:     "Breached password",
+			userName: "Bob",
+			email:    "bob@example.com",
+			password: "Password123",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: breachedPasswordMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.password == "" {
+				tt.password = "validPa$$word"
+			}
+
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.email)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/signup", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountPasswordUpdate(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/password/update")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/password/update")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<h2>Change Password</h2>")
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		confirmation    string
+		wantCode        int
+		wantBody        string
+		wantLocation    string
+	}{
+		{
+			name:            "Wrong current password",
+			currentPassword: "wrong",
+			newPassword:     "new pa$$word",
+			confirmation:    "new pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Current password is incorrect",
+		},
+		{
+			name:            "Confirmation mismatch",
+			currentPassword: "pa$$word",
+			newPassword:     "new pa$$word",
+			confirmation:    "other pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Passwords do not match",
+		},
+		{
+			name:            "Short password",
+			currentPassword: "pa$$word",
+			newPassword:     "short",
+			confirmation:    "short",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "This field must be at least 8 characters long",
+		},
+		{
+			name:            "Breached password",
+			currentPassword: "pa$$word",
+			newPassword:     "qwertyuiop",
+			confirmation:    "qwertyuiop",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        breachedPasswordMessage,
+		},
+		{
+			name:            "Valid submission",
+			currentPassword: "pa$$word",
+			newPassword:     "new pa$$word",
+			confirmation:    "new pa$$word",
+			wantCode:        http.StatusSeeOther,
+			wantLocation:    "/account/sessions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("currentPassword", tt.currentPassword)
+			form.Add("newPassword", tt.newPassword)
+			form.Add("newPasswordConfirmation", tt.confirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/account/password/update", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestAccountPasswordUpdateLogsOutElsewhere changes the password with another session and the remember-me logins
+// of the user, only the session that changed the password stays
+func TestAccountPasswordUpdateLogsOutElsewhere(t *testing.T) {
+	app := newTestApplication(t)
+	userSessions := memory.NewUserSessionModel()
+	rememberTokens := memory.NewRememberTokenModel()
+	app.userSessions = userSessions
+	app.rememberTokens = rememberTokens
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	ctx := context.Background()
+
+	sessions, err := userSessions.ForUser(ctx, mocks.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(sessions), 1)
+	current := sessions[0].ID
+
+	other, err := userSessions.Insert(ctx, mocks.UserID, "192.0.2.1", "Firefox", app.sessionManager.Lifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminSession, err := userSessions.Insert(ctx, mocks.AdminID, "192.0.2.2", "Chrome", app.sessionManager.Lifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens []models.RememberToken
+	for _, sessionID := range []uuid.UUID{current, other} {
+		rt, err := rememberTokens.Insert(ctx, mocks.UserID, sessionID, rememberMeLifetime)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens = append(tokens, rt)
+	}
+
+	adminToken, err := rememberTokens.Insert(ctx, mocks.AdminID, adminSession, rememberMeLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Add("currentPassword", "pa$$word")
+	form.Add("newPassword", "new pa$$word")
+	form.Add("newPasswordConfirmation", "new pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/account/password/update", form)
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.StringContains(t, strings.Join(header.Values("Set-Cookie"), "\n"), rememberCookieName+"=; Path=/; Max-Age=0")
+
+	sessions, err = userSessions.ForUser(ctx, mocks.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].ID, current)
+
+	for _, rt := range tokens {
+		_, err = rememberTokens.Rotate(ctx, rt.Series, rt.Token)
+		assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	}
+
+	// the other users keep their logins
+	_, err = userSessions.Get(ctx, adminSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rememberTokens.Rotate(ctx, adminToken.Series, adminToken.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// this session is still logged in
+	code, _, _ = ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/account/sessions")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("List", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/sessions")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "127.0.0.1")
+		assert.StringContains(t, body, "(this device)")
+	})
+
+	t.Run("Revoke someone else's session", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, fmt.Sprintf("/account/sessions/%s/revoke", mocks.AdminSessionID), form)
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Log out everywhere", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/account/sessions/revoke-all", form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		code, _, _ = ts.get(t, "/account/sessions")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestAccountSessionsRevokeAllMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	userSessions := memory.NewUserSessionModel()
+	app.userSessions = userSessions
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	ctx := context.Background()
+
+	for _, lifetime := range []time.Duration{app.sessionManager.Lifetime, app.sessionManager.Lifetime, 0} {
+		_, err := userSessions.Insert(ctx, mocks.UserID, "192.0.2.1", "Firefox", lifetime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/account/sessions/revoke-all", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	var buf bytes.Buffer
+	err := app.metrics.registry.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// this session and the two other live ones, the expired one had ended already
+	assert.StringContains(t, buf.String(), `snippetbox_sessions_revoked_total{reason="user"} 3`)
+}
+
+func TestAdminUserSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	urlPath := fmt.Sprintf("/admin/users/%s/sessions", mocks.UserID)
+
+	t.Run("Not an admin", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		code, _, _ := ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t, "admin@example.com", "pa$$word")
+
+		code, _, body := ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "alice@example.com")
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, fmt.Sprintf("/admin/users/%s/sessions/%s/revoke", mocks.UserID, mocks.UserSessionID), form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), urlPath)
+	})
+}
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("Login sets the cookie", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("remember", "true")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := ts.postForm(t, "/user/login", form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.StringContains(t, strings.Join(header.Values("Set-Cookie"), "\n"), rememberCookieName+"="+mocks.RememberSeries+":"+mocks.RememberToken)
+	})
+
+	tests := []struct {
+		name       string
+		cookie     string
+		wantCode   int
+		wantCookie string
+	}{
+		{
+			name:       "Valid token",
+			cookie:     mocks.RememberSeries + ":" + mocks.RememberToken,
+			wantCode:   http.StatusOK,
+			wantCookie: rememberCookieName + "=" + mocks.RememberSeries + ":" + mocks.RememberToken,
+		},
+		{
+			name:       "Unknown series",
+			cookie:     "unknown:" + mocks.RememberToken,
+			wantCode:   http.StatusSeeOther,
+			wantCookie: rememberCookieName + "=; Path=/; Max-Age=0",
+		},
+		{
+			name:       "Reused token",
+			cookie:     mocks.StolenSeries + ":" + mocks.RememberToken,
+			wantCode:   http.StatusSeeOther,
+			wantCookie: rememberCookieName + "=; Path=/; Max-Age=0",
+		},
+		{
+			// a parallel request has the new cookie, this one leaves the cookie as is
+			name:     "Rotated token",
+			cookie:   mocks.RotatedSeries + ":" + mocks.RememberToken,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:       "Malformed",
+			cookie:     "garbage",
+			wantCode:   http.StatusSeeOther,
+			wantCookie: rememberCookieName + "=; Path=/; Max-Age=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: tt.cookie}})
+
+			code, header, _ := ts.get(t, "/account/sessions")
+			cookies := strings.Join(header.Values("Set-Cookie"), "\n")
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCookie == "" {
+				if strings.Contains(cookies, rememberCookieName+"=") {
+					t.Errorf("got cookies %q; want no %s", cookies, rememberCookieName)
+				}
+			} else {
+				assert.StringContains(t, cookies, tt.wantCookie)
+			}
+		})
+	}
+}
+
+// TestRestoreLoginRace restores one remember-me login from parallel requests, like the tabs of a restored browser.
+// One request logs in, the others stay logged out without revoking the login
+func TestRestoreLoginRace(t *testing.T) {
+	app := newTestApplication(t)
+	rememberTokens := memory.NewRememberTokenModel()
+	app.rememberTokens = rememberTokens
+
+	rt, err := rememberTokens.Insert(context.Background(), mocks.UserID, uuid.New(), rememberMeLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+
+	var wg sync.WaitGroup
+	restored := make([]bool, n)
+	errs := make([]error, n)
+	recorders := make([]*httptest.ResponseRecorder, n)
+
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, err := app.sessionManager.Load(context.Background(), "")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			r.AddCookie(&http.Cookie{Name: rememberCookieName, Value: rt.Series + ":" + rt.Token})
+
+			recorders[i] = httptest.NewRecorder()
+			restored[i], errs[i] = app.restoreLogin(recorders[i], r)
+		}()
+	}
+
+	wg.Wait()
+
+	var cookie string
+	wins := 0
+
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		if restored[i] {
+			wins++
+			cookie = recorders[i].Header().Get("Set-Cookie")
+		} else {
+			assert.Equal(t, recorders[i].Header().Get("Set-Cookie"), "")
+		}
+	}
+
+	assert.Equal(t, wins, 1)
+
+	// the series survived the race, the cookie of the winner restores the next login
+	series, token, _ := strings.Cut(strings.TrimPrefix(strings.Split(cookie, ";")[0], rememberCookieName+"="), ":")
+
+	_, err = rememberTokens.Rotate(context.Background(), series, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// singleResponseRecorder fails the test if a handler tries to write the status code more than once
+type singleResponseRecorder struct {
+	*httptest.ResponseRecorder
+	t       *testing.T
+	written bool
+}
+
+func (rr *singleResponseRecorder) WriteHeader(status int) {
+	if rr.written {
+		rr.t.Errorf("superfluous WriteHeader call with the status %d", status)
+		return
+	}
+
+	rr.written = true
+	rr.ResponseRecorder.WriteHeader(status)
+}
+
+func TestErrorResponses(t *testing.T) {
+	app := newTestApplication(t)
+
+	failing := app.makeHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database is gone")
+	})
+
+	tests := []struct {
+		name            string
+		handler         http.Handler
+		urlPath         string
+		form            url.Values
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Bad request page",
+			urlPath:         "/snippet/view/foo",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "400 Bad Request",
+		},
+		{
+			name:            "Bad request JSON",
+			urlPath:         "/snippet/view/foo",
+			accept:          "application/json",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/json",
+			wantBody:        `"code":"INVALID_ARGUMENT"`,
+		},
+		{
+			name:            "Not found page",
+			urlPath:         fmt.Sprintf("/snippet/view/%s", uuid.New()),
+			accept:          "text/html",
+			wantCode:        http.StatusNotFound,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "404 Not Found",
+		},
+		{
+			name:            "Not found JSON",
+			urlPath:         fmt.Sprintf("/snippet/view/%s", uuid.New()),
+			wantCode:        http.StatusNotFound,
+			wantContentType: "application/json",
+			wantBody:        `"code":"NOT_FOUND"`,
+		},
+		{
+			name:            "Query timeout page",
+			urlPath:         fmt.Sprintf("/snippet/view/%s", mocks.SlowSnippetID),
+			accept:          "text/html",
+			wantCode:        http.StatusServiceUnavailable,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "503 Service Unavailable",
+		},
+		{
+			name:            "Query timeout JSON",
+			urlPath:         fmt.Sprintf("/snippet/view/%s", mocks.SlowSnippetID),
+			wantCode:        http.StatusServiceUnavailable,
+			wantContentType: "application/json",
+			wantBody:        `"code":"UNAVAILABLE"`,
+		},
+		{
+			name:            "Query timeout on login",
+			handler:         app.sessionManager.LoadAndSave(app.makeHandler(app.userLoginPost)),
+			urlPath:         "/user/login",
+			form:            url.Values{"email": {mocks.SlowEmail}, "password": {"pa$$word"}},
+			accept:          "text/html",
+			wantCode:        http.StatusServiceUnavailable,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "503 Service Unavailable",
+		},
+		{
+			name:            "Unknown route page",
+			urlPath:         "/no/such/page",
+			accept:          "text/html",
+			wantCode:        http.StatusNotFound,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "404 Not Found",
+		},
+		{
+			name:            "Internal error page",
+			handler:         failing,
+			urlPath:         "/",
+			accept:          "text/html",
+			wantCode:        http.StatusInternalServerError,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "500 Internal Server Error",
+		},
+		{
+			name:            "Internal error JSON",
+			handler:         failing,
+			urlPath:         "/",
+			accept:          "application/json",
+			wantCode:        http.StatusInternalServerError,
+			wantContentType: "application/json",
+			wantBody:        `"code":"INTERNAL"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler
+			if handler == nil {
+				handler = app.routes()
+			}
+
+			r, err := http.NewRequest(http.MethodGet, tt.urlPath, nil)
+			if tt.form != nil {
+				r, err = http.NewRequest(http.MethodPost, tt.urlPath, strings.NewReader(tt.form.Encode()))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.form != nil {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			rr := &singleResponseRecorder{ResponseRecorder: httptest.NewRecorder(), t: t}
+			handler.ServeHTTP(rr, r)
+
+			rs := rr.Result()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+			assert.Equal(t, rs.Header.Get("Content-Type"), tt.wantContentType)
+			assert.StringContains(t, rr.Body.String(), tt.wantBody)
+
+			if strings.Contains(tt.wantContentType, "html") {
+				assert.Equal(t, strings.Contains(rr.Body.String(), `"code":`), false)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	app := newTestApplication(t)
+
+	r, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	rs := rr.Result()
+
+	assert.Equal(t, rs.StatusCode, http.StatusMethodNotAllowed)
+	assert.Equal(t, rs.Header.Get("Allow"), "GET, HEAD")
+}
+
+func TestAcceptsHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{
+			name:   "Empty",
+			accept: "",
+			want:   false,
+		},
+		{
+			name:   "Browser",
+			accept: "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+			want:   true,
+		},
+		{
+			name:   "Any",
+			accept: "*/*",
+			want:   false,
+		},
+		{
+			name:   "JSON",
+			accept: "application/json",
+			want:   false,
+		},
+		{
+			name:   "JSON preferred",
+			accept: "text/html;q=0.5, application/json",
+			want:   false,
+		},
+		{
+			name:   "HTML preferred",
+			accept: "application/json;q=0.5, text/html",
+			want:   true,
+		},
+		{
+			name:   "HTML refused",
+			accept: "text/html;q=0",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Accept", tt.accept)
+
+			assert.Equal(t, acceptsHTML(r), tt.want)
+		})
+	}
 }
@@ -4,38 +4,130 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/go-playground/form/v4"
+	"github.com/google/uuid"
+	"github.com/justinas/nosurf"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler func(w http.ResponseWriter, r *http.Request) error
 
 func (app *application) makeHandler(handler Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.tracer.Start(r.Context(), "handler "+app.route(r))
+		defer span.End()
+
+		r = r.WithContext(ctx)
+
 		err := handler(w, r)
 		if err == nil {
 			return
 		}
 
-		var apiError ApiError
-		if errors.As(err, &apiError) {
-			writeJSON(w, StatusCodeMap[apiError.Code], apiError)
-		} else {
-			internalError := NewApiError(ErrorInternal, fmt.Errorf("internal server error"), nil)
-			writeJSON(w, StatusCodeMap[internalError.Code], internalError)
-		}
-		app.logger.Error("HTTP API error", "msg", err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		span.RecordError(err)
+
+		app.writeError(w, r, toApiError(err))
+		app.logger.ErrorContext(r.Context(), "HTTP API error", "error", err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
 	}
 }
 
+// route returns the pattern of the mux that matched the request
+func (app *application) route(r *http.Request) string {
+	route, ok := r.Context().Value(routeContextKey).(string)
+	if !ok {
+		return "unmatched"
+	}
+
+	return route
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(v)
 }
 
+// writeError responds with an error page to the browsers and with the JSON to everyone else
+func (app *application) writeError(w http.ResponseWriter, r *http.Request, apiError ApiError) {
+	status, ok := StatusCodeMap[apiError.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	if !acceptsHTML(r) {
+		writeJSON(w, status, apiError)
+		return
+	}
+
+	tmpl, err := app.template("error.gohtml")
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "failed to render the error page", "error", err.Error())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	// the error can happen outside the session middleware, so the session data is not touched here
+	data := templateData{
+		CurrentYear:     time.Now().Year(),
+		IsAuthenticated: app.isAuthenticated(r),
+		IsAdmin:         app.isAdmin(r),
+		CSRFToken:       nosurf.Token(r),
+		Status:          status,
+		Error:           apiError,
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = tmpl.ExecuteTemplate(buf, "base", data)
+	if err != nil {
+		app.metrics.templateErrors.WithLabelValues("error.gohtml").Inc()
+		app.logger.ErrorContext(r.Context(), "failed to render the error page", "error", err.Error())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	buf.WriteTo(w)
+}
+
+// acceptsHTML reports whether the client prefers text/html over application/json according to the Accept header.
+// Clients that don't send the header get JSON
+func acceptsHTML(r *http.Request) bool {
+	var htmlQuality, jsonQuality float64
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				q, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html", "application/xhtml+xml":
+			htmlQuality = max(htmlQuality, quality)
+		case "application/json":
+			jsonQuality = max(jsonQuality, quality)
+		}
+	}
+
+	return htmlQuality > 0 && htmlQuality >= jsonQuality
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
 	if !ok {
@@ -45,6 +137,97 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
+func (app *application) isAdmin(r *http.Request) bool {
+	isAdmin, ok := r.Context().Value(isAdminContextKey).(bool)
+	if !ok {
+		return false
+	}
+
+	return isAdmin
+}
+
+// authenticatedUserID returns the zero UUID for the anonymous requests
+func (app *application) authenticatedUserID(r *http.Request) uuid.UUID {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(uuid.UUID)
+	if !ok {
+		return uuid.UUID{}
+	}
+
+	return id
+}
+
+func (app *application) userSessionID(r *http.Request) uuid.UUID {
+	id, ok := r.Context().Value(userSessionIDContextKey).(uuid.UUID)
+	if !ok {
+		return uuid.UUID{}
+	}
+
+	return id
+}
+
+// startUserSession logs the user in. The session token is renewed to prevent the session fixation attacks
+// and the login is recorded, so that the user can see and revoke it later
+func (app *application) startUserSession(r *http.Request, userID uuid.UUID) (uuid.UUID, error) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	sessionID, err := app.userSessions.Insert(r.Context(), userID, clientIP(r), userAgent(r), app.sessionManager.Lifetime)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID.String())
+	app.sessionManager.Put(r.Context(), "userSessionID", sessionID.String())
+
+	return sessionID, nil
+}
+
+// endUserSession logs the user out on the current device, including the remember-me login
+func (app *application) endUserSession(w http.ResponseWriter, r *http.Request) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	sessionID := app.userSessionID(r)
+	if sessionID != (uuid.UUID{}) {
+		err = app.userSessions.Delete(r.Context(), app.authenticatedUserID(r), sessionID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+
+		err = app.rememberTokens.DeleteForSession(r.Context(), sessionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.forgetUser(r)
+	clearRememberCookie(w)
+
+	return nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > 256 {
+		ua = ua[:256]
+	}
+
+	return ua
+}
+
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	var (
 		method = r.Method
@@ -52,29 +235,33 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		trace  = string(debug.Stack())
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri, "trace", trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri, "trace", trace)
+	app.writeError(w, r, NewInternalError())
 }
 
-func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) {
-	tmpl, ok := app.templateCache[page]
-
-	if !ok {
-		err := fmt.Errorf("the template %s doesn't exist", page)
-		app.serverError(w, r, err)
-		return
+// render writes the page only if the template was executed successfully, otherwise the error is returned,
+// and nothing is written, so that the caller can respond with an error
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) error {
+	tmpl, err := app.template(page)
+	if err != nil {
+		return err
 	}
 
+	_, span := app.tracer.Start(r.Context(), "render "+page)
+	defer span.End()
+
 	buf := new(bytes.Buffer)
 
-	err := tmpl.ExecuteTemplate(buf, "base", data)
+	err = tmpl.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, r, err)
-		return
+		span.RecordError(err)
+		app.metrics.templateErrors.WithLabelValues(page).Inc()
+		return err
 	}
 	w.WriteHeader(status)
 
 	buf.WriteTo(w)
+	return nil
 }
 
 // error can be DecodeErrors from form decoder
@@ -1,55 +1,77 @@
 package main
 
 import (
-	"crypto/tls"
-	"database/sql"
+	"context"
+	"errors"
 	"flag"
-	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"golang.org/x/crypto/acme/autocert"
 	"html/template"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"snippetbox.doichevkostia.dev/ui"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type application struct {
 	logger         *slog.Logger
 	snippets       models.SnippetModelInterface
+	collections    models.CollectionModelInterface
+	tags           models.TagModelInterface
+	comments       models.CommentModelInterface
+	stars          models.StarModelInterface
+	views          models.ViewModelInterface
+	viewRecorder   *viewRecorder
 	users          models.UserModelInterface
+	userSessions   models.UserSessionModelInterface
+	rememberTokens models.RememberTokenModelInterface
+	health         models.HealthModelInterface
+	breachList     *password.BreachList
 	templateCache  map[string]*template.Template
+	templateFS     fs.FS // set in the development mode to parse the templates from the disk
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	metrics        *appMetrics
+	tracer         *trace.Tracer
+	tlsEnabled     bool
+	shuttingDown   atomic.Bool
 }
 
 func main() {
-	addr := flag.String("addr", ":8080", "HTTP network address")
-	loglevel := flag.String("loglevel", "info", "Logger level")
-	dsn := flag.String("dsn", "file:db.sqlite", "SQLite data source name")
-	secure := flag.Bool("secure", true, "Use HTTPS server")
-	cert := flag.String("cert", "./tls/cert.pem", "TLS certificate file")
-	key := flag.String("key", "./tls/key.pem", "TLS key file")
-
-	flag.Parse()
-
-	if *secure == true {
-		if _, err := os.Stat(*cert); os.IsNotExist(err) {
-			log.Fatalf("Certificate file %s does not exist", *cert)
+	cfg, printConfig, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
 		}
+		log.Fatal(err)
+	}
 
-		if _, err := os.Stat(*key); os.IsNotExist(err) {
-			log.Fatalf("Key file %s does not exist", *key)
+	if printConfig {
+		err = cfg.print(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
 		}
+		os.Exit(0)
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		log.Fatalf("Invalid config:\n%s", err)
 	}
 
 	var level slog.Level
 
-	switch *loglevel {
+	switch cfg.Log.Level {
 	case "info":
 		level = slog.LevelInfo
 	case "debug":
@@ -58,83 +80,229 @@ func main() {
 		level = slog.LevelWarn
 	case "error":
 		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	handlerOptions := &slog.HandlerOptions{
 		Level: level,
-	}))
+	}
+
+	var handler slog.Handler
+
+	switch cfg.Log.Format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, handlerOptions)
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, handlerOptions)
+	}
+
+	logger := slog.New(contextHandler{handler})
+
+	var tracer *trace.Tracer
+
+	switch cfg.Trace.Exporter {
+	case "none":
+	case "stdout":
+		tracer = trace.NewTracer(trace.NewWriterExporter(os.Stdout))
+	case "file":
+		exporter, err := trace.NewFileExporter(cfg.Trace.File)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		tracer = trace.NewTracer(exporter)
+	}
+
+	if tracer != nil {
+		tracer.OnError = func(err error) {
+			logger.Error("failed to export a span", "error", err.Error())
+		}
+	}
 
-	db, err := openDB(*dsn)
+	st, err := openStorage(cfg, tracer)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
-	defer db.Close()
+	if st.db == nil {
+		logger.Warn("the data is kept in memory, it is lost on restart")
+	}
+
+	defer st.Close()
+
+	if cfg.Migrate && st.migrate != nil {
+		from, err := st.migrate(context.Background())
+		if err != nil {
+			if errors.Is(err, models.ErrNoSchema) {
+				logger.Error("the database is empty, create it with sql/schema.sql or sql/schema_postgres.sql")
+			} else {
+				logger.Error("failed to migrate the schema", "error", err.Error())
+			}
+			os.Exit(1)
+		}
 
-	templateCache, err := newTemplateCache()
+		if from != models.SchemaVersion {
+			logger.Info("migrated the schema", "from", from, "to", models.SchemaVersion)
+		}
+	} else if st.db != nil {
+		// the server starts anyway, /readyz reports the outdated schema
+		version, err := st.health.SchemaVersion(context.Background())
+		if err != nil || version < models.SchemaVersion {
+			logger.Warn("the schema is outdated, start with -migrate to upgrade it", "version", version, "expected", models.SchemaVersion)
+		}
+	}
+
+	templateCache, err := newTemplateCache(ui.Files)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
+	breachList := password.DefaultBreachList()
+	if cfg.Password.BreachList != "" {
+		breachList, err = password.ReadBreachList(cfg.Password.BreachList)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	logger.Info("loaded the breached passwords", "count", breachList.Len())
+
 	formDecoder := form.NewDecoder()
 
 	sessionManager := scs.New()
-	sessionManager.Store = sqlite3store.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Store = st.sessionStore
+	sessionManager.Lifetime = cfg.Session.Lifetime
 
 	app := &application{
 		logger:         logger,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db, PasswordCost: 12},
+		snippets:       st.snippets,
+		collections:    st.collections,
+		tags:           st.tags,
+		comments:       st.comments,
+		stars:          st.stars,
+		views:          st.views,
+		users:          st.users,
+		userSessions:   st.userSessions,
+		rememberTokens: st.rememberTokens,
+		health:         st.health,
+		breachList:     breachList,
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		metrics:        newAppMetrics(),
+		tracer:         tracer,
+		tlsEnabled:     cfg.TLS.Enabled,
+	}
+
+	app.viewRecorder = newViewRecorder(app.views, logger, app.metrics.viewsDropped)
+
+	if cfg.Dev {
+		logger.Warn("development mode, the templates are parsed from the disk on every request", "dir", uiDir)
+		app.templateFS = os.DirFS(uiDir)
 	}
 
-	// some elliptic curves with assembly implementation. Idk what this is yet
-	tlsConfig := &tls.Config{
-		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	if st.db != nil {
+		app.metrics.registerDBStats(st.db)
 	}
 
+	var acmeManager *autocert.Manager
+	if cfg.acmeEnabled() {
+		acmeManager = newACMEManager(cfg)
+	}
+
+	var reloader *certReloader
+	if cfg.TLS.Enabled && acmeManager == nil {
+		reloader, err = newCertReloader(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+
+		go reloader.watch(context.Background(), logger, sighup, certCheckInterval)
+	}
+
+	tlsConfig := newTLSConfig(acmeManager, reloader)
+
 	srv := &http.Server{
-		Addr:      *addr,
+		Addr:      cfg.Addr,
 		Handler:   app.routes(),
 		ErrorLog:  slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		TLSConfig: tlsConfig,
 
-		IdleTimeout: time.Minute, // the keep-alive connection timeout
+		IdleTimeout: cfg.Timeouts.Idle, // the keep-alive connection timeout
 		// could use ReadHeaderTimeout and different read timeout for different handlers (body can have different size)
-		ReadTimeout: 5 * time.Second,
+		ReadTimeout: cfg.Timeouts.Read,
 		// writes made by a handler are buffered and written to the connection as one.
 		// Idea is NOT to prevent the long-running handlers, but to prevent the data the handler returns  from taking too long to write
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: cfg.Timeouts.Write,
 	}
 
-	logger.Info("starting server", "addr", *addr)
+	var others []*http.Server
+
+	if cfg.Metrics.Addr != "" {
+		metricsSrv := &http.Server{
+			Addr:         cfg.Metrics.Addr,
+			Handler:      app.metricsRoutes(cfg.Metrics.User, cfg.Metrics.Password),
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.Info("starting metrics server", "addr", cfg.Metrics.Addr)
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server stopped", "error", err.Error())
+			}
+		}()
 
-	if *secure == true {
-		err = srv.ListenAndServeTLS(*cert, *key)
-	} else {
-		err = srv.ListenAndServe()
+		others = append(others, metricsSrv)
 	}
-	logger.Error(err.Error())
-	os.Exit(1)
-}
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
+	if cfg.TLS.RedirectAddr != "" {
+		handler := redirectHTTPS(cfg.Addr)
+		if acmeManager != nil {
+			handler = acmeManager.HTTPHandler(handler)
+		}
+
+		redirectSrv := &http.Server{
+			Addr:         cfg.TLS.RedirectAddr,
+			Handler:      handler,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.Info("starting redirect server", "addr", cfg.TLS.RedirectAddr)
+			err := redirectSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("redirect server stopped", "error", err.Error())
+			}
+		}()
+
+		others = append(others, redirectSrv)
 	}
-	err = db.Ping()
+
+	logger.Info("starting server", "addr", cfg.Addr)
+
+	err = app.serve(srv, func() error {
+		if cfg.TLS.Enabled {
+			// the certificates come from the TLS config
+			return srv.ListenAndServeTLS("", "")
+		}
+
+		return srv.ListenAndServe()
+	}, cfg.ShutdownDelay, others...)
 	if err != nil {
-		db.Close()
-		return nil, err
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
-
-	return db, nil
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"snippetbox.doichevkostia.dev/internal/metrics"
+	"strconv"
+	"time"
+)
+
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests         *metrics.CounterVec
+	requestDuration  *metrics.HistogramVec
+	requestsInFlight *metrics.GaugeVec
+
+	logins          *metrics.CounterVec
+	sessionsStarted *metrics.CounterVec
+	sessionsRevoked *metrics.CounterVec
+	rememberReuses  *metrics.CounterVec
+
+	templateErrors *metrics.CounterVec
+	viewsDropped   *metrics.CounterVec
+}
+
+func newAppMetrics() *appMetrics {
+	registry := metrics.NewRegistry()
+
+	return &appMetrics{
+		registry: registry,
+
+		requests:         registry.NewCounterVec("http_requests_total", "Number of the completed HTTP requests.", "route", "method", "code"),
+		requestDuration:  registry.NewHistogramVec("http_request_duration_seconds", "Latency of the HTTP requests.", metrics.DefBuckets, "route", "method"),
+		requestsInFlight: registry.NewGaugeVec("http_requests_in_flight", "Number of the HTTP requests being served."),
+
+		logins:          registry.NewCounterVec("snippetbox_logins_total", "Login attempts with the email and password.", "result"),
+		sessionsStarted: registry.NewCounterVec("snippetbox_sessions_started_total", "Started user sessions by the way the user logged in.", "method"),
+		sessionsRevoked: registry.NewCounterVec("snippetbox_sessions_revoked_total", "User sessions ended before they expired.", "reason"),
+		rememberReuses:  registry.NewCounterVec("snippetbox_remember_token_reuses_total", "Reused remember-me tokens, a sign of a stolen cookie."),
+
+		templateErrors: registry.NewCounterVec("snippetbox_template_render_errors_total", "Failed template executions.", "template"),
+		viewsDropped:   registry.NewCounterVec("snippetbox_snippet_views_dropped_total", "Snippet views not recorded because the queue was full."),
+	}
+}
+
+// registerDBStats exposes the connection pool statistics, they are read on every scrape
+func (m *appMetrics) registerDBStats(db *sql.DB) {
+	m.registry.NewGaugeFunc("snippetbox_db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_open_connections", "Number of established connections both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	m.registry.NewCounterFunc("snippetbox_db_wait_count_total", "Number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	m.registry.NewCounterFunc("snippetbox_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	m.registry.NewCounterFunc("snippetbox_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	m.registry.NewCounterFunc("snippetbox_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+}
+
+// instrument records the requests by the route pattern they matched in the mux,
+// so that /snippet/view/{id} is one time series and not one per snippet.
+// The route is stored in the context for the following middlewares
+func (app *application) instrument(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), routeContextKey, route))
+
+			inFlight := app.metrics.requestsInFlight.WithLabelValues()
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w}
+
+			next.ServeHTTP(rw, r)
+
+			app.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rw.Status())).Inc()
+			app.metrics.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// metricsRoutes is served on a separate listener, so the metrics are not exposed to the internet with the app.
+// The basic authentication is required if the user is set
+func (app *application) metricsRoutes(user, password string) http.Handler {
+	mux := http.NewServeMux()
+
+	handler := app.metrics.registry.Handler()
+	if user != "" {
+		handler = basicAuth(user, password, handler)
+	}
+
+	mux.Handle("GET /metrics", handler)
+
+	return mux
+}
+
+func basicAuth(user, password string, next http.Handler) http.Handler {
+	expectedUser := sha256.Sum256([]byte(user))
+	expectedPassword := sha256.Sum256([]byte(password))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if ok {
+			userHash := sha256.Sum256([]byte(u))
+			passwordHash := sha256.Sum256([]byte(p))
+
+			userMatch := subtle.ConstantTimeCompare(userHash[:], expectedUser[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPassword[:]) == 1
+
+			if userMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
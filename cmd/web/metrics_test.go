@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models/mocks"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, fmt.Sprintf("/snippet/view/%s", mocks.SnippetID))
+	ts.get(t, fmt.Sprintf("/snippet/view/%s", uuid.New()))
+	ts.get(t, fmt.Sprintf("/snippet/view/%s", uuid.New()))
+	ts.get(t, "/no/such/page")
+
+	var buf bytes.Buffer
+	err := app.metrics.registry.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := buf.String()
+
+	assert.StringContains(t, body, `http_requests_total{route="GET /snippet/view/{id}",method="GET",code="200"} 1`)
+	assert.StringContains(t, body, `http_requests_total{route="GET /snippet/view/{id}",method="GET",code="404"} 2`)
+	assert.StringContains(t, body, `http_requests_total{route="GET /",method="GET",code="404"} 1`)
+	assert.StringContains(t, body, `http_request_duration_seconds_count{route="GET /snippet/view/{id}",method="GET"} 3`)
+	assert.StringContains(t, body, `http_requests_in_flight 0`)
+}
+
+func TestMetricsRoutes(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		auth     bool
+		wantCode int
+	}{
+		{
+			name:     "No authentication configured",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Valid credentials",
+			user:     "prometheus",
+			password: "secret",
+			auth:     true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Missing credentials",
+			user:     "prometheus",
+			password: "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.auth {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+
+			rr := httptest.NewRecorder()
+			app.metricsRoutes(tt.user, tt.password).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			if tt.wantCode == http.StatusOK {
+				assert.StringContains(t, rr.Body.String(), "# TYPE http_requests_total counter")
+			}
+		})
+	}
+}
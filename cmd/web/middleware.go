@@ -2,14 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
 	"net/http"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
 )
 
-func commonHeaders(next http.Handler) http.Handler {
+// hstsMaxAge is two years, the minimum for the browser preload lists is one year
+const hstsMaxAge = 2 * 365 * 24 * time.Hour
+
+func (app *application) commonHeaders(next http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d; includeSubDomains", int(hstsMaxAge.Seconds()))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the header is ignored by the browsers over plain HTTP, it is only sent when the server is behind TLS
+		if app.tlsEnabled {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+
 		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
 
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
@@ -23,18 +37,115 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// logRequest assigns an id to the request and logs the request once it is completed.
+// The id is taken from the X-Request-ID header if the client (or a proxy) already set a valid one
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ip     = r.RemoteAddr
-			proto  = r.Proto
-			method = r.Method
-			uri    = r.URL.RequestURI()
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		r = r.WithContext(ctx)
+
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		app.logger.InfoContext(r.Context(), "request completed",
+			"ip", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rw.Status(),
+			"bytes", rw.bytes,
+			"duration", time.Since(start),
 		)
+	})
+}
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
 
-		next.ServeHTTP(w, r)
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// responseWriter remembers the status code and the size of the response for the logs
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rw.status
+}
+
+// traceRequest starts the server span of the request. If the client sent the traceparent header,
+// the span continues that trace
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.Extract(r.Header); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		ctx, span := app.tracer.Start(ctx, app.route(r))
+		defer span.End()
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", app.route(r))
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("request_id", ctx.Value(requestIDContextKey))
+
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttribute("http.response.status_code", rw.Status())
+		if rw.Status() >= 500 {
+			span.SetStatus(trace.StatusError)
+		}
 	})
 }
 
@@ -63,6 +174,16 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// limitRequestBody fails the reads of the body past the limit, so that an upload can't fill the memory or the disk
+func limitRequestBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -74,33 +195,97 @@ func noSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAdmin(r) {
+			app.writeError(w, r, NewApiError(ErrorPermissionDenied, errors.New("admin rights are required"), nil))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
 		if id == "" {
-			next.ServeHTTP(w, r)
-			return
+			restored, err := app.restoreLogin(w, r)
+			if err != nil {
+				app.logger.ErrorContext(r.Context(), "Failed to restore the remembered login", "error", err.Error())
+				app.writeError(w, r, toApiError(err))
+				return
+			}
+
+			if !restored {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id = app.sessionManager.GetString(r.Context(), "authenticatedUserID")
 		}
 
 		userID, err := uuid.Parse(id)
 		if err != nil {
-			app.logger.Error("Invalid UUID is stored in the authenticatedUserID context property")
-			writeJSON(w, http.StatusInternalServerError, NewInternalError())
+			app.logger.ErrorContext(r.Context(), "Invalid UUID is stored in the authenticatedUserID context property")
+			app.writeError(w, r, NewInternalError())
 			return
 		}
 
-		exists, err := app.users.Exists(userID)
+		// the sessions created before the login tracking don't have the id, they are treated as logged out
+		sessionID, err := uuid.Parse(app.sessionManager.GetString(r.Context(), "userSessionID"))
 		if err != nil {
-			app.logger.Error("Failed to check whether user exists", "msg", err.Error())
-			writeJSON(w, http.StatusInternalServerError, NewInternalError())
+			app.forgetUser(r)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := app.userSessions.Get(r.Context(), sessionID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.logger.ErrorContext(r.Context(), "Failed to get the user session", "error", err.Error())
+			app.writeError(w, r, toApiError(err))
+			return
+		}
+
+		// revoked, expired or belongs to someone else
+		if errors.Is(err, models.ErrNoRecord) || session.UserID != userID {
+			app.forgetUser(r)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.forgetUser(r)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			app.logger.ErrorContext(r.Context(), "Failed to get the user", "error", err.Error())
+			app.writeError(w, r, toApiError(err))
 			return
 		}
 
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+		// no need to write to the database on every request
+		if time.Since(session.LastSeenTime) > time.Minute {
+			err = app.userSessions.Touch(r.Context(), sessionID)
+			if err != nil {
+				app.logger.ErrorContext(r.Context(), "Failed to update the last seen time of the session", "error", err.Error())
+			}
 		}
 
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, isAdminContextKey, user.Admin)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, userID)
+		ctx = context.WithValue(ctx, userSessionIDContextKey, sessionID)
+		r = r.WithContext(ctx)
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) forgetUser(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "userSessionID")
+}
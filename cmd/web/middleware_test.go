@@ -2,14 +2,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/trace"
 	"testing"
 )
 
 func TestCommonHeaders(t *testing.T) {
+	app := newTestApplication(t)
+
 	rr := httptest.NewRecorder()
 
 	r, err := http.NewRequest(http.MethodGet, "/", nil)
@@ -21,7 +27,7 @@ func TestCommonHeaders(t *testing.T) {
 		w.Write([]byte("OK"))
 	})
 
-	commonHeaders(next).ServeHTTP(rr, r)
+	app.commonHeaders(next).ServeHTTP(rr, r)
 
 	rs := rr.Result()
 
@@ -55,6 +61,10 @@ func TestCommonHeaders(t *testing.T) {
 	expectedValue = "Go"
 	assert.Equal(t, rs.Header.Get("Server"), expectedValue)
 
+	// Check that the HSTS header is set, the test application runs with TLS
+	expectedValue = "max-age=63072000; includeSubDomains"
+	assert.Equal(t, rs.Header.Get("Strict-Transport-Security"), expectedValue)
+
 	// Check that the middleware has correctly called the next handler in line
 	// and the response status code and body are as expected.
 	assert.Equal(t, rs.StatusCode, http.StatusOK)
@@ -68,3 +78,134 @@ func TestCommonHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestCommonHeadersWithoutTLS(t *testing.T) {
+	app := newTestApplication(t)
+	app.tlsEnabled = false
+
+	rr := httptest.NewRecorder()
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.commonHeaders(http.NotFoundHandler()).ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Result().Header.Get("Strict-Transport-Security"), "")
+}
+
+func TestLogRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		requestID     string
+		wantRequestID string
+	}{
+		{
+			name:          "Propagated ID",
+			requestID:     "abc-123",
+			wantRequestID: "abc-123",
+		},
+		{
+			name:      "Generated ID",
+			requestID: "",
+		},
+		{
+			name:      "Invalid ID",
+			requestID: "<script>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			app := newTestApplication(t)
+			app.logger = slog.New(contextHandler{slog.NewJSONHandler(&logs, nil)})
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				app.logger.InfoContext(r.Context(), "inside the handler")
+
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			})
+
+			r, err := http.NewRequest(http.MethodGet, "/path?q=1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.requestID != "" {
+				r.Header.Set("X-Request-ID", tt.requestID)
+			}
+
+			rr := httptest.NewRecorder()
+			app.logRequest(next).ServeHTTP(rr, r)
+
+			requestID := rr.Result().Header.Get("X-Request-ID")
+			if tt.wantRequestID != "" {
+				assert.Equal(t, requestID, tt.wantRequestID)
+			} else {
+				_, err = uuid.Parse(requestID)
+				assert.Equal(t, err, nil)
+			}
+
+			decoder := json.NewDecoder(&logs)
+
+			var handlerLine, completionLine map[string]any
+			if err := decoder.Decode(&handlerLine); err != nil {
+				t.Fatal(err)
+			}
+			if err := decoder.Decode(&completionLine); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, handlerLine["request_id"], any(requestID))
+
+			assert.Equal(t, completionLine["msg"], any("request completed"))
+			assert.Equal(t, completionLine["request_id"], any(requestID))
+			assert.Equal(t, completionLine["uri"], any("/path?q=1"))
+			assert.Equal(t, completionLine["status"], any(float64(http.StatusCreated)))
+			assert.Equal(t, completionLine["bytes"], any(float64(5)))
+
+			_, ok := completionLine["duration"]
+			assert.Equal(t, ok, true)
+		})
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	app := newTestApplication(t)
+
+	exporter := &trace.MemoryExporter{}
+	app.tracer = trace.NewTracer(exporter)
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	spans := map[string]trace.SpanData{}
+	for _, span := range exporter.Spans() {
+		assert.Equal(t, span.SpanContext.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+		spans[span.Name] = span
+	}
+
+	server, ok := spans["GET /{$}"]
+	assert.Equal(t, ok, true)
+	assert.Equal(t, server.ParentSpanID.String(), "00f067aa0ba902b7")
+	assert.Equal(t, server.Attributes["http.response.status_code"], any(http.StatusOK))
+
+	handler, ok := spans["handler GET /{$}"]
+	assert.Equal(t, ok, true)
+	assert.Equal(t, handler.ParentSpanID, server.SpanContext.SpanID)
+
+	render, ok := spans["render home.gohtml"]
+	assert.Equal(t, ok, true)
+	assert.Equal(t, render.ParentSpanID, handler.SpanContext.SpanID)
+}
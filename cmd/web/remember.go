@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+	"time"
+)
+
+const rememberCookieName = "remember_me"
+
+const rememberMeLifetime = 30 * 24 * time.Hour
+
+func (app *application) remember(w http.ResponseWriter, r *http.Request, userID, userSessionID uuid.UUID) error {
+	token, err := app.rememberTokens.Insert(r.Context(), userID, userSessionID, rememberMeLifetime)
+	if err != nil {
+		return err
+	}
+
+	setRememberCookie(w, token.Series, token.Token)
+	return nil
+}
+
+// restoreLogin starts a new session from the remember-me cookie. It reports whether the user was logged in
+func (app *application) restoreLogin(w http.ResponseWriter, r *http.Request) (bool, error) {
+	cookie, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return false, nil
+	}
+
+	series, token, ok := strings.Cut(cookie.Value, ":")
+	if !ok {
+		clearRememberCookie(w)
+		return false, nil
+	}
+
+	rt, err := app.rememberTokens.Rotate(r.Context(), series, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			clearRememberCookie(w)
+			return false, nil
+		}
+
+		// a parallel request with the same cookie won the rotation, its response carries the new cookie
+		if errors.Is(err, models.ErrTokenRotated) {
+			return false, nil
+		}
+
+		if errors.Is(err, models.ErrTokenReused) {
+			// the cookie was stolen, whoever used it may still be logged in
+			app.logger.WarnContext(r.Context(), "remember-me token was reused, logging the user out everywhere", "user", rt.UserID, "ip", clientIP(r))
+			app.metrics.rememberReuses.WithLabelValues().Inc()
+			clearRememberCookie(w)
+
+			n, err := app.logoutEverywhere(r.Context(), rt.UserID)
+			if err != nil {
+				return false, err
+			}
+
+			app.metrics.sessionsRevoked.WithLabelValues("token_reuse").Add(float64(n))
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	userSessionID, err := app.startUserSession(r, rt.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	// logging out of the new session ends the remember-me login too
+	err = app.rememberTokens.SetUserSession(r.Context(), series, userSessionID)
+	if err != nil {
+		return false, err
+	}
+
+	app.metrics.sessionsStarted.WithLabelValues("remember").Inc()
+
+	setRememberCookie(w, series, rt.Token)
+	return true, nil
+}
+
+// logoutEverywhere revokes every session and remember-me token of the user, it returns the number of revoked sessions
+func (app *application) logoutEverywhere(ctx context.Context, userID uuid.UUID) (int, error) {
+	err := app.rememberTokens.DeleteAllForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.userSessions.DeleteAllForUser(ctx, userID)
+}
+
+// logoutElsewhere keeps only the given session of the user, the remember-me tokens are revoked on every device,
+// this one included. It returns the number of revoked sessions
+func (app *application) logoutElsewhere(ctx context.Context, userID, userSessionID uuid.UUID) (int, error) {
+	err := app.rememberTokens.DeleteAllForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.userSessions.DeleteOthers(ctx, userID, userSessionID)
+}
+
+func setRememberCookie(w http.ResponseWriter, series, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    series + ":" + token,
+		Path:     "/",
+		MaxAge:   int(rememberMeLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
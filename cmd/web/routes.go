@@ -13,12 +13,25 @@ func (app *application) routes() http.Handler {
 
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 	protected := dynamic.Append(app.requireAuthentication)
+	admin := protected.Append(app.requireAdmin)
+	// the body is limited before noSurf reads the form
+	avatarUpload := alice.New(limitRequestBody(maxAvatarRequest)).Extend(protected)
 
-	mux.HandleFunc("/ping", ping)
+	mux.HandleFunc("GET /ping", ping)
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+	mux.HandleFunc("GET /version", version)
+
+	// the error page instead of the plain text of http.NotFound. Only for GET, so that a known path
+	// with another method still gets the 405 of the mux
+	mux.Handle("GET /", dynamic.ThenFunc(app.makeHandler(app.notFound)))
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(app.makeHandler(app.home)))
 
 	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(app.makeHandler(app.snippetView)))
+	mux.Handle("GET /snippet/download/{id}", dynamic.ThenFunc(app.makeHandler(app.snippetDownload)))
+	mux.Handle("GET /tags/{tag}", dynamic.ThenFunc(app.makeHandler(app.tagView)))
+	mux.Handle("GET /tags", app.makeHandler(app.tagSearch))
 
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.makeHandler(app.userSignup)))
 	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.makeHandler(app.userSignupPost)))
@@ -28,9 +41,44 @@ func (app *application) routes() http.Handler {
 
 	mux.Handle("GET /snippet/create", protected.ThenFunc(app.makeHandler(app.snippetCreate)))
 	mux.Handle("POST /snippet/create", protected.ThenFunc(app.makeHandler(app.snippetCreatePost)))
+	mux.Handle("POST /snippet/fork/{id}", protected.ThenFunc(app.makeHandler(app.snippetFork)))
+	mux.Handle("POST /snippet/star/{id}", protected.ThenFunc(app.makeHandler(app.snippetStarPost)))
+	mux.Handle("POST /snippet/comments/{id}", protected.ThenFunc(app.makeHandler(app.commentCreatePost)))
+	mux.Handle("POST /snippet/comments/{id}/enabled", protected.ThenFunc(app.makeHandler(app.snippetCommentsPost)))
+	mux.Handle("POST /comment/{id}/edit", protected.ThenFunc(app.makeHandler(app.commentEditPost)))
+	mux.Handle("POST /comment/{id}/delete", protected.ThenFunc(app.makeHandler(app.commentDeletePost)))
+	mux.Handle("POST /snippet/collections/{id}", protected.ThenFunc(app.makeHandler(app.collectionSnippetAddPost)))
+	mux.Handle("GET /u/{name}", dynamic.ThenFunc(app.makeHandler(app.userProfile)))
+	mux.Handle("GET /u/{name}/avatar", app.makeHandler(app.userAvatar))
+
+	mux.Handle("GET /user/stars", protected.ThenFunc(app.makeHandler(app.userStars)))
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.makeHandler(app.userLogoutPost)))
 
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	mux.Handle("GET /collection/view/{id}", dynamic.ThenFunc(app.makeHandler(app.collectionView)))
+	mux.Handle("GET /collections", protected.ThenFunc(app.makeHandler(app.collectionList)))
+	mux.Handle("POST /collection/create", protected.ThenFunc(app.makeHandler(app.collectionCreatePost)))
+	mux.Handle("POST /collection/{id}/rename", protected.ThenFunc(app.makeHandler(app.collectionRenamePost)))
+	mux.Handle("POST /collection/{id}/reorder", protected.ThenFunc(app.makeHandler(app.collectionReorderPost)))
+	mux.Handle("POST /collection/{id}/delete", protected.ThenFunc(app.makeHandler(app.collectionDeletePost)))
+	mux.Handle("POST /collection/{id}/snippets/{snippetID}/remove", protected.ThenFunc(app.makeHandler(app.collectionSnippetRemovePost)))
+
+	mux.Handle("GET /account/sessions", protected.ThenFunc(app.makeHandler(app.accountSessions)))
+	mux.Handle("POST /account/sessions/{id}/revoke", protected.ThenFunc(app.makeHandler(app.accountSessionRevokePost)))
+	mux.Handle("POST /account/sessions/revoke-all", protected.ThenFunc(app.makeHandler(app.accountSessionsRevokeAllPost)))
+	mux.Handle("GET /account/preferences", protected.ThenFunc(app.makeHandler(app.accountPreferences)))
+	mux.Handle("POST /account/preferences", protected.ThenFunc(app.makeHandler(app.accountPreferencesPost)))
+	mux.Handle("GET /account/profile", protected.ThenFunc(app.makeHandler(app.accountProfile)))
+	mux.Handle("POST /account/profile", protected.ThenFunc(app.makeHandler(app.accountProfilePost)))
+	mux.Handle("POST /account/avatar", avatarUpload.ThenFunc(app.makeHandler(app.accountAvatarPost)))
+	mux.Handle("POST /account/avatar/delete", protected.ThenFunc(app.makeHandler(app.accountAvatarDeletePost)))
+	mux.Handle("GET /account/password/update", protected.ThenFunc(app.makeHandler(app.accountPasswordUpdate)))
+	mux.Handle("POST /account/password/update", protected.ThenFunc(app.makeHandler(app.accountPasswordUpdatePost)))
+
+	mux.Handle("GET /admin/users/{id}/sessions", admin.ThenFunc(app.makeHandler(app.adminUserSessions)))
+	mux.Handle("POST /admin/users/{id}/sessions/{sessionID}/revoke", admin.ThenFunc(app.makeHandler(app.adminUserSessionRevokePost)))
+	mux.Handle("POST /admin/users/{id}/sessions/revoke-all", admin.ThenFunc(app.makeHandler(app.adminUserSessionsRevokeAllPost)))
+
+	standard := alice.New(app.logRequest, app.instrument(mux), app.traceRequest, app.recoverPanic, app.commonHeaders)
 
 	return standard.Then(mux)
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// serve runs the server until SIGINT or SIGTERM is received. The readiness check fails first,
+// and after the delay the server stops accepting new connections and waits for the in-flight requests
+func (app *application) serve(srv *http.Server, start func() error, shutdownDelay time.Duration, others ...*http.Server) error {
+	shutdownErr := make(chan error, 1)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+		app.shuttingDown.Store(true)
+
+		time.Sleep(shutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+
+		for _, other := range others {
+			err = errors.Join(err, other.Shutdown(ctx))
+		}
+
+		err = errors.Join(err, app.viewRecorder.Shutdown(ctx))
+		err = errors.Join(err, app.tracer.Shutdown(ctx))
+
+		shutdownErr <- err
+	}()
+
+	err := start()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server")
+
+	return nil
+}
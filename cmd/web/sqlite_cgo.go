@@ -0,0 +1,10 @@
+//go:build cgo && !purego
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is the cgo SQLite, build with CGO_ENABLED=0 or the purego tag to use the pure Go one
+const sqliteDriver = "sqlite3"
@@ -0,0 +1,27 @@
+//go:build !cgo || purego
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"modernc.org/sqlite"
+	"strings"
+)
+
+// sqliteDriver is the pure Go SQLite, the binary can be built without cgo and cross-compiled.
+// It is slower than the cgo one, but the data files are the same
+const sqliteDriver = "sqlite"
+
+func init() {
+	// unlike the cgo driver, this one fails right away with SQLITE_BUSY when another connection writes.
+	// Wait for the lock as long as the cgo driver does, unless the DSN sets the timeout with _pragma=busy_timeout(...)
+	sqlite.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+		if strings.Contains(dsn, "busy_timeout") {
+			return nil
+		}
+
+		_, err := conn.ExecContext(context.Background(), "pragma busy_timeout = 5000", []driver.NamedValue{})
+		return err
+	})
+}
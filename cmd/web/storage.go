@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"io/fs"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/models/memory"
+	"snippetbox.doichevkostia.dev/internal/models/postgres"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	schema "snippetbox.doichevkostia.dev/sql"
+	"strings"
+)
+
+// memoryDSN keeps everything in the process memory, the server runs without SQLite and cgo
+const memoryDSN = "memory:"
+
+// storage is the backend of the models and the session data, it is chosen by the scheme of the DSN
+type storage struct {
+	db             *sql.DB // nil for the memory storage
+	snippets       models.SnippetModelInterface
+	collections    models.CollectionModelInterface
+	tags           models.TagModelInterface
+	comments       models.CommentModelInterface
+	stars          models.StarModelInterface
+	views          models.ViewModelInterface
+	users          models.UserModelInterface
+	userSessions   models.UserSessionModelInterface
+	rememberTokens models.RememberTokenModelInterface
+	health         models.HealthModelInterface
+	sessionStore   scs.Store
+	migrate        func(ctx context.Context) (from int, err error) // nil for the memory storage
+}
+
+func isPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// openStorage connects to PostgreSQL for the postgres:// and postgresql:// DSNs, keeps the data in memory for memory:
+// and opens SQLite for everything else. The SQLite driver is chosen at the build time, see sqliteDriver
+func openStorage(cfg config, tracer *trace.Tracer) (*storage, error) {
+	if cfg.DSN == memoryDSN {
+		snippets := memory.NewSnippetModel()
+		users := memory.NewUserModel(cfg.passwordHasher())
+
+		return &storage{
+			snippets:       snippets,
+			collections:    memory.NewCollectionModel(snippets),
+			tags:           memory.NewTagModel(snippets),
+			comments:       memory.NewCommentModel(snippets, users),
+			stars:          memory.NewStarModel(snippets),
+			views:          memory.NewViewModel(snippets),
+			users:          users,
+			userSessions:   memory.NewUserSessionModel(),
+			rememberTokens: memory.NewRememberTokenModel(),
+			health:         &memory.HealthModel{},
+			sessionStore:   memstore.New(),
+		}, nil
+	}
+
+	if isPostgres(cfg.DSN) {
+		db, err := openDB(postgres.DriverName, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage{
+			db:             db,
+			snippets:       &postgres.SnippetModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			collections:    &postgres.CollectionModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			tags:           &postgres.TagModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			comments:       &postgres.CommentModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			stars:          &postgres.StarModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			views:          &postgres.ViewModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			users:          &postgres.UserModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Hasher: cfg.passwordHasher(), Tracer: tracer},
+			userSessions:   &postgres.UserSessionModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			rememberTokens: &postgres.RememberTokenModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			health:         &postgres.HealthModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+			sessionStore:   postgresstore.New(db),
+			migrate: func(ctx context.Context) (int, error) {
+				migrations, err := readMigrations("postgres")
+				if err != nil {
+					return 0, err
+				}
+
+				return postgres.Migrate(ctx, db, migrations)
+			},
+		}, nil
+	}
+
+	db, err := openDB(sqliteDriver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage{
+		db:             db,
+		snippets:       &models.SnippetModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		collections:    &models.CollectionModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		tags:           &models.TagModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		comments:       &models.CommentModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		stars:          &models.StarModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		views:          &models.ViewModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		users:          &models.UserModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Hasher: cfg.passwordHasher(), Tracer: tracer},
+		userSessions:   &models.UserSessionModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		rememberTokens: &models.RememberTokenModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		health:         &models.HealthModel{DB: db, QueryTimeout: cfg.Timeouts.Query, Tracer: tracer},
+		sessionStore:   sqlite3store.New(db),
+		migrate: func(ctx context.Context) (int, error) {
+			migrations, err := readMigrations("sqlite")
+			if err != nil {
+				return 0, err
+			}
+
+			return models.Migrate(ctx, db, migrations)
+		},
+	}, nil
+}
+
+// readMigrations reads the embedded migrations of the dialect, sqlite or postgres
+func readMigrations(dialect string) ([]models.Migration, error) {
+	fsys, err := fs.Sub(schema.Migrations, "migrations/"+dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ReadMigrations(fsys)
+}
+
+func (s *storage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
+
+func openDB(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
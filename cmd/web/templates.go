@@ -1,24 +1,47 @@
 package main
 
 import (
+	"fmt"
+	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
 	"html/template"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"snippetbox.doichevkostia.dev/internal/models"
-	"snippetbox.doichevkostia.dev/ui"
 	"time"
 )
 
+// pagination links the pages of a listing, the zero Prev or Next has no page
+type pagination struct {
+	Page, Prev, Next int
+}
+
 type templateData struct {
 	CurrentYear     int
 	Snippet         models.Snippet
+	Content         template.HTML // the sanitized content of the snippet in the markdown and code formats
 	Snippets        []models.Snippet
+	MostStarred     []models.Snippet // this week
+	Starred         bool             // the snippet by the authenticated user
+	ViewChart       *viewChart       // of the own snippet
+	Collection      models.Collection
+	Collections     []models.Collection
+	Tag             string // the listing is filtered by
+	Comments        []models.Comment
 	Form            any
 	Toast           string
 	IsAuthenticated bool
+	IsAdmin         bool
+	UserID          uuid.UUID // of the authenticated user, zero for the anonymous requests
 	CSRFToken       string
+	User            models.User // of the page, e.g. the author of the snippet or the owner of the profile
+	Pagination      pagination
+	UserSessions    []models.UserSession
+	UserSessionID   uuid.UUID
+	Status          int
+	Error           ApiError
 }
 
 func (app *application) newTemplateData(r *http.Request) templateData {
@@ -26,6 +49,9 @@ func (app *application) newTemplateData(r *http.Request) templateData {
 		CurrentYear:     time.Now().Year(),
 		Toast:           app.sessionManager.PopString(r.Context(), "toast"),
 		IsAuthenticated: app.isAuthenticated(r),
+		IsAdmin:         app.isAdmin(r),
+		UserID:          app.authenticatedUserID(r),
+		UserSessionID:   app.userSessionID(r),
 		CSRFToken:       nosurf.Token(r),
 	}
 }
@@ -38,34 +64,62 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// profilePath is the path of the public profile, the names can have any characters
+func profilePath(name string) string {
+	return "/u/" + url.PathEscape(name)
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":   humanDate,
+	"profilePath": profilePath,
+	"statusText":  http.StatusText,
 }
 
-func newTemplateCache() (map[string]*template.Template, error) {
+func newTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
-	pages, err := fs.Glob(ui.Files, "html/pages/*.gohtml")
+	pages, err := fs.Glob(fsys, "html/pages/*.gohtml")
 	if err != nil {
 		return nil, err
 	}
 
 	for _, page := range pages {
-		name := filepath.Base(page)
-
-		patterns := []string{
-			"html/base.gohtml",
-			"html/partials/*.gohtml",
-			page,
-		}
-
-		tmpl, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
+		tmpl, err := parsePage(fsys, page)
 		if err != nil {
 			return nil, err
 		}
 
-		cache[name] = tmpl
+		cache[filepath.Base(page)] = tmpl
 	}
 
 	return cache, nil
 }
+
+func parsePage(fsys fs.FS, page string) (*template.Template, error) {
+	patterns := []string{
+		"html/base.gohtml",
+		"html/partials/*.gohtml",
+		page,
+	}
+
+	return template.New(filepath.Base(page)).Funcs(functions).ParseFS(fsys, patterns...)
+}
+
+// template returns the parsed page. In the development mode the page is parsed from the disk on every call,
+// so the changes are visible without a restart
+func (app *application) template(page string) (*template.Template, error) {
+	if app.templateFS != nil {
+		if _, err := fs.Stat(app.templateFS, "html/pages/"+page); err != nil {
+			return nil, fmt.Errorf("the template %s doesn't exist", page)
+		}
+
+		return parsePage(app.templateFS, "html/pages/"+page)
+	}
+
+	tmpl, ok := app.templateCache[page]
+	if !ok {
+		return nil, fmt.Errorf("the template %s doesn't exist", page)
+	}
+
+	return tmpl, nil
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"snippetbox.doichevkostia.dev/internal/assert"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -38,3 +41,39 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestDevTemplates(t *testing.T) {
+	app := newTestApplication(t)
+
+	fsys := fstest.MapFS{
+		"html/base.gohtml":         {Data: []byte(`{{define "base"}}<main>{{template "main" .}}</main>{{end}}`)},
+		"html/partials/nav.gohtml": {Data: []byte(`{{define "nav"}}{{end}}`)},
+		"html/pages/home.gohtml":   {Data: []byte(`{{define "main"}}first{{end}}`)},
+		"html/pages/unused.gohtml": {Data: []byte(`{{define "main"}}unused{{end}}`)},
+	}
+	app.templateFS = fsys
+
+	render := func() string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		rr := httptest.NewRecorder()
+
+		err := app.render(rr, r, http.StatusOK, "home.gohtml", templateData{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rr.Body.String()
+	}
+
+	assert.Equal(t, render(), "<main>first</main>")
+
+	// the change is visible without a restart
+	fsys["html/pages/home.gohtml"] = &fstest.MapFile{Data: []byte(`{{define "main"}}second{{end}}`)}
+
+	assert.Equal(t, render(), "<main>second</main>")
+
+	_, err := app.template("missing.gohtml")
+	if err == nil {
+		t.Fatal("expected an error for a missing template")
+	}
+}
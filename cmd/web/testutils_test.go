@@ -4,18 +4,35 @@ import (
 	"bytes"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"html"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"snippetbox.doichevkostia.dev/internal/models/mocks"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"snippetbox.doichevkostia.dev/ui"
 	"testing"
 	"time"
 )
 
+var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
+
+func extractCSRFToken(t *testing.T, body string) string {
+	matches := csrfTokenRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no csrf token found in body")
+	}
+
+	return html.UnescapeString(matches[1])
+}
+
 func newTestApplication(t *testing.T) *application {
-	templateCache, err := newTemplateCache()
+	templateCache, err := newTemplateCache(ui.Files)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -26,13 +43,28 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	appMetrics := newAppMetrics()
+
 	return &application{
-		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		logger:         logger,
 		snippets:       &mocks.SnippetModel{},
+		collections:    &mocks.CollectionModel{},
+		tags:           &mocks.TagModel{},
+		comments:       &mocks.CommentModel{},
+		stars:          &mocks.StarModel{},
+		views:          &mocks.ViewModel{},
+		viewRecorder:   newViewRecorder(&mocks.ViewModel{}, logger, appMetrics.viewsDropped),
 		users:          &mocks.UserModel{},
+		userSessions:   &mocks.UserSessionModel{},
+		rememberTokens: &mocks.RememberTokenModel{},
+		health:         &mocks.HealthModel{},
+		breachList:     password.DefaultBreachList(),
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		metrics:        appMetrics,
+		tlsEnabled:     true,
 	}
 }
 
@@ -74,3 +106,82 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
+	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = bytes.TrimSpace(body)
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// postFile posts the multipart form with the fields and the file in the given field
+func (ts *testServer) postFile(t *testing.T, urlPath string, fields url.Values, field, filename string, data []byte) (int, http.Header, string) {
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+
+	for name, values := range fields {
+		for _, value := range values {
+			err := mw.WriteField(name, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fw.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ts.Client().Post(ts.URL+urlPath, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = bytes.TrimSpace(body)
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// login signs in as the user from the mocks and returns a CSRF token for the following requests
+func (ts *testServer) login(t *testing.T, email, password string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s failed with the status %d", email, code)
+	}
+
+	return csrfToken
+}
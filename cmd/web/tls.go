@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// newACMEManager obtains the certificates on the first TLS handshake for a domain and renews them
+// in the background before they expire. Both the account key and the certificates are cached on disk.
+//
+// The tls-alpn-01 challenge is answered by the TLS listener itself,
+// the http-01 one needs the redirect listener on port 80
+func newACMEManager(cfg config) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.TLS.ACME.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.TLS.ACME.Domains...),
+		Email:      cfg.TLS.ACME.Email,
+		Client:     &acme.Client{DirectoryURL: cfg.TLS.ACME.DirectoryURL},
+	}
+}
+
+// newTLSConfig takes the certificates from the ACME manager if it is set, otherwise from the reloader
+func newTLSConfig(manager *autocert.Manager, reloader *certReloader) *tls.Config {
+	tlsConfig := &tls.Config{}
+	if manager != nil {
+		tlsConfig = manager.TLSConfig()
+	} else if reloader != nil {
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	}
+
+	// some elliptic curves with assembly implementation. Idk what this is yet
+	tlsConfig.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+
+	return tlsConfig
+}
+
+// redirectHTTPS sends the plain HTTP requests to the same host and path on the port of the HTTPS server
+func redirectHTTPS(httpsAddr string) http.Handler {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil || port == "443" {
+		port = ""
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the key pair from the files and replaces it when the files change or on SIGHUP,
+// so that a renewed certificate is picked up without a restart.
+// If the new files can't be loaded, e.g. only one of them was written yet, the old certificate is kept
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	certTime, keyTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.certTime = certTime
+	r.keyTime = keyTime
+
+	return nil
+}
+
+// changed reports whether any of the files was modified since the last successful load
+func (r *certReloader) changed() (bool, error) {
+	certTime, keyTime, err := r.modTimes()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !certTime.Equal(r.certTime) || !keyTime.Equal(r.keyTime), nil
+}
+
+func (r *certReloader) modTimes() (certTime, keyTime time.Time, err error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// watch reloads the certificate when a value is received from the reload channel (SIGHUP)
+// or when the files change, they are checked every interval
+func (r *certReloader) watch(ctx context.Context, logger *slog.Logger, reload <-chan os.Signal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err != nil {
+				logger.Error("failed to check the certificate files", "error", err.Error())
+				continue
+			}
+
+			if !changed {
+				continue
+			}
+		}
+
+		err := r.reload()
+		if err != nil {
+			logger.Error("failed to reload the certificate, the old one is used", "error", err.Error())
+			continue
+		}
+
+		logger.Info("reloaded the certificate", "cert", r.certFile)
+	}
+}
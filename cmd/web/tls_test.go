@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"snippetbox.doichevkostia.dev/internal/acmetest"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		target    string
+		wantURL   string
+	}{
+		{
+			name:      "Default port",
+			httpsAddr: ":443",
+			host:      "example.com",
+			target:    "/snippet/view/1?page=2",
+			wantURL:   "https://example.com/snippet/view/1?page=2",
+		},
+		{
+			name:      "Custom port",
+			httpsAddr: ":4000",
+			host:      "example.com:8080",
+			target:    "/",
+			wantURL:   "https://example.com:4000/",
+		},
+		{
+			name:      "IPv6 host",
+			httpsAddr: "[::1]:4000",
+			host:      "[::1]:8080",
+			target:    "/user/login",
+			wantURL:   "https://[::1]:4000/user/login",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			r.Host = tt.host
+
+			redirectHTTPS(tt.httpsAddr).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, http.StatusPermanentRedirect)
+			assert.Equal(t, rr.Header().Get("Location"), tt.wantURL)
+		})
+	}
+}
+
+// startACME starts the HTTPS server with the certificates from ACME and the redirect server for the http-01 challenges
+func startACME(t *testing.T, ca *acmetest.Server, cfg config) (tlsAddr string) {
+	manager := newACMEManager(cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+		}),
+		TLSConfig: newTLSConfig(manager, nil),
+		ErrorLog:  log.New(io.Discard, "", 0),
+	}
+
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+
+	redirect := httptest.NewServer(manager.HTTPHandler(redirectHTTPS(ln.Addr().String())))
+	t.Cleanup(redirect.Close)
+
+	ca.Resolve(redirect.Listener.Addr().String(), ln.Addr().String())
+
+	return ln.Addr().String()
+}
+
+func getWithSNI(t *testing.T, ca *acmetest.Server, addr, serverName string) (string, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName: serverName,
+				RootCAs:    ca.Roots(),
+			},
+		},
+	}
+
+	rs, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	return string(body), err
+}
+
+func TestACME(t *testing.T) {
+	const domain = "snippetbox.test"
+
+	tests := []struct {
+		name      string
+		challenge string
+	}{
+		{name: "TLS-ALPN-01", challenge: acmetest.ChallengeTLSALPN01},
+		{name: "HTTP-01", challenge: acmetest.ChallengeHTTP01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, err := acmetest.NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ca.Close()
+
+			ca.OfferChallenges(tt.challenge)
+
+			cfg := defaultConfig()
+			cfg.TLS.ACME.Domains = stringList{domain}
+			cfg.TLS.ACME.CacheDir = t.TempDir()
+			cfg.TLS.ACME.DirectoryURL = ca.URL()
+
+			addr := startACME(t, ca, cfg)
+
+			body, err := getWithSNI(t, ca, addr, domain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, body, "OK")
+			assert.Equal(t, len(ca.Issued()), 1)
+
+			_, err = os.Stat(filepath.Join(cfg.TLS.ACME.CacheDir, domain))
+			if err != nil {
+				t.Errorf("certificate is not cached: %s", err)
+			}
+
+			// a restarted server takes the certificate from the cache
+			addr = startACME(t, ca, cfg)
+
+			body, err = getWithSNI(t, ca, addr, domain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, body, "OK")
+			assert.Equal(t, len(ca.Issued()), 1)
+		})
+	}
+
+	t.Run("Unknown domain", func(t *testing.T) {
+		ca, err := acmetest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ca.Close()
+
+		cfg := defaultConfig()
+		cfg.TLS.ACME.Domains = stringList{domain}
+		cfg.TLS.ACME.CacheDir = t.TempDir()
+		cfg.TLS.ACME.DirectoryURL = ca.URL()
+
+		addr := startACME(t, ca, cfg)
+
+		_, err = getWithSNI(t, ca, addr, "evil.test")
+		if err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+
+		assert.Equal(t, len(ca.Issued()), 0)
+	})
+}
+
+// writeKeyPair writes a self-signed certificate with the serial number and its key
+func writeKeyPair(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the modification time can have a coarse resolution, the files must look changed for the reloader
+	modTime := time.Now().Add(time.Duration(serial) * time.Minute)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func certSerial(t *testing.T, r *certReloader) int64 {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeKeyPair(t, certFile, keyFile, 1)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, certSerial(t, r), 1)
+
+	changed, err := r.changed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, changed, false)
+
+	writeKeyPair(t, certFile, keyFile, 2)
+
+	changed, err = r.changed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, changed, true)
+
+	err = r.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, certSerial(t, r), 2)
+
+	// a broken file doesn't replace the working certificate
+	err = os.WriteFile(certFile, []byte("not a certificate"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.reload()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, certSerial(t, r), 2)
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeKeyPair(t, certFile, keyFile, 1)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sighup := make(chan os.Signal)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// the interval is long, so that only the signal triggers the reload
+	go r.watch(ctx, logger, sighup, time.Hour)
+
+	writeKeyPair(t, certFile, keyFile, 2)
+	sighup <- os.Interrupt
+
+	deadline := time.Now().Add(5 * time.Second)
+	for certSerial(t, r) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the certificate wasn't reloaded")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"snippetbox.doichevkostia.dev/internal/metrics"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	viewQueueSize     = 1024
+	viewBatchSize     = 100
+	viewFlushInterval = time.Second
+)
+
+// viewRecorder writes the views of the snippets in the background, so that the pages don't wait for the database.
+// The views are dropped when the queue is full, the analytics are not worth slowing down the pages
+type viewRecorder struct {
+	views   models.ViewModelInterface
+	logger  *slog.Logger
+	dropped *metrics.CounterVec
+
+	mu     sync.RWMutex // guards the queue against the sends after it is closed
+	closed bool
+	queue  chan models.View
+	done   chan struct{}
+
+	keyMu  sync.Mutex
+	keyDay string
+	key    []byte // the secret of the visitor hashes of keyDay, see visitorKey
+}
+
+// newViewRecorder starts the writer of the views, Shutdown stops it
+func newViewRecorder(views models.ViewModelInterface, logger *slog.Logger, dropped *metrics.CounterVec) *viewRecorder {
+	vr := &viewRecorder{
+		views:   views,
+		logger:  logger,
+		dropped: dropped,
+		queue:   make(chan models.View, viewQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	go vr.run()
+
+	return vr
+}
+
+// record queues the view of the snippet by the client of the request, it never blocks
+func (vr *viewRecorder) record(r *http.Request, snippetID uuid.UUID) {
+	now := time.Now().UTC()
+	day := now.Format(models.DayFormat)
+
+	key, err := vr.visitorKey(day)
+	if err != nil {
+		vr.logger.Error("failed to generate the visitor key", "error", err.Error())
+		vr.dropped.WithLabelValues().Inc()
+		return
+	}
+
+	v := models.View{
+		SnippetID: snippetID,
+		Visitor:   visitorHash(key, clientIP(r), day),
+		Time:      now,
+	}
+
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+
+	if vr.closed {
+		vr.dropped.WithLabelValues().Inc()
+		return
+	}
+
+	select {
+	case vr.queue <- v:
+	default:
+		vr.dropped.WithLabelValues().Inc()
+	}
+}
+
+// run writes the views in batches, a batch is written when it is full or after the flush interval
+func (vr *viewRecorder) run() {
+	defer close(vr.done)
+
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.View, 0, viewBatchSize)
+
+	for {
+		select {
+		case v, ok := <-vr.queue:
+			if !ok {
+				vr.flush(batch)
+				return
+			}
+
+			batch = append(batch, v)
+			if len(batch) == viewBatchSize {
+				vr.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			vr.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (vr *viewRecorder) flush(batch []models.View) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the model limits the query, the writer doesn't depend on any request
+	err := vr.views.Insert(context.Background(), batch)
+	if err != nil {
+		vr.logger.Error("failed to record the snippet views", "views", len(batch), "error", err.Error())
+	}
+}
+
+// Shutdown writes the queued views and stops the writer, the views recorded after it are dropped
+func (vr *viewRecorder) Shutdown(ctx context.Context) error {
+	if vr == nil {
+		return nil
+	}
+
+	vr.mu.Lock()
+	if !vr.closed {
+		vr.closed = true
+		close(vr.queue)
+	}
+	vr.mu.Unlock()
+
+	select {
+	case <-vr.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// visitorKey returns the secret of the visitor hashes of the day, a new random one every day. The key is only in memory:
+// without it the hashes can't be turned back into the addresses by hashing all of them. After a restart the visitors
+// of the day get new hashes and are counted again
+func (vr *viewRecorder) visitorKey(day string) ([]byte, error) {
+	vr.keyMu.Lock()
+	defer vr.keyMu.Unlock()
+
+	if vr.keyDay != day {
+		key := make([]byte, 32)
+
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		vr.keyDay, vr.key = day, key
+	}
+
+	return vr.key, nil
+}
+
+// visitorHash identifies the visitor of the day without storing the IP address, it is an HMAC with the key of the day.
+// The day is a part of the hash too, so the visits of one address on different days are not linked
+func visitorHash(key []byte, ip, day string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(day + " " + ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	viewChartDays   = 30
+	viewChartWidth  = 600
+	viewChartHeight = 100
+)
+
+// viewChart is the SVG chart of the daily views, it is drawn by the template without any script or inline style
+type viewChart struct {
+	Total  int
+	Max    int // views on the busiest day, the height of the chart
+	Width  int
+	Height int
+	Bars   []viewBar
+}
+
+type viewBar struct {
+	X, Y, Width, Height int
+	Day                 time.Time
+	Views               int
+}
+
+func newViewChart(stats models.ViewStats) viewChart {
+	chart := viewChart{
+		Total:  stats.Total,
+		Width:  viewChartWidth,
+		Height: viewChartHeight,
+	}
+
+	for _, d := range stats.Daily {
+		chart.Max = max(chart.Max, d.Views)
+	}
+
+	if len(stats.Daily) == 0 {
+		return chart
+	}
+
+	step := viewChartWidth / len(stats.Daily)
+
+	for i, d := range stats.Daily {
+		height := 0
+		if chart.Max > 0 {
+			height = d.Views * viewChartHeight / chart.Max
+		}
+
+		chart.Bars = append(chart.Bars, viewBar{
+			X:      i * step,
+			Y:      viewChartHeight - height,
+			Width:  max(step-2, 1),
+			Height: height,
+			Day:    d.Day,
+			Views:  d.Views,
+		})
+	}
+
+	return chart
+}
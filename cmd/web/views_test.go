@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/models/memory"
+	"testing"
+	"time"
+)
+
+func TestViewRecorder(t *testing.T) {
+	snippets := memory.NewSnippetModel()
+	views := memory.NewViewModel(snippets)
+
+	snippetID, err := snippets.Insert(context.Background(), uuid.New(), "Viewed", "Viewed", models.FormatPlain, models.VisibilityPublic, nil, nil, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vr := newViewRecorder(views, slog.New(slog.NewTextHandler(io.Discard, nil)), newAppMetrics().viewsDropped)
+
+	for _, addr := range []string{"192.0.2.1:1234", "192.0.2.1:5678", "192.0.2.2:1234"} {
+		r := httptest.NewRequest("GET", "/snippet/view/"+snippetID.String(), nil)
+		r.RemoteAddr = addr
+
+		vr.record(r, snippetID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the queued views are written on shutdown
+	err = vr.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := views.Stats(context.Background(), snippetID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, stats.Total, 2)
+
+	// the views after the shutdown are dropped, not sent to the closed queue
+	vr.record(httptest.NewRequest("GET", "/", nil), snippetID)
+}
+
+func TestVisitorHash(t *testing.T) {
+	key := []byte("key")
+	hash := visitorHash(key, "192.0.2.1", "2024-01-01")
+
+	assert.Equal(t, len(hash), 64)
+	assert.Equal(t, visitorHash(key, "192.0.2.1", "2024-01-01"), hash)
+	assert.Equal(t, visitorHash(key, "192.0.2.1", "2024-01-02") == hash, false)
+	assert.Equal(t, visitorHash(key, "192.0.2.2", "2024-01-01") == hash, false)
+	// without the key the hash can't be recomputed from the address
+	assert.Equal(t, visitorHash([]byte("other"), "192.0.2.1", "2024-01-01") == hash, false)
+}
+
+func TestVisitorKey(t *testing.T) {
+	vr := &viewRecorder{}
+
+	key, err := vr.visitorKey("2024-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(key), 32)
+
+	same, err := vr.visitorKey("2024-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, string(same), string(key))
+
+	next, err := vr.visitorKey("2024-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, string(next) == string(key), false)
+}
+
+func TestNewViewChart(t *testing.T) {
+	stats := models.ViewStats{
+		Total: 6,
+		Daily: []models.DailyViews{{Views: 0}, {Views: 4}, {Views: 2}},
+	}
+
+	chart := newViewChart(stats)
+
+	assert.Equal(t, chart.Total, 6)
+	assert.Equal(t, chart.Max, 4)
+	assert.Equal(t, len(chart.Bars), 3)
+	assert.Equal(t, chart.Bars[0].Height, 0)
+	assert.Equal(t, chart.Bars[1].Height, viewChartHeight)
+	assert.Equal(t, chart.Bars[1].Y, 0)
+	assert.Equal(t, chart.Bars[2].Height, viewChartHeight/2)
+	assert.Equal(t, chart.Bars[2].X, 2*viewChartWidth/3)
+}
@@ -0,0 +1,749 @@
+// Package acmetest provides an in-process ACME (RFC 8555) server, so that the certificate issuance can be tested
+// without the network. The certificates are signed by a CA generated in memory.
+//
+// The challenges are validated for real, but against the addresses set with Resolve instead of the DNS.
+// The JWS signatures of the requests are not verified.
+package acmetest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// CertLifetime is the validity of the issued certificates
+const CertLifetime = 90 * 24 * time.Hour
+
+// idPeACMEIdentifier is the extension of the tls-alpn-01 challenge certificate, RFC 8737
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type Server struct {
+	ts *httptest.Server
+
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	mu             sync.Mutex
+	challengeTypes []string
+	httpAddr       string
+	tlsAddr        string
+	nextID         int
+	accounts       map[string]string // thumbprint of the account key by the account url
+	orders         map[string]*order
+	authzs         map[string]*authz
+	challenges     map[string]*challenge
+	certs          map[string][]byte
+	issued         []string
+}
+
+type order struct {
+	id          string
+	status      string
+	domains     []string
+	authzs      []*authz
+	certificate string
+}
+
+type authz struct {
+	id         string
+	status     string
+	domain     string
+	order      *order
+	challenges []*challenge
+}
+
+type challenge struct {
+	id     string
+	typ    string
+	token  string
+	status string
+	err    string
+	authz  *authz
+}
+
+// NewServer starts the server, both challenge types are offered until OfferChallenges is called
+func NewServer() (*Server, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * CertLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		caCert:         caCert,
+		caKey:          caKey,
+		challengeTypes: []string{ChallengeTLSALPN01, ChallengeHTTP01},
+		accounts:       make(map[string]string),
+		orders:         make(map[string]*order),
+		authzs:         make(map[string]*authz),
+		challenges:     make(map[string]*challenge),
+		certs:          make(map[string][]byte),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.directory)
+	mux.HandleFunc("/new-nonce", s.newNonce)
+	mux.HandleFunc("POST /new-account", s.newAccount)
+	mux.HandleFunc("POST /new-order", s.newOrder)
+	mux.HandleFunc("POST /order/{id}", s.getOrder)
+	mux.HandleFunc("POST /authz/{id}", s.getAuthz)
+	mux.HandleFunc("POST /challenge/{id}", s.acceptChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.finalize)
+	mux.HandleFunc("POST /cert/{id}", s.getCert)
+
+	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", randomString())
+		w.Header().Set("Cache-Control", "no-store")
+		mux.ServeHTTP(w, r)
+	}))
+
+	return s, nil
+}
+
+// URL of the ACME directory
+func (s *Server) URL() string {
+	return s.ts.URL + "/directory"
+}
+
+func (s *Server) Close() {
+	s.ts.Close()
+}
+
+// Roots contains the CA that signs the issued certificates
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.caCert)
+	return pool
+}
+
+// Resolve sets where the challenges of every domain are validated, the http-01 at httpAddr and the tls-alpn-01 at tlsAddr
+func (s *Server) Resolve(httpAddr, tlsAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.httpAddr = httpAddr
+	s.tlsAddr = tlsAddr
+}
+
+// OfferChallenges limits the challenge types offered for the new authorizations
+func (s *Server) OfferChallenges(types ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.challengeTypes = types
+}
+
+// Issued returns the first domain of every issued certificate
+func (s *Server) Issued() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.issued)
+}
+
+func (s *Server) directory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   s.ts.URL + "/new-nonce",
+		"newAccount": s.ts.URL + "/new-account",
+		"newOrder":   s.ts.URL + "/new-order",
+		"meta": map[string]any{
+			"termsOfService": s.ts.URL + "/terms",
+		},
+	})
+}
+
+func (s *Server) newNonce(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type jwsHeader struct {
+	JWK json.RawMessage `json:"jwk"`
+	KID string          `json:"kid"`
+}
+
+// readJWS decodes the payload into the given value and returns the protected header. The account of the kid must exist
+func (s *Server) readJWS(r *http.Request, payload any) (header jwsHeader, err error) {
+	var body struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return jwsHeader{}, err
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		return jwsHeader{}, err
+	}
+
+	err = json.Unmarshal(protected, &header)
+	if err != nil {
+		return jwsHeader{}, err
+	}
+
+	if header.KID != "" {
+		s.mu.Lock()
+		_, ok := s.accounts[header.KID]
+		s.mu.Unlock()
+
+		if !ok {
+			return jwsHeader{}, errors.New("account does not exist")
+		}
+	}
+
+	// POST-as-GET has an empty payload
+	if body.Payload == "" || payload == nil {
+		return header, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(body.Payload)
+	if err != nil {
+		return jwsHeader{}, err
+	}
+
+	return header, json.Unmarshal(raw, payload)
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+
+	header, err := s.readJWS(r, &payload)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	thumbprint, err := jwkThumbprint(header.JWK)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for url, t := range s.accounts {
+		if t == thumbprint {
+			w.Header().Set("Location", url)
+			writeJSON(w, http.StatusOK, map[string]any{"status": "valid"})
+			return
+		}
+	}
+
+	if payload.OnlyReturnExisting {
+		writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for the key")
+		return
+	}
+
+	url := s.url("account", s.id())
+	s.accounts[url] = thumbprint
+
+	w.Header().Set("Location", url)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"status":  "valid",
+		"contact": payload.Contact,
+	})
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Identifiers []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+
+	_, err := s.readJWS(r, &payload)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	if len(payload.Identifiers) == 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &order{id: s.id(), status: "pending"}
+
+	for _, id := range payload.Identifiers {
+		if id.Type != "dns" {
+			writeProblem(w, http.StatusBadRequest, "unsupportedIdentifier", id.Type)
+			return
+		}
+
+		z := &authz{id: s.id(), status: "pending", domain: id.Value, order: o}
+
+		for _, typ := range s.challengeTypes {
+			c := &challenge{id: s.id(), typ: typ, token: randomString(), status: "pending", authz: z}
+			z.challenges = append(z.challenges, c)
+			s.challenges[c.id] = c
+		}
+
+		o.domains = append(o.domains, id.Value)
+		o.authzs = append(o.authzs, z)
+		s.authzs[z.id] = z
+	}
+
+	s.orders[o.id] = o
+
+	w.Header().Set("Location", s.url("order", o.id))
+	writeJSON(w, http.StatusCreated, s.orderJSON(o))
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	_, err := s.readJWS(r, nil)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.orderJSON(o))
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Status string `json:"status"`
+	}
+
+	_, err := s.readJWS(r, &payload)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := s.authzs[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+
+	if payload.Status == "deactivated" {
+		z.status = "deactivated"
+	}
+
+	writeJSON(w, http.StatusOK, s.authzJSON(z))
+}
+
+// acceptChallenge validates the challenge right away, so the client never has to wait
+func (s *Server) acceptChallenge(w http.ResponseWriter, r *http.Request) {
+	header, err := s.readJWS(r, nil)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	c, ok := s.challenges[r.PathValue("id")]
+	thumbprint := s.accounts[header.KID]
+	httpAddr, tlsAddr := s.httpAddr, s.tlsAddr
+	s.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+
+	keyAuth := c.token + "." + thumbprint
+
+	switch c.typ {
+	case ChallengeHTTP01:
+		err = validateHTTP01(httpAddr, c.authz.domain, c.token, keyAuth)
+	case ChallengeTLSALPN01:
+		err = validateTLSALPN01(tlsAddr, c.authz.domain, keyAuth)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		c.status = "invalid"
+		c.err = err.Error()
+		c.authz.status = "invalid"
+		c.authz.order.status = "invalid"
+	} else {
+		c.status = "valid"
+		c.authz.status = "valid"
+
+		ready := true
+		for _, z := range c.authz.order.authzs {
+			ready = ready && z.status == "valid"
+		}
+
+		if ready {
+			c.authz.order.status = "ready"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.challengeJSON(c))
+}
+
+func (s *Server) finalize(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+
+	_, err := s.readJWS(r, &payload)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+
+	if o.status != "ready" {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", "order is "+o.status)
+		return
+	}
+
+	names := slices.Clone(csr.DNSNames)
+	slices.Sort(names)
+	domains := slices.Clone(o.domains)
+	slices.Sort(domains)
+
+	if !slices.Equal(names, domains) {
+		writeProblem(w, http.StatusBadRequest, "badCSR", "names of the CSR don't match the order")
+		return
+	}
+
+	chain, err := s.issue(csr)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+
+	certID := s.id()
+	s.certs[certID] = chain
+	s.issued = append(s.issued, o.domains[0])
+
+	o.status = "valid"
+	o.certificate = s.url("cert", certID)
+
+	w.Header().Set("Location", s.url("order", o.id))
+	writeJSON(w, http.StatusOK, s.orderJSON(o))
+}
+
+func (s *Server) getCert(w http.ResponseWriter, r *http.Request) {
+	_, err := s.readJWS(r, nil)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	chain, ok := s.certs[r.PathValue("id")]
+	s.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+// issue signs the leaf certificate and returns it in PEM together with the CA
+func (s *Server) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(CertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})
+
+	return buf.Bytes(), nil
+}
+
+func validateHTTP01(addr, domain, token, keyAuth string) error {
+	if addr == "" {
+		return errors.New("no address to validate http-01")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/.well-known/acme-challenge/"+token, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = domain
+
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != keyAuth {
+		return fmt.Errorf("unexpected http-01 response: %d %q", res.StatusCode, body)
+	}
+
+	return nil
+}
+
+func validateTLSALPN01(addr, domain, keyAuth string) error {
+	if addr == "" {
+		return errors.New("no address to validate tls-alpn-01")
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true, // the challenge certificate is self-signed
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "acme-tls/1" {
+		return fmt.Errorf("unexpected protocol %q", state.NegotiatedProtocol)
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	want, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return err
+	}
+
+	leaf := state.PeerCertificates[0]
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(idPeACMEIdentifier) {
+			if !ext.Critical || !bytes.Equal(ext.Value, want) {
+				return errors.New("acme identifier extension doesn't match")
+			}
+
+			return nil
+		}
+	}
+
+	return errors.New("no acme identifier extension")
+}
+
+// jwkThumbprint implements RFC 7638 for the EC and RSA keys
+func jwkThumbprint(raw json.RawMessage) (string, error) {
+	var jwk map[string]string
+
+	err := json.Unmarshal(raw, &jwk)
+	if err != nil {
+		return "", err
+	}
+
+	var canonical string
+
+	switch jwk["kty"] {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk["crv"], jwk["x"], jwk["y"])
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk["e"], jwk["n"])
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk["kty"])
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (s *Server) orderJSON(o *order) map[string]any {
+	var identifiers []map[string]string
+	var authzs []string
+
+	for _, z := range o.authzs {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": z.domain})
+		authzs = append(authzs, s.url("authz", z.id))
+	}
+
+	v := map[string]any{
+		"status":         o.status,
+		"identifiers":    identifiers,
+		"authorizations": authzs,
+		"finalize":       s.url("finalize", o.id),
+	}
+
+	if o.certificate != "" {
+		v["certificate"] = o.certificate
+	}
+
+	return v
+}
+
+func (s *Server) authzJSON(z *authz) map[string]any {
+	var challenges []map[string]any
+	for _, c := range z.challenges {
+		challenges = append(challenges, s.challengeJSON(c))
+	}
+
+	return map[string]any{
+		"status":     z.status,
+		"identifier": map[string]string{"type": "dns", "value": z.domain},
+		"expires":    time.Now().Add(time.Hour),
+		"challenges": challenges,
+	}
+}
+
+func (s *Server) challengeJSON(c *challenge) map[string]any {
+	v := map[string]any{
+		"type":   c.typ,
+		"url":    s.url("challenge", c.id),
+		"token":  c.token,
+		"status": c.status,
+	}
+
+	if c.err != "" {
+		v["error"] = map[string]any{
+			"type":   "urn:ietf:params:acme:error:incorrectResponse",
+			"detail": c.err,
+			"status": http.StatusForbidden,
+		}
+	}
+
+	return v
+}
+
+// id must be called with the lock held
+func (s *Server) id() string {
+	s.nextID++
+	return fmt.Sprint(s.nextID)
+}
+
+func (s *Server) url(kind, id string) string {
+	return s.ts.URL + "/" + kind + "/" + id
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": detail,
+		"status": status,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
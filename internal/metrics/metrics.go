@@ -0,0 +1,426 @@
+// Package metrics implements the counters, gauges and histograms with labels,
+// and exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the histogram buckets (in seconds) suitable for the request latencies
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+type collector interface {
+	name() string
+	write(w io.Writer) error
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: %s is already registered", c.name()))
+		}
+	}
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all the metrics sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	for _, c := range collectors {
+		err := c.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// desc is the part shared by all the metrics
+type desc struct {
+	metricName string
+	help       string
+	typ        metricType
+	labelNames []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, d.typ)
+	return err
+}
+
+// vec holds the children of a metric, one for every combination of the label values
+type vec[T any] struct {
+	desc
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+	newChild func() *T
+}
+
+func (v *vec[T]) with(labelValues ...string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = slices.Clone(labelValues)
+	}
+
+	return child
+}
+
+// each calls f for every child sorted by the label values
+func (v *vec[T]) each(f func(labelValues []string, child *T) error) error {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.Lock()
+		child, values := v.children[key], v.values[key]
+		v.mu.Unlock()
+
+		err := f(values, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newVec[T any](name, help string, typ metricType, labelNames []string, newChild func() *T) vec[T] {
+	return vec[T]{
+		desc: desc{
+			metricName: name,
+			help:       help,
+			typ:        typ,
+			labelNames: labelNames,
+		},
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+// value is a float64 that can be updated concurrently
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *value) set(n float64) {
+	v.mu.Lock()
+	v.v = n
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add panics if the delta is negative, counters can only go up
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+type CounterVec struct {
+	vec[Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, typeCounter, labelNames, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) WithLabelValues(labelValues ...string) *Counter {
+	return c.with(labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	err := c.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	return c.each(func(labelValues []string, child *Counter) error {
+		return writeSample(w, c.metricName, c.labelNames, labelValues, child.get())
+	})
+}
+
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+func (g *Gauge) Set(n float64) {
+	g.set(n)
+}
+
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, typeGauge, labelNames, func() *Gauge { return &Gauge{} })}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) WithLabelValues(labelValues ...string) *Gauge {
+	return g.with(labelValues...)
+}
+
+func (g *GaugeVec) write(w io.Writer) error {
+	err := g.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	return g.each(func(labelValues []string, child *Gauge) error {
+		return writeSample(w, g.metricName, g.labelNames, labelValues, child.get())
+	})
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds of the buckets, the +Inf bucket is added implicitly
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	h := &HistogramVec{newVec(name, help, typeHistogram, labelNames, func() *Histogram {
+		return &Histogram{
+			buckets: buckets,
+			counts:  make([]uint64, len(buckets)),
+		}
+	})}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	return h.with(labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	err := h.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	bucketLabels := append(slices.Clone(h.labelNames), "le")
+
+	return h.each(func(labelValues []string, child *Histogram) error {
+		child.mu.Lock()
+		counts := slices.Clone(child.counts)
+		sum, count := child.sum, child.count
+		child.mu.Unlock()
+
+		for i, upperBound := range child.buckets {
+			err := writeSample(w, h.metricName+"_bucket", bucketLabels, append(slices.Clone(labelValues), formatFloat(upperBound)), float64(counts[i]))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := writeSample(w, h.metricName+"_bucket", bucketLabels, append(slices.Clone(labelValues), "+Inf"), float64(count))
+		if err != nil {
+			return err
+		}
+
+		err = writeSample(w, h.metricName+"_sum", h.labelNames, labelValues, sum)
+		if err != nil {
+			return err
+		}
+
+		return writeSample(w, h.metricName+"_count", h.labelNames, labelValues, float64(count))
+	})
+}
+
+// funcMetric reads the value when the metrics are scraped, e.g. from sql.DB.Stats()
+type funcMetric struct {
+	desc
+	f func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{desc{metricName: name, help: help, typ: typeGauge}, f})
+}
+
+// NewCounterFunc registers a counter that is maintained elsewhere, f must never return a smaller value
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{desc{metricName: name, help: help, typ: typeCounter}, f})
+}
+
+func (m *funcMetric) write(w io.Writer) error {
+	err := m.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	return writeSample(w, m.metricName, nil, nil, m.f())
+}
+
+func writeSample(w io.Writer, name string, labelNames, labelValues []string, v float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+
+	if len(labelNames) > 0 {
+		b.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labelName)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(labelValues[i]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
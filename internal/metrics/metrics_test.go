@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"sync"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("http_requests_total", "Total number of requests.", "route", "code")
+	requests.WithLabelValues("GET /{$}", "200").Inc()
+	requests.WithLabelValues("GET /{$}", "200").Inc()
+	requests.WithLabelValues(`GET /snippet/view/{id}`, "404").Add(3)
+
+	inFlight := registry.NewGaugeVec("http_requests_in_flight", "Requests being served.")
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Dec()
+
+	duration := registry.NewHistogramVec("http_request_duration_seconds", "Latency.", []float64{0.5, 0.1}, "route")
+	duration.WithLabelValues("/ping").Observe(0.05)
+	duration.WithLabelValues("/ping").Observe(0.3)
+	duration.WithLabelValues("/ping").Observe(2)
+
+	registry.NewGaugeFunc("db_open_connections", "Open connections.\nWith a new line", func() float64 { return 4 })
+
+	var buf bytes.Buffer
+	err := registry.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP db_open_connections Open connections.\nWith a new line
+# TYPE db_open_connections gauge
+db_open_connections 4
+# HELP http_request_duration_seconds Latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="/ping",le="0.1"} 1
+http_request_duration_seconds_bucket{route="/ping",le="0.5"} 2
+http_request_duration_seconds_bucket{route="/ping",le="+Inf"} 3
+http_request_duration_seconds_sum{route="/ping"} 2.35
+http_request_duration_seconds_count{route="/ping"} 3
+# HELP http_requests_in_flight Requests being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 1
+# HELP http_requests_total Total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{route="GET /snippet/view/{id}",code="404"} 3
+http_requests_total{route="GET /{$}",code="200"} 2
+`
+
+	assert.Equal(t, buf.String(), want)
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, escapeLabelValue(`a"b\c`+"\n"), `a\"b\\c\n`)
+}
+
+func TestCounterConcurrency(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("total", "Total.", "label")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.WithLabelValues("x").Inc()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, counter.WithLabelValues("x").get(), float64(50))
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	registry := NewRegistry()
+	registry.NewCounterVec("total", "Total.")
+	registry.NewGaugeVec("total", "Total.")
+}
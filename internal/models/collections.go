@@ -0,0 +1,384 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// CollectionModelInterface organizes the snippets of a user into named collections. A collection only links
+// to the snippets, the expired ones stay linked but are left out of Snippets the same way as of the Latest.
+// The changes require the user id, so that one user can't modify the collection of another one
+type CollectionModelInterface interface {
+	Insert(ctx context.Context, userID uuid.UUID, name string, visibility Visibility) (uuid.UUID, error)
+	Get(ctx context.Context, id uuid.UUID) (Collection, error)
+	ForUser(ctx context.Context, userID uuid.UUID) ([]Collection, error)
+	Update(ctx context.Context, userID, id uuid.UUID, name string, visibility Visibility) error
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	AddSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error
+	RemoveSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error
+	Reorder(ctx context.Context, userID, id uuid.UUID, snippetIDs []uuid.UUID) error
+	Snippets(ctx context.Context, id uuid.UUID, tag string) ([]Snippet, error)
+}
+
+// Visibility decides who can see a collection besides the owner
+type Visibility string
+
+const (
+	VisibilityPublic   Visibility = "public"
+	VisibilityUnlisted Visibility = "unlisted" // everyone with the link, but not listed anywhere
+	VisibilityPrivate  Visibility = "private"
+)
+
+type Collection struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	Visibility Visibility
+	CreateTime time.Time
+}
+
+// VisibleTo reports whether the user can see the collection, the zero id is an anonymous user
+func (c Collection) VisibleTo(userID uuid.UUID) bool {
+	return c.Visibility != VisibilityPrivate || c.UserID == userID
+}
+
+// OrderSnippets returns the snippets of a collection in the new order. The snippets in order come first,
+// the rest keeps the current order after them, so that the expired snippets nobody sees are not lost.
+// ErrNoRecord means a snippet in order is not in the collection
+func OrderSnippets(current, order []uuid.UUID) ([]uuid.UUID, error) {
+	rest := make(map[uuid.UUID]bool, len(current))
+	for _, id := range current {
+		rest[id] = true
+	}
+
+	ordered := make([]uuid.UUID, 0, len(current))
+
+	for _, id := range order {
+		if !rest[id] {
+			return nil, ErrNoRecord
+		}
+
+		delete(rest, id)
+		ordered = append(ordered, id)
+	}
+
+	for _, id := range current {
+		if rest[id] {
+			ordered = append(ordered, id)
+		}
+	}
+
+	return ordered, nil
+}
+
+type CollectionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID uuid.UUID, name string, visibility Visibility) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "collections" ("id", "user_id", "name", "visibility") values (?, ?, ?, ?)`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id, userID, name, visibility)
+	if err != nil {
+		if uniqueViolation(err, "idx_collections_user_id_name") {
+			return uuid.UUID{}, ErrDuplicateName
+		} else {
+			return uuid.UUID{}, err
+		}
+	}
+
+	return id, nil
+}
+
+func (m *CollectionModel) Get(ctx context.Context, id uuid.UUID) (_ Collection, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "name", "visibility", "create_time" from "collections" where id = ?`
+
+	var c Collection
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&c.ID, &c.UserID, &c.Name, &c.Visibility, &c.CreateTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Collection{}, ErrNoRecord
+		} else {
+			return Collection{}, err
+		}
+	}
+
+	return c, nil
+}
+
+func (m *CollectionModel) ForUser(ctx context.Context, userID uuid.UUID) (_ []Collection, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "name", "visibility", "create_time" from "collections"
+	where user_id = ? order by lower("name")`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var collections []Collection
+
+	for rows.Next() {
+		var c Collection
+
+		err = rows.Scan(&c.ID, &c.UserID, &c.Name, &c.Visibility, &c.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Update renames the collection and changes its visibility
+func (m *CollectionModel) Update(ctx context.Context, userID, id uuid.UUID, name string, visibility Visibility) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "collections" set "name" = ?, "visibility" = ? where id = ? and user_id = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, name, visibility, id, userID)
+	if err != nil {
+		if uniqueViolation(err, "idx_collections_user_id_name") {
+			return ErrDuplicateName
+		} else {
+			return err
+		}
+	}
+
+	return expectAffected(result)
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from "collections" where id = ? and user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectAffected(result)
+	if err != nil {
+		return err
+	}
+
+	// SQLite doesn't enforce the foreign keys unless asked to, the links are not left to the cascade
+	_, err = tx.ExecContext(ctx, `delete from "collection_snippets" where collection_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddSnippet puts the snippet at the end of the collection. Adding a snippet that is already there changes nothing,
+// ErrNoRecord means that the collection of the user or the snippet visible to the user doesn't exist
+func (m *CollectionModel) AddSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.AddSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "collection_snippets" ("collection_id", "snippet_id", "position")
+	select c.id, s.id, coalesce((select max(position) + 1 from "collection_snippets" where collection_id = c.id), 0)
+	from "collections" c, "snippets" s
+	where c.id = ? and c.user_id = ? and s.id = ? and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = c.user_id)
+	on conflict do nothing`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	err = expectAffected(result)
+	if !errors.Is(err, ErrNoRecord) {
+		return err
+	}
+
+	// nothing was inserted, either the snippet is already in the collection or one of them doesn't exist
+	var exists bool
+
+	err = m.DB.QueryRowContext(ctx, `select exists(select true from "collection_snippets" cs join "collections" c on c.id = cs.collection_id
+	where cs.collection_id = ? and cs.snippet_id = ? and c.user_id = ?)`, id, snippetID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.RemoveSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "collection_snippets" where collection_id = ? and snippet_id = ?
+	and exists(select true from "collections" where id = ? and user_id = ?)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, snippetID, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Reorder moves the snippets to the front of the collection in the given order, see OrderSnippets
+func (m *CollectionModel) Reorder(ctx context.Context, userID, id uuid.UUID, snippetIDs []uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Reorder")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var owned bool
+
+	err = tx.QueryRowContext(ctx, `select exists(select true from "collections" where id = ? and user_id = ?)`, id, userID).Scan(&owned)
+	if err != nil {
+		return err
+	}
+
+	if !owned {
+		return ErrNoRecord
+	}
+
+	rows, err := tx.QueryContext(ctx, `select "snippet_id" from "collection_snippets" where collection_id = ? order by position`, id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var current []uuid.UUID
+
+	for rows.Next() {
+		var snippetID uuid.UUID
+
+		err = rows.Scan(&snippetID)
+		if err != nil {
+			return err
+		}
+
+		current = append(current, snippetID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	ordered, err := OrderSnippets(current, snippetIDs)
+	if err != nil {
+		return err
+	}
+
+	for position, snippetID := range ordered {
+		_, err = tx.ExecContext(ctx, `update "collection_snippets" set "position" = ? where collection_id = ? and snippet_id = ?`, position, id, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Snippets returns the snippets of the collection in their order, the expired ones are left out.
+// The private snippets of the owner stay, the viewer has to check Snippet.VisibleTo. The tag filters the snippets the same way as in the SnippetModel.Latest
+func (m *CollectionModel) Snippets(ctx context.Context, id uuid.UUID, tag string) (_ []Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Snippets")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "collection_snippets" cs join "snippets" s on s.id = cs.snippet_id
+	where cs.collection_id = ? and s.expire_time > current_timestamp and ` + snippetTagFilter + `
+	order by cs.position`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id, tag, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// expectAffected turns a change that didn't touch any row into ErrNoRecord
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
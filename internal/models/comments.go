@@ -0,0 +1,237 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// CommentModelInterface stores the threaded comments of the snippets. The authors can edit and delete their comments,
+// the owner of the snippet can delete any comment on it. A deleted comment stays in the thread without the content
+type CommentModelInterface interface {
+	Insert(ctx context.Context, userID, snippetID uuid.UUID, parentID uuid.NullUUID, content string) (uuid.UUID, error)
+	Get(ctx context.Context, id uuid.UUID) (Comment, error)
+	ForSnippet(ctx context.Context, snippetID uuid.UUID) ([]Comment, error)
+	Update(ctx context.Context, userID, id uuid.UUID, content string) error
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+}
+
+type Comment struct {
+	ID         uuid.UUID
+	SnippetID  uuid.UUID
+	UserID     uuid.UUID
+	UserName   string
+	ParentID   uuid.NullUUID
+	Content    string // empty for the deleted comments
+	Edited     bool
+	Deleted    bool
+	CreateTime time.Time
+	Depth      int // in the thread, set by ThreadComments
+}
+
+// ThreadComments orders the comments depth first, every comment is followed by its replies, and sets their depth.
+// The order of the siblings is kept. A reply to a comment that is not in the list is a top level one
+func ThreadComments(comments []Comment) []Comment {
+	ids := make(map[uuid.UUID]bool, len(comments))
+	for _, c := range comments {
+		ids[c.ID] = true
+	}
+
+	replies := make(map[uuid.UUID][]Comment)
+	var roots []Comment
+
+	for _, c := range comments {
+		if c.ParentID.Valid && ids[c.ParentID.UUID] {
+			replies[c.ParentID.UUID] = append(replies[c.ParentID.UUID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	threaded := make([]Comment, 0, len(comments))
+
+	var add func(c Comment, depth int)
+	add = func(c Comment, depth int) {
+		c.Depth = depth
+		threaded = append(threaded, c)
+
+		for _, reply := range replies[c.ID] {
+			add(reply, depth+1)
+		}
+	}
+
+	for _, c := range roots {
+		add(c, 0)
+	}
+
+	return threaded
+}
+
+type CommentModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Insert adds the comment to a snippet that is not expired. The parent must be a comment of the same snippet,
+// ErrNoRecord otherwise. ErrCommentsDisabled means the owner turned the comments off
+func (m *CommentModel) Insert(ctx context.Context, userID, snippetID uuid.UUID, parentID uuid.NullUUID, content string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "comments" ("id", "snippet_id", "user_id", "parent_id", "content")
+	select ?, s.id, ?, ?, ? from "snippets" s
+	where s.id = ? and s.expire_time > current_timestamp and s.comments_enabled
+	and (? is null or exists(select true from "comments" p where p.id = ? and p.snippet_id = s.id))`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, parentID, content, snippetID, parentID, parentID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = expectAffected(result)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, ErrNoRecord) {
+		return uuid.UUID{}, err
+	}
+
+	// nothing was inserted, either the comments are turned off or the snippet or the parent doesn't exist
+	var enabled bool
+
+	err = m.DB.QueryRowContext(ctx, `select "comments_enabled" from "snippets" where id = ? and expire_time > current_timestamp`, snippetID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.UUID{}, ErrNoRecord
+		} else {
+			return uuid.UUID{}, err
+		}
+	}
+
+	if !enabled {
+		return uuid.UUID{}, ErrCommentsDisabled
+	}
+
+	return uuid.UUID{}, ErrNoRecord
+}
+
+func (m *CommentModel) Get(ctx context.Context, id uuid.UUID) (_ Comment, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + commentColumns + ` from "comments" c join "users" u on u.id = c.user_id where c.id = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	if len(comments) == 0 {
+		return Comment{}, ErrNoRecord
+	}
+
+	return comments[0], nil
+}
+
+// ForSnippet returns the comments of the snippet from the oldest, see ThreadComments
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID uuid.UUID) (_ []Comment, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.ForSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + commentColumns + ` from "comments" c join "users" u on u.id = c.user_id
+	where c.snippet_id = ? order by c.create_time, c.id`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// Update changes the content of the comment of the user, the deleted comments can't be changed
+func (m *CommentModel) Update(ctx context.Context, userID, id uuid.UUID, content string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "comments" set "content" = ?, "edit_time" = current_timestamp
+	where id = ? and user_id = ? and delete_time is null`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, content, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Delete removes the content of the comment, the user is either the author or the owner of the snippet
+func (m *CommentModel) Delete(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "comments" set "content" = '', "delete_time" = current_timestamp
+	where id = ? and delete_time is null
+	and (user_id = ? or exists(select true from "snippets" s where s.id = "comments".snippet_id and s.user_id = ?))`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// commentColumns are read by scanComments, c is the comment and u is its author
+const commentColumns = `c."id", c."snippet_id", c."user_id", u."name", c."parent_id", c."content",
+	c.edit_time is not null, c.delete_time is not null, c."create_time"`
+
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	var comments []Comment
+
+	for rows.Next() {
+		var c Comment
+
+		err := rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.ParentID, &c.Content, &c.Edited, &c.Deleted, &c.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
@@ -1,9 +1,23 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateName      = errors.New("models: duplicate name")
+	ErrTokenReused        = errors.New("models: token reused")
+	ErrTokenRotated       = errors.New("models: token rotated")
+	ErrCommentsDisabled   = errors.New("models: comments disabled")
+	ErrNoSchema           = errors.New("models: the database has no schema")
 )
+
+// uniqueViolation reports whether the error is a violation of the unique index. Both SQLite drivers
+// have the same message, so the driver doesn't need to be imported
+func uniqueViolation(err error, index string) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed: index '"+index+"'")
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// SchemaVersion is the version of sql/schema.sql the code expects. Bump it together with every schema change and add
+// the migrations to it in sql/migrations
+const SchemaVersion = 13
+
+type HealthModelInterface interface {
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int, error)
+}
+
+type HealthModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *HealthModel) Ping(ctx context.Context) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "HealthModel.Ping")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+func (m *HealthModel) SchemaVersion(ctx context.Context) (_ int, err error) {
+	ctx, span := m.Tracer.Start(ctx, "HealthModel.SchemaVersion")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select max("version") from "schema_version"`
+
+	var version int
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt).Scan(&version)
+	return version, err
+}
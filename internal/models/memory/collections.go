@@ -0,0 +1,227 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CollectionModel struct {
+	snippets *SnippetModel
+
+	mu          sync.RWMutex
+	collections map[uuid.UUID]models.Collection
+	links       map[uuid.UUID][]uuid.UUID // snippet ids of a collection in their order
+}
+
+// NewCollectionModel links the collections to the snippets of the model
+func NewCollectionModel(snippets *SnippetModel) *CollectionModel {
+	return &CollectionModel{
+		snippets:    snippets,
+		collections: make(map[uuid.UUID]models.Collection),
+		links:       make(map[uuid.UUID][]uuid.UUID),
+	}
+}
+
+// nameTaken is the same as the unique index on "user_id", lower("name"), the caller holds the lock
+func (m *CollectionModel) nameTaken(userID, id uuid.UUID, name string) bool {
+	for _, c := range m.collections {
+		if c.ID != id && c.UserID == userID && strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// owned returns the collection of the user, the caller holds the lock
+func (m *CollectionModel) owned(userID, id uuid.UUID) (models.Collection, bool) {
+	c, ok := m.collections[id]
+	if !ok || c.UserID != userID {
+		return models.Collection{}, false
+	}
+
+	return c, true
+}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID uuid.UUID, name string, visibility models.Visibility) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nameTaken(userID, uuid.UUID{}, name) {
+		return uuid.UUID{}, models.ErrDuplicateName
+	}
+
+	c := models.Collection{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Name:       name,
+		Visibility: visibility,
+		CreateTime: time.Now().UTC(),
+	}
+
+	m.collections[c.ID] = c
+
+	return c.ID, nil
+}
+
+func (m *CollectionModel) Get(ctx context.Context, id uuid.UUID) (models.Collection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.collections[id]
+	if !ok {
+		return models.Collection{}, models.ErrNoRecord
+	}
+
+	return c, nil
+}
+
+func (m *CollectionModel) ForUser(ctx context.Context, userID uuid.UUID) ([]models.Collection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var collections []models.Collection
+
+	for _, c := range m.collections {
+		if c.UserID == userID {
+			collections = append(collections, c)
+		}
+	}
+
+	slices.SortFunc(collections, func(a, b models.Collection) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	return collections, nil
+}
+
+// Update renames the collection and changes its visibility
+func (m *CollectionModel) Update(ctx context.Context, userID, id uuid.UUID, name string, visibility models.Visibility) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.owned(userID, id)
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	if m.nameTaken(userID, id, name) {
+		return models.ErrDuplicateName
+	}
+
+	c.Name = name
+	c.Visibility = visibility
+	m.collections[id] = c
+
+	return nil
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.owned(userID, id); !ok {
+		return models.ErrNoRecord
+	}
+
+	delete(m.collections, id)
+	delete(m.links, id)
+
+	return nil
+}
+
+// AddSnippet puts the snippet at the end of the collection. Adding a snippet that is already there changes nothing,
+// ErrNoRecord means that the collection of the user or the snippet visible to the user doesn't exist
+func (m *CollectionModel) AddSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error {
+	s, err := m.snippets.Get(ctx, snippetID)
+	if err != nil {
+		return err
+	}
+
+	if !s.VisibleTo(userID) {
+		return models.ErrNoRecord
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.owned(userID, id); !ok {
+		return models.ErrNoRecord
+	}
+
+	if !slices.Contains(m.links[id], snippetID) {
+		m.links[id] = append(m.links[id], snippetID)
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.owned(userID, id); !ok {
+		return models.ErrNoRecord
+	}
+
+	i := slices.Index(m.links[id], snippetID)
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	m.links[id] = slices.Delete(m.links[id], i, i+1)
+
+	return nil
+}
+
+// Reorder moves the snippets to the front of the collection in the given order, see models.OrderSnippets
+func (m *CollectionModel) Reorder(ctx context.Context, userID, id uuid.UUID, snippetIDs []uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.owned(userID, id); !ok {
+		return models.ErrNoRecord
+	}
+
+	ordered, err := models.OrderSnippets(m.links[id], snippetIDs)
+	if err != nil {
+		return err
+	}
+
+	m.links[id] = ordered
+
+	return nil
+}
+
+// Snippets returns the snippets of the collection in their order, the expired ones are left out.
+// The private snippets of the owner stay, the viewer has to check Snippet.VisibleTo. The tag filters the snippets the same way as in the SnippetModel.Latest
+func (m *CollectionModel) Snippets(ctx context.Context, id uuid.UUID, tag string) ([]models.Snippet, error) {
+	m.mu.RLock()
+	links := slices.Clone(m.links[id])
+	m.mu.RUnlock()
+
+	var snippets []models.Snippet
+
+	for _, snippetID := range links {
+		// the expired snippets stay linked, Get doesn't return them
+		s, err := m.snippets.Get(ctx, snippetID)
+		if errors.Is(err, models.ErrNoRecord) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hasTag(s, tag) {
+			s.Files, s.ForkedFrom, s.Forks, s.CommentsEnabled = nil, uuid.NullUUID{}, 0, false // only Get loads them
+			snippets = append(snippets, s)
+		}
+	}
+
+	return snippets, nil
+}
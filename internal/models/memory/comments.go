@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+	"time"
+)
+
+type CommentModel struct {
+	snippets *SnippetModel
+	users    *UserModel
+
+	mu       sync.RWMutex
+	comments map[uuid.UUID]models.Comment
+}
+
+// NewCommentModel links the comments to the snippets and their authors to the users of the models
+func NewCommentModel(snippets *SnippetModel, users *UserModel) *CommentModel {
+	return &CommentModel{
+		snippets: snippets,
+		users:    users,
+		comments: make(map[uuid.UUID]models.Comment),
+	}
+}
+
+// Insert adds the comment to a snippet that is not expired. The parent must be a comment of the same snippet,
+// ErrNoRecord otherwise. ErrCommentsDisabled means the owner turned the comments off
+func (m *CommentModel) Insert(ctx context.Context, userID, snippetID uuid.UUID, parentID uuid.NullUUID, content string) (uuid.UUID, error) {
+	s, err := m.snippets.Get(ctx, snippetID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if !s.CommentsEnabled {
+		return uuid.UUID{}, models.ErrCommentsDisabled
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if parentID.Valid {
+		parent, ok := m.comments[parentID.UUID]
+		if !ok || parent.SnippetID != snippetID {
+			return uuid.UUID{}, models.ErrNoRecord
+		}
+	}
+
+	c := models.Comment{
+		ID:         uuid.New(),
+		SnippetID:  snippetID,
+		UserID:     userID,
+		ParentID:   parentID,
+		Content:    content,
+		CreateTime: time.Now().UTC(),
+	}
+
+	m.comments[c.ID] = c
+
+	return c.ID, nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id uuid.UUID) (models.Comment, error) {
+	m.mu.RLock()
+	c, ok := m.comments[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return models.Comment{}, models.ErrNoRecord
+	}
+
+	return m.withAuthor(ctx, c)
+}
+
+// ForSnippet returns the comments of the snippet from the oldest, see models.ThreadComments
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID uuid.UUID) ([]models.Comment, error) {
+	m.mu.RLock()
+	var comments []models.Comment
+	for _, c := range m.comments {
+		if c.SnippetID == snippetID {
+			comments = append(comments, c)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(comments, func(a, b models.Comment) int {
+		return a.CreateTime.Compare(b.CreateTime)
+	})
+
+	for i, c := range comments {
+		c, err := m.withAuthor(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		comments[i] = c
+	}
+
+	return comments, nil
+}
+
+// Update changes the content of the comment of the user, the deleted comments can't be changed
+func (m *CommentModel) Update(ctx context.Context, userID, id uuid.UUID, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.comments[id]
+	if !ok || c.UserID != userID || c.Deleted {
+		return models.ErrNoRecord
+	}
+
+	c.Content = content
+	c.Edited = true
+	m.comments[id] = c
+
+	return nil
+}
+
+// Delete removes the content of the comment, the user is either the author or the owner of the snippet
+func (m *CommentModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.comments[id]
+	if !ok || c.Deleted {
+		return models.ErrNoRecord
+	}
+
+	if c.UserID != userID {
+		// the expired snippets are still owned, Get doesn't return them
+		m.snippets.mu.RLock()
+		s := m.snippets.snippets[c.SnippetID]
+		m.snippets.mu.RUnlock()
+
+		if s.UserID != userID {
+			return models.ErrNoRecord
+		}
+	}
+
+	c.Content = ""
+	c.Deleted = true
+	m.comments[id] = c
+
+	return nil
+}
+
+// withAuthor sets the name of the author, the same as the join with the users
+func (m *CommentModel) withAuthor(ctx context.Context, c models.Comment) (models.Comment, error) {
+	u, err := m.users.Get(ctx, c.UserID)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	c.UserName = u.Name
+
+	return c, nil
+}
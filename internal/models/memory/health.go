@@ -0,0 +1,17 @@
+package memory
+
+import (
+	"context"
+	"snippetbox.doichevkostia.dev/internal/models"
+)
+
+// HealthModel is always ready, there is nothing to connect to
+type HealthModel struct{}
+
+func (m *HealthModel) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *HealthModel) SchemaVersion(ctx context.Context) (int, error) {
+	return models.SchemaVersion, nil
+}
@@ -0,0 +1,33 @@
+package memory_test
+
+import (
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/models/memory"
+	"snippetbox.doichevkostia.dev/internal/models/modelstest"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	modelstest.Run(t, func(t *testing.T) modelstest.Models {
+		users := memory.NewUserModel(modelstest.Hasher)
+		snippets := memory.NewSnippetModel()
+
+		return modelstest.Models{
+			Snippets:       snippets,
+			Collections:    memory.NewCollectionModel(snippets),
+			Tags:           memory.NewTagModel(snippets),
+			Comments:       memory.NewCommentModel(snippets, users),
+			Stars:          memory.NewStarModel(snippets),
+			Views:          memory.NewViewModel(snippets),
+			Users:          users,
+			UserSessions:   memory.NewUserSessionModel(),
+			RememberTokens: memory.NewRememberTokenModel(),
+			Health:         &memory.HealthModel{},
+			WithHasher: func(hasher password.Hasher) models.UserModelInterface {
+				users.Hasher = hasher
+				return users
+			},
+		}
+	})
+}
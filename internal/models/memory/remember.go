@@ -0,0 +1,158 @@
+package memory
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+	"time"
+)
+
+type rememberToken struct {
+	userID            uuid.UUID
+	userSessionID     uuid.UUID
+	tokenHash         string
+	previousTokenHash string
+	rotateTime        time.Time
+	createTime        time.Time
+	expireTime        time.Time
+}
+
+type RememberTokenModel struct {
+	mu     sync.Mutex
+	tokens map[string]rememberToken
+}
+
+func NewRememberTokenModel() *RememberTokenModel {
+	return &RememberTokenModel{
+		tokens: make(map[string]rememberToken),
+	}
+}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, userSessionID uuid.UUID, lifetime time.Duration) (models.RememberToken, error) {
+	series, err := models.RandomToken(16)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	token, err := models.RandomToken(32)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	m.tokens[series] = rememberToken{
+		userID:        userID,
+		userSessionID: userSessionID,
+		tokenHash:     models.HashToken(token),
+		createTime:    now,
+		expireTime:    now.Add(lifetime),
+	}
+
+	return models.RememberToken{
+		Series:        series,
+		Token:         token,
+		UserID:        userID,
+		UserSessionID: userSessionID,
+		CreateTime:    now,
+		ExpireTime:    now.Add(lifetime),
+	}, nil
+}
+
+// Rotate checks and replaces the token under the lock, only one of the parallel calls with the same token wins
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, token string) (models.RememberToken, error) {
+	newToken, err := models.RandomToken(32)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	rt, ok := m.tokens[series]
+	if !ok || !rt.expireTime.After(now) {
+		return models.RememberToken{}, models.ErrNoRecord
+	}
+
+	tokenHash := models.HashToken(token)
+
+	if subtle.ConstantTimeCompare([]byte(rt.tokenHash), []byte(tokenHash)) != 1 {
+		if subtle.ConstantTimeCompare([]byte(rt.previousTokenHash), []byte(tokenHash)) == 1 && now.Sub(rt.rotateTime) < models.RememberRaceWindow {
+			return models.RememberToken{}, models.ErrTokenRotated
+		}
+
+		delete(m.tokens, series)
+		return models.RememberToken{UserID: rt.userID}, models.ErrTokenReused
+	}
+
+	rt.previousTokenHash = rt.tokenHash
+	rt.tokenHash = models.HashToken(newToken)
+	rt.rotateTime = now
+	m.tokens[series] = rt
+
+	return models.RememberToken{
+		Series:        series,
+		Token:         newToken,
+		UserID:        rt.userID,
+		UserSessionID: rt.userSessionID,
+		CreateTime:    rt.createTime,
+		ExpireTime:    rt.expireTime,
+	}, nil
+}
+
+func (m *RememberTokenModel) SetUserSession(ctx context.Context, series string, userSessionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rt, ok := m.tokens[series]
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	rt.userSessionID = userSessionID
+	m.tokens[series] = rt
+
+	return nil
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tokens, series)
+
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, userSessionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for series, rt := range m.tokens {
+		if rt.userSessionID == userSessionID {
+			delete(m.tokens, series)
+		}
+	}
+
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for series, rt := range m.tokens {
+		if rt.userID == userID {
+			delete(m.tokens, series)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+	"time"
+)
+
+type UserSessionModel struct {
+	mu       sync.RWMutex
+	sessions map[uuid.UUID]models.UserSession
+}
+
+func NewUserSessionModel() *UserSessionModel {
+	return &UserSessionModel{
+		sessions: make(map[uuid.UUID]models.UserSession),
+	}
+}
+
+func (m *UserSessionModel) Insert(ctx context.Context, userID uuid.UUID, ip, userAgent string, lifetime time.Duration) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	s := models.UserSession{
+		ID:           uuid.New(),
+		UserID:       userID,
+		IP:           ip,
+		UserAgent:    userAgent,
+		CreateTime:   now,
+		LastSeenTime: now,
+		ExpireTime:   now.Add(lifetime),
+	}
+
+	m.sessions[s.ID] = s
+
+	return s.ID, nil
+}
+
+// Get returns the session only while it is still active, revoked and expired sessions result in ErrNoRecord
+func (m *UserSessionModel) Get(ctx context.Context, id uuid.UUID) (models.UserSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !s.ExpireTime.After(time.Now()) {
+		return models.UserSession{}, models.ErrNoRecord
+	}
+
+	return s, nil
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if ok {
+		s.LastSeenTime = time.Now().UTC()
+		m.sessions[id] = s
+	}
+
+	return nil
+}
+
+func (m *UserSessionModel) ForUser(ctx context.Context, userID uuid.UUID) ([]models.UserSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+
+	var sessions []models.UserSession
+
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.ExpireTime.After(now) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b models.UserSession) int {
+		return b.LastSeenTime.Compare(a.LastSeenTime)
+	})
+
+	return sessions, nil
+}
+
+// Delete revokes a single session. The user id is required, so that one user can't end the session of another one
+func (m *UserSessionModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return models.ErrNoRecord
+	}
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+// DeleteOthers revokes every session of the user but the one with the id, the one the user is logged in with,
+// and returns the number of revoked sessions. The expired ones are left alone, they ended already
+func (m *UserSessionModel) DeleteOthers(ctx context.Context, userID, id uuid.UUID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for sessionID, s := range m.sessions {
+		if s.UserID == userID && sessionID != id && s.ExpireTime.After(now) {
+			delete(m.sessions, sessionID)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// DeleteAllForUser revokes every live session of the user and returns the number of revoked sessions
+func (m *UserSessionModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for id, s := range m.sessions {
+		if s.UserID == userID && s.ExpireTime.After(now) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
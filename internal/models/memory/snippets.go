@@ -0,0 +1,187 @@
+// Package memory implements the model interfaces with maps, for the local development and the tests.
+// The data is lost on restart. The behaviour follows the SQL models, the conformance suite runs against both
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+	"time"
+)
+
+type SnippetModel struct {
+	mu       sync.RWMutex
+	snippets map[uuid.UUID]models.Snippet
+	stars    map[uuid.UUID]map[uuid.UUID]time.Time // the time of the star by the user of a snippet, kept by the StarModel
+}
+
+func NewSnippetModel() *SnippetModel {
+	return &SnippetModel{
+		snippets: make(map[uuid.UUID]models.Snippet),
+		stars:    make(map[uuid.UUID]map[uuid.UUID]time.Time),
+	}
+}
+
+func (m *SnippetModel) Insert(ctx context.Context, userID uuid.UUID, title string, content string, format models.Format, visibility models.Visibility, tags []string, files []models.SnippetFile, expires int) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	s := models.Snippet{
+		ID:              uuid.New(),
+		UserID:          userID,
+		Title:           title,
+		Content:         content,
+		Format:          format,
+		Tags:            models.NormalizeTags(tags),
+		Files:           slices.Clone(files),
+		CommentsEnabled: true,
+		Visibility:      visibility,
+		CreateTime:      now,
+		ExpireTime:      now.AddDate(0, 0, expires),
+	}
+
+	m.snippets[s.ID] = s
+
+	return s.ID, nil
+}
+
+func (m *SnippetModel) Get(ctx context.Context, id uuid.UUID) (models.Snippet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.snippets[id]
+	if !ok || !s.ExpireTime.After(time.Now()) {
+		return models.Snippet{}, models.ErrNoRecord
+	}
+
+	for _, f := range m.snippets {
+		if f.ForkedFrom.Valid && f.ForkedFrom.UUID == id {
+			s.Forks++
+		}
+	}
+
+	s.Stars = len(m.stars[id])
+
+	return s, nil
+}
+
+// Fork copies the snippet with its tags and files into the account of the user, the copy expires in the given days.
+// Only a snippet that Get returns and that is visible to the user can be forked, ErrNoRecord otherwise.
+// The copy keeps the visibility of the original, so that a fork doesn't list an unlisted or private snippet
+func (m *SnippetModel) Fork(ctx context.Context, userID, id uuid.UUID, expires int) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	original, ok := m.snippets[id]
+	if !ok || !original.ExpireTime.After(now) || !original.VisibleTo(userID) {
+		return uuid.UUID{}, models.ErrNoRecord
+	}
+
+	s := models.Snippet{
+		ID:              uuid.New(),
+		UserID:          userID,
+		Title:           original.Title,
+		Content:         original.Content,
+		Format:          original.Format,
+		Tags:            slices.Clone(original.Tags),
+		Files:           slices.Clone(original.Files),
+		ForkedFrom:      uuid.NullUUID{UUID: id, Valid: true},
+		CommentsEnabled: true,
+		Visibility:      original.Visibility,
+		CreateTime:      now,
+		ExpireTime:      now.AddDate(0, 0, expires),
+	}
+
+	m.snippets[s.ID] = s
+
+	return s.ID, nil
+}
+
+// SetCommentsEnabled turns the new comments on or off, only the owner of the snippet can do it
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userID, id uuid.UUID, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snippets[id]
+	if !ok || s.UserID != userID {
+		return models.ErrNoRecord
+	}
+
+	s.CommentsEnabled = enabled
+	m.snippets[id] = s
+
+	return nil
+}
+
+// Latest returns the 10 newest public snippets, only the ones with the tag unless it is empty
+func (m *SnippetModel) Latest(ctx context.Context, tag string) ([]models.Snippet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+
+	var snippets []models.Snippet
+
+	for _, s := range m.snippets {
+		if s.ExpireTime.After(now) && s.Visibility == models.VisibilityPublic && hasTag(s, tag) {
+			s.Files, s.ForkedFrom, s.CommentsEnabled = nil, uuid.NullUUID{}, false // only Get loads them
+			s.Stars = len(m.stars[s.ID])
+			snippets = append(snippets, s)
+		}
+	}
+
+	slices.SortFunc(snippets, func(a, b models.Snippet) int {
+		return b.CreateTime.Compare(a.CreateTime)
+	})
+
+	if len(snippets) > 10 {
+		snippets = snippets[:10]
+	}
+
+	return snippets, nil
+}
+
+// ForUser returns a page of the non-expired public snippets of the user, the newest first. Only the ones with the tag
+// unless it is empty
+func (m *SnippetModel) ForUser(ctx context.Context, userID uuid.UUID, tag string, limit, offset int) ([]models.Snippet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+
+	var snippets []models.Snippet
+
+	for _, s := range m.snippets {
+		if s.ExpireTime.After(now) && s.Visibility == models.VisibilityPublic && s.UserID == userID && hasTag(s, tag) {
+			s.Files, s.ForkedFrom, s.CommentsEnabled = nil, uuid.NullUUID{}, false // only Get loads them
+			s.Stars = len(m.stars[s.ID])
+			snippets = append(snippets, s)
+		}
+	}
+
+	slices.SortFunc(snippets, func(a, b models.Snippet) int {
+		return b.CreateTime.Compare(a.CreateTime)
+	})
+
+	if offset >= len(snippets) {
+		return nil, nil
+	}
+
+	snippets = snippets[offset:]
+	if len(snippets) > limit {
+		snippets = snippets[:limit]
+	}
+
+	return snippets, nil
+}
+
+// hasTag reports whether the snippet has the tag, every snippet has the empty one
+func hasTag(s models.Snippet, tag string) bool {
+	return tag == "" || slices.Contains(s.Tags, tag)
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+// StarModel keeps the stars with the snippets, so that the SnippetModel can count them
+type StarModel struct {
+	snippets *SnippetModel
+}
+
+// NewStarModel links the stars to the snippets of the model
+func NewStarModel(snippets *SnippetModel) *StarModel {
+	return &StarModel{snippets: snippets}
+}
+
+// Toggle stars the snippet or takes the star back, and returns the new state with the number of the stars.
+// Only a snippet that is not expired and that is visible to the user can be starred, ErrNoRecord otherwise
+func (m *StarModel) Toggle(ctx context.Context, userID, snippetID uuid.UUID) (bool, int, error) {
+	m.snippets.mu.Lock()
+	defer m.snippets.mu.Unlock()
+
+	stars := m.snippets.stars[snippetID]
+
+	if _, ok := stars[userID]; ok {
+		delete(stars, userID)
+		return false, len(stars), nil
+	}
+
+	s, ok := m.snippets.snippets[snippetID]
+	if !ok || !s.ExpireTime.After(time.Now()) || !s.VisibleTo(userID) {
+		return false, 0, models.ErrNoRecord
+	}
+
+	if stars == nil {
+		stars = make(map[uuid.UUID]time.Time)
+		m.snippets.stars[snippetID] = stars
+	}
+
+	stars[userID] = time.Now().UTC()
+
+	return true, len(stars), nil
+}
+
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID uuid.UUID) (bool, error) {
+	m.snippets.mu.RLock()
+	defer m.snippets.mu.RUnlock()
+
+	_, ok := m.snippets.stars[snippetID][userID]
+
+	return ok, nil
+}
+
+// ForUser returns the snippets the user starred and can still see, the latest star first. Only the ones with the tag
+// unless it is empty
+func (m *StarModel) ForUser(ctx context.Context, userID uuid.UUID, tag string) ([]models.Snippet, error) {
+	m.snippets.mu.RLock()
+	defer m.snippets.mu.RUnlock()
+
+	starred := make(map[uuid.UUID]time.Time)
+
+	for snippetID, stars := range m.snippets.stars {
+		if t, ok := stars[userID]; ok {
+			starred[snippetID] = t
+		}
+	}
+
+	snippets := m.listed(func(s models.Snippet) bool {
+		_, ok := starred[s.ID]
+		return ok && s.VisibleTo(userID) && hasTag(s, tag)
+	})
+
+	slices.SortFunc(snippets, func(a, b models.Snippet) int {
+		return starred[b.ID].Compare(starred[a.ID])
+	})
+
+	return snippets, nil
+}
+
+// MostStarred returns the 10 public snippets with the most stars given in the last days, the newer snippet wins a tie.
+// The Stars of the snippets are all of their stars
+func (m *StarModel) MostStarred(ctx context.Context, days int) ([]models.Snippet, error) {
+	m.snippets.mu.RLock()
+	defer m.snippets.mu.RUnlock()
+
+	since := time.Now().AddDate(0, 0, -days)
+	recent := make(map[uuid.UUID]int)
+
+	for snippetID, stars := range m.snippets.stars {
+		for _, t := range stars {
+			if t.After(since) {
+				recent[snippetID]++
+			}
+		}
+	}
+
+	snippets := m.listed(func(s models.Snippet) bool {
+		return recent[s.ID] > 0 && s.Visibility == models.VisibilityPublic
+	})
+
+	slices.SortFunc(snippets, func(a, b models.Snippet) int {
+		return cmp.Or(cmp.Compare(recent[b.ID], recent[a.ID]), b.CreateTime.Compare(a.CreateTime))
+	})
+
+	if len(snippets) > 10 {
+		snippets = snippets[:10]
+	}
+
+	return snippets, nil
+}
+
+// listed returns the snippets that are not expired and match, the way the lists show them. The caller holds the lock
+func (m *StarModel) listed(match func(s models.Snippet) bool) []models.Snippet {
+	now := time.Now()
+
+	var snippets []models.Snippet
+
+	for _, s := range m.snippets.snippets {
+		if s.ExpireTime.After(now) && match(s) {
+			s.Files, s.ForkedFrom, s.CommentsEnabled = nil, uuid.NullUUID{}, false // only Get loads them
+			s.Stars = len(m.snippets.stars[s.ID])
+			snippets = append(snippets, s)
+		}
+	}
+
+	return snippets
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+	"time"
+)
+
+// TagModel reads the tags from the snippets, they are stored with them
+type TagModel struct {
+	snippets *SnippetModel
+}
+
+func NewTagModel(snippets *SnippetModel) *TagModel {
+	return &TagModel{
+		snippets: snippets,
+	}
+}
+
+// Search returns the most used tags of the public snippets that start with the prefix, for the autocomplete
+func (m *TagModel) Search(ctx context.Context, prefix string, limit int) ([]models.Tag, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+
+	m.snippets.mu.RLock()
+
+	now := time.Now()
+	counts := make(map[string]int)
+
+	for _, s := range m.snippets.snippets {
+		if !s.ExpireTime.After(now) || s.Visibility != models.VisibilityPublic {
+			continue
+		}
+
+		for _, tag := range s.Tags {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+
+	m.snippets.mu.RUnlock()
+
+	tags := make([]models.Tag, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, models.Tag{Name: name, Count: count})
+	}
+
+	slices.SortFunc(tags, func(a, b models.Tag) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, nil
+}
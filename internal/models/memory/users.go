@@ -0,0 +1,263 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UserModel struct {
+	Hasher password.Hasher
+
+	mu      sync.RWMutex
+	users   map[uuid.UUID]models.User
+	avatars map[uuid.UUID]models.Avatar
+}
+
+func NewUserModel(hasher password.Hasher) *UserModel {
+	return &UserModel{
+		Hasher:  hasher,
+		users:   make(map[uuid.UUID]models.User),
+		avatars: make(map[uuid.UUID]models.Avatar),
+	}
+}
+
+func (m *UserModel) Insert(ctx context.Context, name, email, plaintext string) (uuid.UUID, error) {
+	// hashing is slow, it is done before taking the lock
+	hashedPassword, err := m.Hasher.Hash(plaintext)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the same as the unique indexes on lower("email") and lower("name")
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return uuid.UUID{}, models.ErrDuplicateEmail
+		}
+
+		if strings.EqualFold(u.Name, name) {
+			return uuid.UUID{}, models.ErrDuplicateName
+		}
+	}
+
+	u := models.User{
+		ID:             uuid.New(),
+		Name:           name,
+		Email:          email,
+		HashedPassword: []byte(hashedPassword),
+		DefaultExpires: models.DefaultExpires,
+		CreateTime:     time.Now().UTC(),
+	}
+
+	m.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+func (m *UserModel) Authenticate(ctx context.Context, email, plaintext string) (uuid.UUID, error) {
+	u, err := m.ByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return uuid.UUID{}, models.ErrInvalidCredentials
+		}
+
+		return uuid.UUID{}, err
+	}
+
+	hash := string(u.HashedPassword)
+
+	ok, err := password.Verify(hash, plaintext)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if !ok {
+		return uuid.UUID{}, models.ErrInvalidCredentials
+	}
+
+	if m.Hasher.NeedsRehash(hash) {
+		m.setHash(u.ID, hash, plaintext)
+	}
+
+	return u.ID, nil
+}
+
+func (m *UserModel) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.users[id]
+	return ok, nil
+}
+
+func (m *UserModel) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.User{}, models.ErrNoRecord
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) ByEmail(ctx context.Context, email string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+
+	return models.User{}, models.ErrNoRecord
+}
+
+func (m *UserModel) UpdatePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) error {
+	u, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ok, err := password.Verify(string(u.HashedPassword), currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return models.ErrInvalidCredentials
+	}
+
+	return m.setHash(id, "", newPassword)
+}
+
+// UpdateDefaultExpires changes the expiry of the forks and the preselected one of the new snippets
+func (m *UserModel) UpdateDefaultExpires(ctx context.Context, id uuid.UUID, expires int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	u.DefaultExpires = expires
+	m.users[id] = u
+
+	return nil
+}
+
+// ByName finds the user by the name regardless of the case, the same as the unique index
+func (m *UserModel) ByName(ctx context.Context, name string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Name, name) {
+			return u, nil
+		}
+	}
+
+	return models.User{}, models.ErrNoRecord
+}
+
+func (m *UserModel) UpdateBio(ctx context.Context, id uuid.UUID, bio string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	u.Bio = bio
+	m.users[id] = u
+
+	return nil
+}
+
+func (m *UserModel) Avatar(ctx context.Context, id uuid.UUID) (models.Avatar, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.avatars[id]
+	if !ok {
+		return models.Avatar{}, models.ErrNoRecord
+	}
+
+	a.Data = slices.Clone(a.Data)
+
+	return a, nil
+}
+
+// SetAvatar adds the avatar of the user or replaces the existing one
+func (m *UserModel) SetAvatar(ctx context.Context, id uuid.UUID, contentType string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	m.avatars[id] = models.Avatar{
+		ContentType: contentType,
+		Data:        slices.Clone(data),
+		UpdateTime:  time.Now().UTC(),
+	}
+
+	u.HasAvatar = true
+	m.users[id] = u
+
+	return nil
+}
+
+// DeleteAvatar removes the avatar of the user, without an avatar it does nothing
+func (m *UserModel) DeleteAvatar(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.avatars, id)
+
+	if u, ok := m.users[id]; ok {
+		u.HasAvatar = false
+		m.users[id] = u
+	}
+
+	return nil
+}
+
+// setHash replaces the hash. With the old hash set, it is replaced only if it wasn't changed since it was read,
+// like the conditional update of the rehash in the SQL models
+func (m *UserModel) setHash(id uuid.UUID, oldHash, plaintext string) error {
+	newHash, err := m.Hasher.Hash(plaintext)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+
+	if oldHash == "" || string(u.HashedPassword) == oldHash {
+		u.HashedPassword = []byte(newHash)
+		m.users[id] = u
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"sync"
+)
+
+// viewKey is the same as the unique index on "snippet_id", "day", "visitor"
+type viewKey struct {
+	snippetID uuid.UUID
+	day       string
+	visitor   string
+}
+
+type ViewModel struct {
+	snippets *SnippetModel
+
+	mu    sync.Mutex
+	views map[viewKey]bool
+}
+
+// NewViewModel links the views to the snippets of the model
+func NewViewModel(snippets *SnippetModel) *ViewModel {
+	return &ViewModel{
+		snippets: snippets,
+		views:    make(map[viewKey]bool),
+	}
+}
+
+// Insert counts the views, the repeated views of a visitor on the same day and the views of the snippets
+// that don't exist are ignored
+func (m *ViewModel) Insert(ctx context.Context, views []models.View) error {
+	m.snippets.mu.RLock()
+	defer m.snippets.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range views {
+		if _, ok := m.snippets.snippets[v.SnippetID]; ok {
+			m.views[viewKey{snippetID: v.SnippetID, day: v.Day(), visitor: v.Visitor}] = true
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the views of the snippet with the views of the last days
+func (m *ViewModel) Stats(ctx context.Context, snippetID uuid.UUID, days int) (models.ViewStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats models.ViewStats
+
+	counts := make(map[string]int)
+
+	for key := range m.views {
+		if key.snippetID == snippetID {
+			stats.Total++
+			counts[key.day]++
+		}
+	}
+
+	stats.Daily = models.DailyStats(counts, days)
+
+	return stats, nil
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Migration upgrades the schema from the version before it to Version. The SQL inserts the version into
+// "schema_version" itself, so that a migration can also be applied by hand
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// ReadMigrations reads the .sql files of the directory in the order of their versions. The name of a file starts with
+// the version, e.g. 0003_collections.sql, the versions follow each other up to SchemaVersion
+func ReadMigrations(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+
+	// the names are sorted and the versions are zero-padded, so the migrations are in order
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: the name doesn't start with a version", name)
+		}
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+
+	if len(migrations) == 0 {
+		return nil, errors.New("no migrations")
+	}
+
+	for i, m := range migrations[1:] {
+		if m.Version != migrations[i].Version+1 {
+			return nil, fmt.Errorf("migration %s: version %d, expected %d", m.Name, m.Version, migrations[i].Version+1)
+		}
+	}
+
+	if last := migrations[len(migrations)-1]; last.Version != SchemaVersion {
+		return nil, fmt.Errorf("migration %s: version %d, expected %d", last.Name, last.Version, SchemaVersion)
+	}
+
+	return migrations, nil
+}
+
+// Pending returns the ones of the migrations read by ReadMigrations that upgrade the schema of the version to
+// SchemaVersion
+func Pending(migrations []Migration, from int) ([]Migration, error) {
+	if from > SchemaVersion {
+		return nil, fmt.Errorf("the schema version %d is newer than %d", from, SchemaVersion)
+	}
+
+	if from < migrations[0].Version-1 {
+		return nil, fmt.Errorf("no migration from the schema version %d", from)
+	}
+
+	return migrations[max(from-migrations[0].Version+1, 0):], nil
+}
+
+// Migrate applies the migrations newer than the schema of the SQLite database, each in a transaction of its own, and
+// returns the version it started from. The database of the first release has no "schema_version", it is the version 0
+func Migrate(ctx context.Context, db *sql.DB, migrations []Migration) (from int, err error) {
+	// the pragmas are set per connection, the migrations have to run on the one that turned the foreign keys off
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	from, err = sqliteSchemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	pending, err := Pending(migrations, from)
+	if err != nil {
+		return from, err
+	}
+
+	// a rebuilt table is dropped and created again, the foreign keys would delete the rows referencing it. The pragma
+	// is a no-op inside a transaction
+	var foreignKeys bool
+
+	err = conn.QueryRowContext(ctx, `pragma foreign_keys`).Scan(&foreignKeys)
+	if err != nil {
+		return from, err
+	}
+
+	if foreignKeys {
+		_, err = conn.ExecContext(ctx, `pragma foreign_keys = off`)
+		if err != nil {
+			return from, err
+		}
+
+		defer conn.ExecContext(context.Background(), `pragma foreign_keys = on`)
+	}
+
+	for _, m := range pending {
+		err = applySQLiteMigration(ctx, conn, m)
+		if err != nil {
+			return from, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+
+	return from, nil
+}
+
+func sqliteSchemaVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	stmt := `select
+		exists(select true from "sqlite_master" where type = 'table' and name = 'users'),
+		exists(select true from "sqlite_master" where type = 'table' and name = 'schema_version')`
+
+	var users, versioned bool
+
+	err := conn.QueryRowContext(ctx, stmt).Scan(&users, &versioned)
+	if err != nil {
+		return 0, err
+	}
+
+	if !users {
+		return 0, ErrNoSchema
+	}
+
+	if !versioned {
+		return 0, nil
+	}
+
+	var version int
+
+	err = conn.QueryRowContext(ctx, `select max("version") from "schema_version"`).Scan(&version)
+	return version, err
+}
+
+func applySQLiteMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, m.SQL)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,99 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+// CollectionID is a private collection of the user
+var CollectionID = uuid.New()
+
+var mockCollection = models.Collection{
+	ID:         CollectionID,
+	UserID:     UserID,
+	Name:       "Haiku",
+	Visibility: models.VisibilityPrivate,
+	CreateTime: time.Now(),
+}
+
+type CollectionModel struct{}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID uuid.UUID, name string, visibility models.Visibility) (uuid.UUID, error) {
+	if name == "Dupe" {
+		return uuid.UUID{}, models.ErrDuplicateName
+	}
+
+	return uuid.New(), nil
+}
+
+func (m *CollectionModel) Get(ctx context.Context, id uuid.UUID) (models.Collection, error) {
+	if id == CollectionID {
+		return mockCollection, nil
+	}
+
+	return models.Collection{}, models.ErrNoRecord
+}
+
+func (m *CollectionModel) ForUser(ctx context.Context, userID uuid.UUID) ([]models.Collection, error) {
+	if userID == UserID {
+		return []models.Collection{mockCollection}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *CollectionModel) Update(ctx context.Context, userID, id uuid.UUID, name string, visibility models.Visibility) error {
+	if userID != UserID || id != CollectionID {
+		return models.ErrNoRecord
+	}
+
+	if name == "Dupe" {
+		return models.ErrDuplicateName
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	if userID != UserID || id != CollectionID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) AddSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error {
+	if userID != UserID || id != CollectionID || snippetID != SnippetID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) error {
+	if userID != UserID || id != CollectionID || snippetID != SnippetID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) Reorder(ctx context.Context, userID, id uuid.UUID, snippetIDs []uuid.UUID) error {
+	if userID != UserID || id != CollectionID {
+		return models.ErrNoRecord
+	}
+
+	_, err := models.OrderSnippets([]uuid.UUID{SnippetID}, snippetIDs)
+	return err
+}
+
+func (m *CollectionModel) Snippets(ctx context.Context, id uuid.UUID, tag string) ([]models.Snippet, error) {
+	if id == CollectionID && (tag == "" || slices.Contains(mockSnippet.Tags, tag)) {
+		return []models.Snippet{mockSnippet}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,81 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+// CommentID is a comment of the user on the snippet, OtherCommentID is a reply to it by another user
+var (
+	CommentID      = uuid.New()
+	OtherCommentID = uuid.New()
+)
+
+var mockComments = []models.Comment{
+	{
+		ID:         CommentID,
+		SnippetID:  SnippetID,
+		UserID:     UserID,
+		UserName:   "Alice",
+		Content:    "<script>alert('pond')</script>",
+		CreateTime: time.Now(),
+	},
+	{
+		ID:         OtherCommentID,
+		SnippetID:  SnippetID,
+		UserID:     AdminID,
+		UserName:   "Admin",
+		ParentID:   uuid.NullUUID{UUID: CommentID, Valid: true},
+		Content:    "A frog jumps in",
+		CreateTime: time.Now(),
+	},
+}
+
+type CommentModel struct{}
+
+func (m *CommentModel) Insert(ctx context.Context, userID, snippetID uuid.UUID, parentID uuid.NullUUID, content string) (uuid.UUID, error) {
+	if snippetID != SnippetID || (parentID.Valid && parentID.UUID != CommentID && parentID.UUID != OtherCommentID) {
+		return uuid.UUID{}, models.ErrNoRecord
+	}
+
+	return uuid.New(), nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id uuid.UUID) (models.Comment, error) {
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+
+	return models.Comment{}, models.ErrNoRecord
+}
+
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID uuid.UUID) ([]models.Comment, error) {
+	if snippetID == SnippetID {
+		return mockComments, nil
+	}
+
+	return nil, nil
+}
+
+func (m *CommentModel) Update(ctx context.Context, userID, id uuid.UUID, content string) error {
+	c, err := m.Get(ctx, id)
+	if err != nil || c.UserID != userID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CommentModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	c, err := m.Get(ctx, id)
+	// the user owns the snippet
+	if err != nil || (c.UserID != userID && userID != UserID) {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.doichevkostia.dev/internal/models"
+)
+
+type HealthModel struct {
+	PingErr    error
+	Version    int
+	VersionErr error
+}
+
+func (m *HealthModel) Ping(ctx context.Context) error {
+	return m.PingErr
+}
+
+func (m *HealthModel) SchemaVersion(ctx context.Context) (int, error) {
+	if m.VersionErr != nil {
+		return 0, m.VersionErr
+	}
+
+	if m.Version == 0 {
+		return models.SchemaVersion, nil
+	}
+
+	return m.Version, nil
+}
@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+const (
+	RememberSeries = "series"
+	RememberToken  = "token"
+	// StolenSeries is a series which token was already rotated
+	StolenSeries = "stolen"
+	// RotatedSeries is a series which token was rotated by a parallel request a moment ago
+	RotatedSeries = "rotated"
+)
+
+type RememberTokenModel struct{}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, userSessionID uuid.UUID, lifetime time.Duration) (models.RememberToken, error) {
+	return models.RememberToken{
+		Series:        RememberSeries,
+		Token:         RememberToken,
+		UserID:        userID,
+		UserSessionID: userSessionID,
+		CreateTime:    time.Now(),
+		ExpireTime:    time.Now().Add(lifetime),
+	}, nil
+}
+
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, token string) (models.RememberToken, error) {
+	switch {
+	case series == RememberSeries && token == RememberToken:
+		return models.RememberToken{Series: RememberSeries, Token: RememberToken, UserID: UserID}, nil
+	case series == RotatedSeries:
+		return models.RememberToken{}, models.ErrTokenRotated
+	case series == StolenSeries:
+		return models.RememberToken{UserID: UserID}, models.ErrTokenReused
+	default:
+		return models.RememberToken{}, models.ErrNoRecord
+	}
+}
+
+func (m *RememberTokenModel) SetUserSession(ctx context.Context, series string, userSessionID uuid.UUID) error {
+	if series != RememberSeries {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) error {
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, userSessionID uuid.UUID) error {
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return nil
+}
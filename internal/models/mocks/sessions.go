@@ -0,0 +1,84 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+var UserSessionID = uuid.New()
+var AdminSessionID = uuid.New()
+
+var mockUserSession = models.UserSession{
+	ID:           UserSessionID,
+	UserID:       UserID,
+	IP:           "127.0.0.1",
+	UserAgent:    "Go-http-client/1.1",
+	CreateTime:   time.Now(),
+	LastSeenTime: time.Now(),
+	ExpireTime:   time.Now().Add(12 * time.Hour),
+}
+
+var mockAdminSession = models.UserSession{
+	ID:           AdminSessionID,
+	UserID:       AdminID,
+	IP:           "127.0.0.1",
+	UserAgent:    "Go-http-client/1.1",
+	CreateTime:   time.Now(),
+	LastSeenTime: time.Now(),
+	ExpireTime:   time.Now().Add(12 * time.Hour),
+}
+
+type UserSessionModel struct{}
+
+func (m *UserSessionModel) Insert(ctx context.Context, userID uuid.UUID, ip, userAgent string, lifetime time.Duration) (uuid.UUID, error) {
+	if userID == AdminID {
+		return AdminSessionID, nil
+	}
+
+	return UserSessionID, nil
+}
+
+func (m *UserSessionModel) Get(ctx context.Context, id uuid.UUID) (models.UserSession, error) {
+	switch id {
+	case UserSessionID:
+		return mockUserSession, nil
+	case AdminSessionID:
+		return mockAdminSession, nil
+	default:
+		return models.UserSession{}, models.ErrNoRecord
+	}
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (m *UserSessionModel) ForUser(ctx context.Context, userID uuid.UUID) ([]models.UserSession, error) {
+	switch userID {
+	case UserID:
+		return []models.UserSession{mockUserSession}, nil
+	case AdminID:
+		return []models.UserSession{mockAdminSession}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *UserSessionModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	if (userID == UserID && id == UserSessionID) || (userID == AdminID && id == AdminSessionID) {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *UserSessionModel) DeleteOthers(ctx context.Context, userID, id uuid.UUID) (int, error) {
+	return 0, nil
+}
+
+// DeleteAllForUser reports the one session every mocked user has
+func (m *UserSessionModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	return 1, nil
+}
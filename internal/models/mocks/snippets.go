@@ -1,36 +1,97 @@
 package mocks
 
 import (
+	"context"
 	"github.com/google/uuid"
+	"slices"
 	"snippetbox.doichevkostia.dev/internal/models"
 	"time"
 )
 
 var SnippetID = uuid.New()
 
+// SlowSnippetID is the snippet the query for which runs out of time
+var SlowSnippetID = uuid.New()
+
+// PrivateSnippetID is a private snippet of the UserID, only its owner can see it
+var PrivateSnippetID = uuid.New()
+
 var mockSnippet = models.Snippet{
-	ID:         SnippetID,
-	Title:      "An old silent pond",
-	Content:    "An old silent pond...",
+	ID:              SnippetID,
+	UserID:          UserID,
+	Title:           "An old silent pond",
+	Content:         "An old silent pond...",
+	Format:          models.FormatPlain,
+	Tags:            []string{"haiku", "poetry"},
+	Stars:           2,
+	CommentsEnabled: true,
+	Visibility:      models.VisibilityPublic,
+	Files: []models.SnippetFile{
+		{Filename: "frog.txt", Language: "text", Content: "A frog jumps into the pond"},
+	},
 	CreateTime: time.Now(),
 	ExpireTime: time.Now(),
 }
 
+var mockPrivateSnippet = models.Snippet{
+	ID:              PrivateSnippetID,
+	UserID:          UserID,
+	Title:           "A private diary",
+	Content:         "Dear diary...",
+	Format:          models.FormatPlain,
+	CommentsEnabled: true,
+	Visibility:      models.VisibilityPrivate,
+	CreateTime:      time.Now(),
+	ExpireTime:      time.Now(),
+}
+
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (uuid.UUID, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID uuid.UUID, title string, content string, format models.Format, visibility models.Visibility, tags []string, files []models.SnippetFile, expires int) (uuid.UUID, error) {
 	return uuid.New(), nil
 }
 
-func (m *SnippetModel) Get(id uuid.UUID) (models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id uuid.UUID) (models.Snippet, error) {
 	switch id {
 	case SnippetID:
 		return mockSnippet, nil
+	case PrivateSnippetID:
+		return mockPrivateSnippet, nil
+	case SlowSnippetID:
+		return models.Snippet{}, context.DeadlineExceeded
 	default:
 		return models.Snippet{}, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]models.Snippet, error) {
+func (m *SnippetModel) Fork(ctx context.Context, userID, id uuid.UUID, expires int) (uuid.UUID, error) {
+	if id != SnippetID && !(id == PrivateSnippetID && userID == UserID) {
+		return uuid.UUID{}, models.ErrNoRecord
+	}
+
+	return uuid.New(), nil
+}
+
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userID, id uuid.UUID, enabled bool) error {
+	if userID != UserID || id != SnippetID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *SnippetModel) Latest(ctx context.Context, tag string) ([]models.Snippet, error) {
+	if tag != "" && !slices.Contains(mockSnippet.Tags, tag) {
+		return nil, nil
+	}
+
+	return []models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) ForUser(ctx context.Context, userID uuid.UUID, tag string, limit, offset int) ([]models.Snippet, error) {
+	if userID != UserID || offset > 0 || tag != "" && !slices.Contains(mockSnippet.Tags, tag) {
+		return nil, nil
+	}
+
 	return []models.Snippet{mockSnippet}, nil
 }
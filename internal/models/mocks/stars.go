@@ -0,0 +1,39 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/models"
+)
+
+// StarModel has the snippet starred by the admin, the user hasn't starred it yet
+type StarModel struct{}
+
+func (m *StarModel) Toggle(ctx context.Context, userID, snippetID uuid.UUID) (bool, int, error) {
+	if snippetID != SnippetID {
+		return false, 0, models.ErrNoRecord
+	}
+
+	if userID == AdminID {
+		return false, mockSnippet.Stars - 1, nil
+	}
+
+	return true, mockSnippet.Stars + 1, nil
+}
+
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID uuid.UUID) (bool, error) {
+	return userID == AdminID && snippetID == SnippetID, nil
+}
+
+func (m *StarModel) ForUser(ctx context.Context, userID uuid.UUID, tag string) ([]models.Snippet, error) {
+	if userID == AdminID && (tag == "" || slices.Contains(mockSnippet.Tags, tag)) {
+		return []models.Snippet{mockSnippet}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *StarModel) MostStarred(ctx context.Context, days int) ([]models.Snippet, error) {
+	return []models.Snippet{mockSnippet}, nil
+}
@@ -0,0 +1,21 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+)
+
+type TagModel struct{}
+
+func (m *TagModel) Search(ctx context.Context, prefix string, limit int) ([]models.Tag, error) {
+	var tags []models.Tag
+
+	for _, name := range mockSnippet.Tags {
+		if strings.HasPrefix(name, prefix) && len(tags) < limit {
+			tags = append(tags, models.Tag{Name: name, Count: 1})
+		}
+	}
+
+	return tags, nil
+}
@@ -1,36 +1,149 @@
 package mocks
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"snippetbox.doichevkostia.dev/internal/models"
+	"strings"
+	"time"
 )
 
 var UserID = uuid.New()
+var AdminID = uuid.New()
+
+// SlowEmail is the email the login query for which runs out of time
+const SlowEmail = "slow@example.com"
 
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) (uuid.UUID, error) {
-	switch email {
-	case "dupe@example.com":
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (uuid.UUID, error) {
+	switch {
+	case email == "dupe@example.com":
 		return uuid.UUID{}, models.ErrDuplicateEmail
+	case name == "Dupe":
+		return uuid.UUID{}, models.ErrDuplicateName
 	default:
 		return uuid.New(), nil
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (uuid.UUID, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (uuid.UUID, error) {
+	if email == SlowEmail {
+		return uuid.UUID{}, context.DeadlineExceeded
+	}
+
 	if email == "alice@example.com" && password == "pa$$word" {
 		return UserID, nil
 	}
 
+	if email == "admin@example.com" && password == "pa$$word" {
+		return AdminID, nil
+	}
+
 	return uuid.UUID{}, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
 	switch id {
-	case UserID:
+	case UserID, AdminID:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
+
+func (m *UserModel) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	switch id {
+	case UserID:
+		return models.User{
+			ID:             UserID,
+			Name:           "Alice",
+			Email:          "alice@example.com",
+			DefaultExpires: 7,
+			Bio:            "Writes haiku",
+			HasAvatar:      true,
+			CreateTime:     time.Now(),
+		}, nil
+	case AdminID:
+		return models.User{
+			ID:             AdminID,
+			Name:           "Admin",
+			Email:          "admin@example.com",
+			Admin:          true,
+			DefaultExpires: models.DefaultExpires,
+			CreateTime:     time.Now(),
+		}, nil
+	default:
+		return models.User{}, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdatePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) error {
+	if id != UserID && id != AdminID {
+		return models.ErrNoRecord
+	}
+
+	if currentPassword != "pa$$word" {
+		return models.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func (m *UserModel) UpdateDefaultExpires(ctx context.Context, id uuid.UUID, expires int) error {
+	if id != UserID && id != AdminID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *UserModel) ByName(ctx context.Context, name string) (models.User, error) {
+	switch strings.ToLower(name) {
+	case "alice":
+		return m.Get(ctx, UserID)
+	case "admin":
+		return m.Get(ctx, AdminID)
+	case "mallory":
+		return models.User{
+			ID:         uuid.New(),
+			Name:       "Mallory",
+			Email:      "mallory@example.com",
+			Suspended:  true,
+			CreateTime: time.Now(),
+		}, nil
+	default:
+		return models.User{}, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdateBio(ctx context.Context, id uuid.UUID, bio string) error {
+	if id != UserID && id != AdminID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// AvatarPNG is the avatar of Alice
+var AvatarPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func (m *UserModel) Avatar(ctx context.Context, id uuid.UUID) (models.Avatar, error) {
+	if id != UserID {
+		return models.Avatar{}, models.ErrNoRecord
+	}
+
+	return models.Avatar{ContentType: "image/png", Data: AvatarPNG, UpdateTime: time.Now()}, nil
+}
+
+func (m *UserModel) SetAvatar(ctx context.Context, id uuid.UUID, contentType string, data []byte) error {
+	if id != UserID && id != AdminID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *UserModel) DeleteAvatar(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
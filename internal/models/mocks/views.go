@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"time"
+)
+
+type ViewModel struct{}
+
+func (m *ViewModel) Insert(ctx context.Context, views []models.View) error {
+	return nil
+}
+
+// Stats has 3 views of the snippet today
+func (m *ViewModel) Stats(ctx context.Context, snippetID uuid.UUID, days int) (models.ViewStats, error) {
+	if snippetID != SnippetID {
+		return models.ViewStats{Daily: models.DailyStats(nil, days)}, nil
+	}
+
+	counts := map[string]int{time.Now().UTC().Format(models.DayFormat): 3}
+
+	return models.ViewStats{Total: 3, Daily: models.DailyStats(counts, days)}, nil
+}
@@ -0,0 +1,281 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/models/modelstest"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDrivers are the drivers the SQLite models are tested with, the cgo one is added when cgo is enabled
+var sqliteDrivers = []string{"sqlite"}
+
+// openTestDB opens an empty database
+func openTestDB(t *testing.T, driverName string) *sql.DB {
+	// the pure Go driver doesn't wait for the locks by default, the cgo one ignores the parameter
+	db, err := sql.Open(driverName, "file:"+filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func newTestDB(t *testing.T, driverName string) *sql.DB {
+	db := openTestDB(t, driverName)
+
+	schema, err := os.ReadFile("../../sql/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSQLite(t *testing.T) {
+	for _, driverName := range sqliteDrivers {
+		t.Run(driverName, func(t *testing.T) {
+			modelstest.Run(t, func(t *testing.T) modelstest.Models {
+				db := newTestDB(t, driverName)
+
+				return modelstest.Models{
+					Snippets:       &models.SnippetModel{DB: db},
+					Collections:    &models.CollectionModel{DB: db},
+					Tags:           &models.TagModel{DB: db},
+					Comments:       &models.CommentModel{DB: db},
+					Stars:          &models.StarModel{DB: db},
+					Views:          &models.ViewModel{DB: db},
+					Users:          &models.UserModel{DB: db, Hasher: modelstest.Hasher},
+					UserSessions:   &models.UserSessionModel{DB: db},
+					RememberTokens: &models.RememberTokenModel{DB: db},
+					Health:         &models.HealthModel{DB: db},
+					WithHasher: func(hasher password.Hasher) models.UserModelInterface {
+						return &models.UserModel{DB: db, Hasher: hasher}
+					},
+				}
+			})
+		})
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	for _, driverName := range sqliteDrivers {
+		t.Run(driverName, func(t *testing.T) {
+			m := &models.SnippetModel{DB: newTestDB(t, driverName), QueryTimeout: time.Nanosecond}
+
+			_, err := m.Get(context.Background(), uuid.New())
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+			}
+
+			_, err = m.Latest(context.Background(), "")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+			}
+
+			// the login fails with the timeout, not with ErrInvalidCredentials
+			users := &models.UserModel{DB: m.DB, QueryTimeout: time.Nanosecond}
+
+			_, err = users.Authenticate(context.Background(), "alice@example.com", "pa$$word")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+// firstSchema is the schema of the first release, the one without "schema_version"
+const firstSchema = `
+create table "snippets" (
+    "id" text primary key,
+    "title" text not null,
+    "content" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_snippets_create_time" on "snippets" ("create_time");
+
+create table "sessions" (
+    "token"  text primary key,
+    "data"   BLOB NOT NULL,
+    "expiry" REAL NOT NULL
+);
+
+create index "idx_session_expiry" on "sessions" ("expiry");
+
+create table "users" (
+    "id" text primary key,
+    "name" text not null unique,
+    "email" text not null,
+    "hashed_password" text not null,
+    "create_time" timestamp not null default current_timestamp
+);
+
+create index "idx_users_email" on "users" ("email");
+`
+
+// sqliteSchema describes the columns, the foreign keys and the indexes of the tables regardless of their order
+func sqliteSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	stmt := `select 'column ' || m.name || '.' || c.name || ' ' || c.type || ' ' || c."notnull" || ' ' || coalesce(c.dflt_value, 'null') || ' ' || c.pk
+	from "sqlite_master" m join pragma_table_info(m.name) c where m.type = 'table'
+	union all
+	select 'foreign key ' || m.name || '.' || f."from" || ' ' || f."table" || '.' || f."to" || ' ' || f.on_delete
+	from "sqlite_master" m join pragma_foreign_key_list(m.name) f where m.type = 'table'
+	union all
+	select 'index ' || m.name || ' ' || coalesce(m.sql, m.tbl_name) from "sqlite_master" m where m.type = 'index'
+	order by 1`
+
+	rows, err := db.Query(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var lines []string
+
+	for rows.Next() {
+		var line string
+
+		err = rows.Scan(&line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := models.ReadMigrations(os.DirFS("../../sql/migrations/sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	for _, driverName := range sqliteDrivers {
+		t.Run(driverName, func(t *testing.T) {
+			db := openTestDB(t, driverName)
+
+			// the rebuilt tables must not delete the rows referencing them
+			db.SetMaxOpenConns(1)
+
+			_, err := db.Exec(`pragma foreign_keys = on`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = db.Exec(firstSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = db.Exec(`insert into "users" ("id", "name", "email", "hashed_password") values ('user', 'Alice', 'alice@example.com', 'hash')`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = db.Exec(`insert into "snippets" ("id", "title", "content", "expire_time") values ('snippet', 'Snippet', 'Snippet', current_timestamp)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from, err := models.Migrate(ctx, db, migrations[:1])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, from, 0)
+
+			_, err = db.Exec(`insert into "user_sessions" ("id", "user_id", "ip", "user_agent", "expire_time") values ('session', 'user', '', '', current_timestamp)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from, err = models.Migrate(ctx, db, migrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, from, 1)
+			assert.Equal(t, sqliteSchema(t, db), sqliteSchema(t, newTestDB(t, driverName)))
+
+			// the snippets of the first release go to the oldest user
+			var owner string
+
+			err = db.QueryRow(`select "user_id" from "snippets" where "id" = 'snippet'`).Scan(&owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, owner, "user")
+
+			var sessions int
+
+			err = db.QueryRow(`select count(*) from "user_sessions"`).Scan(&sessions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, sessions, 1)
+
+			version, err := (&models.HealthModel{DB: db}).SchemaVersion(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, version, models.SchemaVersion)
+
+			from, err = models.Migrate(ctx, db, migrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, from, models.SchemaVersion)
+		})
+	}
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	migrations, err := models.ReadMigrations(os.DirFS("../../sql/migrations/sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, driverName := range sqliteDrivers {
+		t.Run(driverName, func(t *testing.T) {
+			_, err := models.Migrate(context.Background(), openTestDB(t, driverName), migrations)
+			if !errors.Is(err, models.ErrNoSchema) {
+				t.Errorf("got error %v; want %v", err, models.ErrNoSchema)
+			}
+		})
+	}
+}
@@ -0,0 +1,1526 @@
+// Package modelstest is the conformance suite of the storage backends. Every implementation of the model interfaces
+// runs it from its own tests, so that the backends are interchangeable
+package modelstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Hasher is the one the user models of the backends must use, the minimal bcrypt cost keeps the suite fast
+var Hasher password.Hasher = &password.Bcrypt{Cost: bcrypt.MinCost}
+
+// Models of one backend. The models must be empty
+type Models struct {
+	Snippets       models.SnippetModelInterface
+	Collections    models.CollectionModelInterface
+	Tags           models.TagModelInterface
+	Comments       models.CommentModelInterface
+	Stars          models.StarModelInterface
+	Views          models.ViewModelInterface
+	Users          models.UserModelInterface
+	UserSessions   models.UserSessionModelInterface
+	RememberTokens models.RememberTokenModelInterface
+	Health         models.HealthModelInterface
+
+	// WithHasher returns a user model with another hasher on top of the same data, the hasher was changed in the config
+	WithHasher func(hasher password.Hasher) models.UserModelInterface
+}
+
+// Run calls newModels for every test, so the tests don't see the data of each other
+func Run(t *testing.T, newModels func(t *testing.T) Models) {
+	t.Run("Snippets", func(t *testing.T) { testSnippets(t, newModels(t)) })
+	t.Run("Forks", func(t *testing.T) { testForks(t, newModels(t)) })
+	t.Run("Visibility", func(t *testing.T) { testVisibility(t, newModels(t)) })
+	t.Run("Tags", func(t *testing.T) { testTags(t, newModels(t)) })
+	t.Run("Collections", func(t *testing.T) { testCollections(t, newModels(t)) })
+	t.Run("Comments", func(t *testing.T) { testComments(t, newModels(t)) })
+	t.Run("Stars", func(t *testing.T) { testStars(t, newModels(t)) })
+	t.Run("StarRace", func(t *testing.T) { testStarRace(t, newModels(t)) })
+	t.Run("Views", func(t *testing.T) { testViews(t, newModels(t)) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, newModels(t)) })
+	t.Run("Profiles", func(t *testing.T) { testProfiles(t, newModels(t)) })
+	t.Run("ParallelSignups", func(t *testing.T) { testParallelSignups(t, newModels(t)) })
+	t.Run("Rehash", func(t *testing.T) { testRehash(t, newModels(t)) })
+	t.Run("UpdatePassword", func(t *testing.T) { testUpdatePassword(t, newModels(t)) })
+	t.Run("UserSessions", func(t *testing.T) { testUserSessions(t, newModels(t)) })
+	t.Run("RememberTokens", func(t *testing.T) { testRememberTokens(t, newModels(t)) })
+	t.Run("RememberTokenRace", func(t *testing.T) { testRememberTokenRace(t, newModels(t)) })
+	t.Run("Health", func(t *testing.T) { testHealth(t, newModels(t)) })
+}
+
+func assertErr(t *testing.T, got, want error) {
+	t.Helper()
+
+	if !errors.Is(got, want) {
+		t.Errorf("got error %v; want %v", got, want)
+	}
+}
+
+func assertRecent(t *testing.T, tm time.Time) {
+	t.Helper()
+
+	if d := time.Since(tm); d < -time.Minute || d > time.Minute {
+		t.Errorf("got time %s; want around now, is the time zone right?", tm)
+	}
+}
+
+func testSnippets(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+
+	id, err := m.Snippets.Insert(ctx, alice, "An old silent pond", "An old silent pond...", models.FormatMarkdown, models.VisibilityPublic, []string{"Haiku", " poetry ", "haiku", ""}, nil, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Snippets.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s.ID, id)
+	assert.Equal(t, s.UserID, alice)
+	assert.Equal(t, s.Title, "An old silent pond")
+	assert.Equal(t, s.Content, "An old silent pond...")
+	assert.Equal(t, s.Format, models.FormatMarkdown)
+	assert.Equal(t, fmt.Sprint(s.Tags), "[haiku poetry]")
+	assert.Equal(t, s.ExpireTime.Sub(s.CreateTime), 7*24*time.Hour)
+	assertRecent(t, s.CreateTime)
+	assert.Equal(t, len(s.Files), 0)
+
+	files := []models.SnippetFile{
+		{Filename: "Dockerfile", Language: "dockerfile", Content: "FROM golang"},
+		{Filename: "compose.yaml", Language: "yaml", Content: "services:"},
+	}
+
+	gistID, err := m.Snippets.Insert(ctx, alice, "Docker", "Build and run", models.FormatPlain, models.VisibilityPublic, nil, files, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gist, err := m.Snippets.Get(ctx, gistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(gist.Files), fmt.Sprint(files))
+
+	_, err = m.Snippets.Get(ctx, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+
+	expiredID, err := m.Snippets.Insert(ctx, alice, "Expired", "Expired", models.FormatPlain, models.VisibilityPublic, []string{"haiku"}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Snippets.Get(ctx, expiredID)
+	assertErr(t, err, models.ErrNoRecord)
+
+	for i := 0; i < 10; i++ {
+		_, err = m.Snippets.Insert(ctx, alice, "More", "More", models.FormatPlain, models.VisibilityPublic, nil, nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := m.Snippets.Latest(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(latest), 10)
+
+	for _, s := range latest {
+		if s.ID == expiredID {
+			t.Error("expired snippet is in the latest ones")
+		}
+	}
+
+	tagged, err := m.Snippets.Latest(ctx, "haiku")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(tagged), 1)
+	assert.Equal(t, tagged[0].ID, id)
+	assert.Equal(t, fmt.Sprint(tagged[0].Tags), "[haiku poetry]")
+
+	tagged, err = m.Snippets.Latest(ctx, "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(tagged), 0)
+}
+
+func testForks(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	files := []models.SnippetFile{{Filename: "main.go", Language: "go", Content: "package main"}}
+
+	id, err := m.Snippets.Insert(ctx, alice, "Original", "Original content", models.FormatCode, models.VisibilityPublic, []string{"go"}, files, 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forkID, err := m.Snippets.Fork(ctx, bob, id, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := m.Snippets.Get(ctx, forkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fork.UserID, bob)
+	assert.Equal(t, fork.Title, "Original")
+	assert.Equal(t, fork.Content, "Original content")
+	assert.Equal(t, fork.Format, models.FormatCode)
+	assert.Equal(t, fmt.Sprint(fork.Tags), "[go]")
+	assert.Equal(t, fmt.Sprint(fork.Files), fmt.Sprint(files))
+	assert.Equal(t, fork.ForkedFrom, uuid.NullUUID{UUID: id, Valid: true})
+	assert.Equal(t, fork.Forks, 0)
+	// the expiry of the caller, not of the original
+	assert.Equal(t, fork.ExpireTime.Sub(fork.CreateTime), 7*24*time.Hour)
+
+	_, err = m.Snippets.Fork(ctx, alice, forkID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := m.Snippets.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fork of the fork is not counted
+	assert.Equal(t, original.ForkedFrom.Valid, false)
+	assert.Equal(t, original.Forks, 1)
+
+	expired := insertSnippet(t, m, alice, "Expired", 0)
+
+	_, err = m.Snippets.Fork(ctx, bob, expired, 7)
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, err = m.Snippets.Fork(ctx, bob, uuid.New(), 7)
+	assertErr(t, err, models.ErrNoRecord)
+
+	// a private snippet is forked only by its owner, the forks keep the visibility
+	for _, visibility := range []models.Visibility{models.VisibilityUnlisted, models.VisibilityPrivate} {
+		id, err := m.Snippets.Insert(ctx, alice, "Hidden", "Hidden", models.FormatPlain, visibility, nil, nil, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		forker := bob
+		if visibility == models.VisibilityPrivate {
+			_, err = m.Snippets.Fork(ctx, bob, id, 7)
+			assertErr(t, err, models.ErrNoRecord)
+
+			forker = alice
+		}
+
+		forkID, err := m.Snippets.Fork(ctx, forker, id, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fork, err := m.Snippets.Get(ctx, forkID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, fork.Visibility, visibility)
+	}
+}
+
+func testVisibility(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	ids := make(map[models.Visibility]uuid.UUID)
+
+	for _, visibility := range []models.Visibility{models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate} {
+		id, err := m.Snippets.Insert(ctx, alice, string(visibility), string(visibility), models.FormatPlain, visibility, []string{"vis-" + string(visibility)}, nil, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := m.Snippets.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, s.Visibility, visibility)
+		ids[visibility] = id
+	}
+
+	titles := func(snippets []models.Snippet, err error) string {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		for _, s := range snippets {
+			titles = append(titles, s.Title)
+		}
+
+		return strings.Join(titles, ",")
+	}
+
+	// only the public snippets are listed
+	assert.Equal(t, titles(m.Snippets.Latest(ctx, "")), "public")
+	assert.Equal(t, titles(m.Snippets.Latest(ctx, "vis-unlisted")), "")
+	assert.Equal(t, titles(m.Snippets.ForUser(ctx, alice, "", 10, 0)), "public")
+	assert.Equal(t, titles(m.Snippets.ForUser(ctx, alice, "vis-public", 10, 0)), "public")
+	assert.Equal(t, titles(m.Snippets.ForUser(ctx, alice, "vis-private", 10, 0)), "")
+
+	tags, err := m.Tags.Search(ctx, "vis-", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(tags), "[{vis-public 1}]")
+
+	// the link is enough to star an unlisted snippet, a private one is starred only by its owner
+	_, _, err = m.Stars.Toggle(ctx, bob, ids[models.VisibilityPrivate])
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, _, err = m.Stars.Toggle(ctx, bob, ids[models.VisibilityUnlisted])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = m.Stars.Toggle(ctx, alice, ids[models.VisibilityPrivate])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = m.Stars.Toggle(ctx, insertUser(t, m, "carol@example.com"), ids[models.VisibilityPublic])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, titles(m.Stars.ForUser(ctx, bob, "")), "unlisted")
+	assert.Equal(t, titles(m.Stars.ForUser(ctx, alice, "")), "private")
+	assert.Equal(t, titles(m.Stars.ForUser(ctx, bob, "vis-unlisted")), "unlisted")
+	assert.Equal(t, titles(m.Stars.ForUser(ctx, bob, "vis-public")), "")
+	assert.Equal(t, titles(m.Stars.MostStarred(ctx, 7)), "public")
+
+	// a private snippet of another user can't be collected, the owner sees it in the own collection
+	bobs, err := m.Collections.Insert(ctx, bob, "Bob's", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.AddSnippet(ctx, bob, bobs, ids[models.VisibilityPrivate])
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.AddSnippet(ctx, bob, bobs, ids[models.VisibilityUnlisted])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alices, err := m.Collections.Insert(ctx, alice, "Alice's", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.AddSnippet(ctx, alice, alices, ids[models.VisibilityPrivate])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippets, err := m.Collections.Snippets(ctx, alices, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].Visibility, models.VisibilityPrivate)
+	assert.Equal(t, snippets[0].VisibleTo(alice), true)
+	assert.Equal(t, snippets[0].VisibleTo(bob), false)
+	assert.Equal(t, snippets[0].VisibleTo(uuid.UUID{}), false)
+}
+
+func testComments(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	snippetID := insertSnippet(t, m, alice, "Commented", 7)
+	otherID := insertSnippet(t, m, alice, "Other", 7)
+
+	s, err := m.Snippets.Get(ctx, snippetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s.CommentsEnabled, true)
+
+	first, err := m.Comments.Insert(ctx, bob, snippetID, uuid.NullUUID{}, "First")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := m.Comments.Insert(ctx, alice, snippetID, uuid.NullUUID{UUID: first, Valid: true}, "Reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := m.Comments.Get(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, c.SnippetID, snippetID)
+	assert.Equal(t, c.UserID, alice)
+	assert.Equal(t, c.UserName, "alice@example.com")
+	assert.Equal(t, c.ParentID, uuid.NullUUID{UUID: first, Valid: true})
+	assert.Equal(t, c.Content, "Reply")
+	assertRecent(t, c.CreateTime)
+
+	// the parent must be on the same snippet
+	_, err = m.Comments.Insert(ctx, bob, otherID, uuid.NullUUID{UUID: first, Valid: true}, "Elsewhere")
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, err = m.Comments.Insert(ctx, bob, uuid.New(), uuid.NullUUID{}, "Nowhere")
+	assertErr(t, err, models.ErrNoRecord)
+
+	expired := insertSnippet(t, m, alice, "Expired", 0)
+
+	_, err = m.Comments.Insert(ctx, bob, expired, uuid.NullUUID{}, "Too late")
+	assertErr(t, err, models.ErrNoRecord)
+
+	// only the author edits
+	err = m.Comments.Update(ctx, alice, first, "Changed")
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Comments.Update(ctx, bob, first, "Changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner of the snippet deletes any comment, the others only their own
+	err = m.Comments.Delete(ctx, bob, reply)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Comments.Delete(ctx, alice, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Comments.Delete(ctx, alice, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Comments.Update(ctx, bob, first, "Again")
+	assertErr(t, err, models.ErrNoRecord)
+
+	comments, err := m.Comments.ForSnippet(ctx, snippetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(comments), 2)
+
+	// the deleted comment stays for the reply
+	threaded := models.ThreadComments(comments)
+	assert.Equal(t, threaded[0].ID, first)
+	assert.Equal(t, threaded[0].Content, "")
+	assert.Equal(t, threaded[0].Edited, true)
+	assert.Equal(t, threaded[0].Deleted, true)
+	assert.Equal(t, threaded[1].ID, reply)
+	assert.Equal(t, threaded[1].Depth, 1)
+
+	err = m.Snippets.SetCommentsEnabled(ctx, bob, snippetID, false)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Snippets.SetCommentsEnabled(ctx, alice, snippetID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = m.Snippets.Get(ctx, snippetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s.CommentsEnabled, false)
+
+	_, err = m.Comments.Insert(ctx, bob, snippetID, uuid.NullUUID{}, "Closed")
+	assertErr(t, err, models.ErrCommentsDisabled)
+}
+
+func testStars(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	popular := insertSnippet(t, m, alice, "Popular", 7)
+	other := insertSnippet(t, m, alice, "Other", 7)
+	insertSnippet(t, m, alice, "Unstarred", 7)
+
+	starred, stars, err := m.Stars.Toggle(ctx, alice, popular)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, starred, true)
+	assert.Equal(t, stars, 1)
+
+	starred, stars, err = m.Stars.Toggle(ctx, bob, popular)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, starred, true)
+	assert.Equal(t, stars, 2)
+
+	_, _, err = m.Stars.Toggle(ctx, bob, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := m.Stars.Starred(ctx, alice, popular)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ok, true)
+
+	ok, err = m.Stars.Starred(ctx, alice, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ok, false)
+
+	s, err := m.Snippets.Get(ctx, popular)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s.Stars, 2)
+
+	latest, err := m.Snippets.Latest(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range latest {
+		switch s.ID {
+		case popular:
+			assert.Equal(t, s.Stars, 2)
+		case other:
+			assert.Equal(t, s.Stars, 1)
+		default:
+			assert.Equal(t, s.Stars, 0)
+		}
+	}
+
+	mostStarred, err := m.Stars.MostStarred(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(mostStarred), 2)
+	assert.Equal(t, mostStarred[0].ID, popular)
+	assert.Equal(t, mostStarred[0].Stars, 2)
+	assert.Equal(t, mostStarred[1].ID, other)
+
+	forAlice, err := m.Stars.ForUser(ctx, alice, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(forAlice), 1)
+	assert.Equal(t, forAlice[0].ID, popular)
+	assert.Equal(t, forAlice[0].Title, "Popular")
+
+	// the second toggle takes the star back
+	starred, stars, err = m.Stars.Toggle(ctx, alice, popular)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, starred, false)
+	assert.Equal(t, stars, 1)
+
+	forAlice, err = m.Stars.ForUser(ctx, alice, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(forAlice), 0)
+
+	expired := insertSnippet(t, m, alice, "Expired", 0)
+
+	_, _, err = m.Stars.Toggle(ctx, bob, expired)
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, _, err = m.Stars.Toggle(ctx, bob, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+}
+
+// testStarRace stars a snippet from parallel requests of one user, like a double click. A request that loses the race
+// to the first star must not get ErrNoRecord, the snippet exists
+func testStarRace(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	snippetID := insertSnippet(t, m, alice, "Starred", 7)
+
+	const n = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = m.Stars.Toggle(ctx, alice, snippetID)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+	}
+
+	starred, err := m.Stars.Starred(ctx, alice, snippetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Snippets.Get(ctx, snippetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one star at most, whichever request was the last one
+	want := 0
+	if starred {
+		want = 1
+	}
+
+	assert.Equal(t, s.Stars, want)
+}
+
+func testViews(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	snippetID := insertSnippet(t, m, alice, "Viewed", 7)
+
+	now := time.Now()
+
+	err := m.Views.Insert(ctx, []models.View{
+		{SnippetID: snippetID, Visitor: "a", Time: now},
+		{SnippetID: snippetID, Visitor: "a", Time: now}, // the same visitor on the same day
+		{SnippetID: snippetID, Visitor: "b", Time: now},
+		{SnippetID: snippetID, Visitor: "a", Time: now.AddDate(0, 0, -1)},
+		{SnippetID: snippetID, Visitor: "a", Time: now.AddDate(0, 0, -40)},
+		{SnippetID: uuid.New(), Visitor: "a", Time: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the next batch repeats a view
+	err = m.Views.Insert(ctx, []models.View{{SnippetID: snippetID, Visitor: "b", Time: now}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.Views.Stats(ctx, snippetID, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, stats.Total, 4)
+	assert.Equal(t, len(stats.Daily), 30)
+	assert.Equal(t, stats.Daily[29].Day.Format(models.DayFormat), now.UTC().Format(models.DayFormat))
+	assert.Equal(t, stats.Daily[29].Views, 2)
+	assert.Equal(t, stats.Daily[28].Views, 1)
+	assert.Equal(t, stats.Daily[0].Views, 0)
+
+	stats, err = m.Views.Stats(ctx, insertSnippet(t, m, alice, "Unviewed", 7), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, stats.Total, 0)
+	assert.Equal(t, len(stats.Daily), 30)
+}
+
+func testTags(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+
+	for _, tags := range [][]string{{"go", "golang"}, {"go", "sql"}, {"go_test"}} {
+		_, err := m.Snippets.Insert(ctx, alice, "Tagged", "Tagged", models.FormatPlain, models.VisibilityPublic, tags, nil, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.Snippets.Insert(ctx, alice, "Expired", "Expired", models.FormatPlain, models.VisibilityPublic, []string{"gopher"}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := m.Tags.Search(ctx, "GO", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the most used first, the expired snippets are not counted
+	assert.Equal(t, fmt.Sprint(tags), "[{go 2} {go_test 1} {golang 1}]")
+
+	tags, err = m.Tags.Search(ctx, "go", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(tags), "[{go 2}]")
+
+	// "_" is not a wildcard
+	tags, err = m.Tags.Search(ctx, "go_", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(tags), "[{go_test 1}]")
+
+	tags, err = m.Tags.Search(ctx, "%", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(tags), 0)
+}
+
+func insertSnippet(t *testing.T, m Models, userID uuid.UUID, title string, expires int) uuid.UUID {
+	t.Helper()
+
+	id, err := m.Snippets.Insert(context.Background(), userID, title, title, models.FormatPlain, models.VisibilityPublic, nil, nil, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func snippetIDs(t *testing.T, m Models, collectionID uuid.UUID) []uuid.UUID {
+	t.Helper()
+
+	snippets, err := m.Collections.Snippets(context.Background(), collectionID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uuid.UUID
+	for _, s := range snippets {
+		ids = append(ids, s.ID)
+	}
+
+	return ids
+}
+
+func testCollections(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	id, err := m.Collections.Insert(ctx, alice, "Haiku", models.VisibilityPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := m.Collections.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, c.ID, id)
+	assert.Equal(t, c.UserID, alice)
+	assert.Equal(t, c.Name, "Haiku")
+	assert.Equal(t, c.Visibility, models.VisibilityPrivate)
+	assertRecent(t, c.CreateTime)
+
+	_, err = m.Collections.Get(ctx, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, err = m.Collections.Insert(ctx, alice, "HAIKU", models.VisibilityPublic)
+	assertErr(t, err, models.ErrDuplicateName)
+
+	// the names are unique per user
+	_, err = m.Collections.Insert(ctx, bob, "Haiku", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := m.Collections.Insert(ctx, alice, "Go", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collections, err := m.Collections.ForUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(collections), 2)
+	assert.Equal(t, collections[0].Name, "Go")
+	assert.Equal(t, collections[1].Name, "Haiku")
+
+	// bob can't change the collection of alice
+	err = m.Collections.Update(ctx, bob, id, "Renamed", models.VisibilityPublic)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.Update(ctx, alice, id, "go", models.VisibilityPublic)
+	assertErr(t, err, models.ErrDuplicateName)
+
+	err = m.Collections.Update(ctx, alice, id, "Poems", models.VisibilityUnlisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = m.Collections.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, c.Name, "Poems")
+	assert.Equal(t, c.Visibility, models.VisibilityUnlisted)
+
+	first := insertSnippet(t, m, alice, "First", 7)
+	second := insertSnippet(t, m, bob, "Second", 7)
+	third := insertSnippet(t, m, alice, "Third", 7)
+	expired := insertSnippet(t, m, alice, "Expired", 0)
+
+	for _, snippetID := range []uuid.UUID{first, second, third} {
+		err = m.Collections.AddSnippet(ctx, alice, id, snippetID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// adding again changes nothing
+	err = m.Collections.AddSnippet(ctx, alice, id, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.AddSnippet(ctx, alice, id, expired)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.AddSnippet(ctx, alice, id, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.AddSnippet(ctx, bob, id, second)
+	assertErr(t, err, models.ErrNoRecord)
+
+	assert.Equal(t, fmt.Sprint(snippetIDs(t, m, id)), fmt.Sprint([]uuid.UUID{first, second, third}))
+
+	err = m.Collections.Reorder(ctx, alice, id, []uuid.UUID{third, first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(snippetIDs(t, m, id)), fmt.Sprint([]uuid.UUID{third, first, second}))
+
+	err = m.Collections.Reorder(ctx, alice, id, []uuid.UUID{uuid.New()})
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.Reorder(ctx, bob, id, []uuid.UUID{second})
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.RemoveSnippet(ctx, bob, id, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.RemoveSnippet(ctx, alice, id, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.RemoveSnippet(ctx, alice, id, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	assert.Equal(t, fmt.Sprint(snippetIDs(t, m, id)), fmt.Sprint([]uuid.UUID{third, second}))
+
+	// a removed snippet goes to the end when it is added back
+	err = m.Collections.AddSnippet(ctx, alice, id, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(snippetIDs(t, m, id)), fmt.Sprint([]uuid.UUID{third, second, first}))
+
+	err = m.Collections.AddSnippet(ctx, alice, other, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.Delete(ctx, bob, id)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Collections.Delete(ctx, alice, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Collections.Get(ctx, id)
+	assertErr(t, err, models.ErrNoRecord)
+
+	assert.Equal(t, len(snippetIDs(t, m, id)), 0)
+
+	// the snippets and the other collections are not touched
+	_, err = m.Snippets.Get(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fmt.Sprint(snippetIDs(t, m, other)), fmt.Sprint([]uuid.UUID{first}))
+
+	tagged, err := m.Snippets.Insert(ctx, alice, "Tagged", "Tagged", models.FormatPlain, models.VisibilityPublic, []string{"haiku"}, nil, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Collections.AddSnippet(ctx, alice, other, tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippets, err := m.Collections.Snippets(ctx, other, "haiku")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].ID, tagged)
+}
+
+func testUsers(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	id, err := m.Users.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := m.Users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, u.ID, id)
+	assert.Equal(t, u.Name, "Alice")
+	assert.Equal(t, u.Email, "alice@example.com")
+	assert.Equal(t, u.Admin, false)
+	assert.Equal(t, u.DefaultExpires, models.DefaultExpires)
+	assertRecent(t, u.CreateTime)
+
+	err = m.Users.UpdateDefaultExpires(ctx, id, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = m.Users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, u.DefaultExpires, 7)
+
+	err = m.Users.UpdateDefaultExpires(ctx, uuid.New(), 7)
+	assertErr(t, err, models.ErrNoRecord)
+
+	if string(u.HashedPassword) == "pa$$word" {
+		t.Error("password is stored in plain text")
+	}
+
+	_, err = m.Users.Get(ctx, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, err = m.Users.Insert(ctx, "Alice 2", "alice@example.com", "pa$$word")
+	assertErr(t, err, models.ErrDuplicateEmail)
+
+	_, err = m.Users.Insert(ctx, "Alice 2", "ALICE@Example.com", "pa$$word")
+	assertErr(t, err, models.ErrDuplicateEmail)
+
+	_, err = m.Users.Insert(ctx, "alice", "alice2@example.com", "pa$$word")
+	assertErr(t, err, models.ErrDuplicateName)
+
+	exists, err := m.Users.Exists(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exists, true)
+
+	exists, err = m.Users.Exists(ctx, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exists, false)
+
+	authenticated, err := m.Users.Authenticate(ctx, "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authenticated, id)
+
+	authenticated, err = m.Users.Authenticate(ctx, "Alice@Example.COM", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authenticated, id)
+
+	_, err = m.Users.Authenticate(ctx, "alice@example.com", "wrong")
+	assertErr(t, err, models.ErrInvalidCredentials)
+
+	_, err = m.Users.Authenticate(ctx, "bob@example.com", "pa$$word")
+	assertErr(t, err, models.ErrInvalidCredentials)
+}
+
+// testParallelSignups signs up with the same email from many goroutines, exactly one of them has to win
+func testProfiles(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	id, err := m.Users.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := m.Users.ByName(ctx, "aLICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, u.ID, id)
+	assert.Equal(t, u.Bio, "")
+	assert.Equal(t, u.Suspended, false)
+	assert.Equal(t, u.HasAvatar, false)
+
+	_, err = m.Users.ByName(ctx, "Bob")
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Users.UpdateBio(ctx, id, "Writes haiku")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Users.UpdateBio(ctx, uuid.New(), "Writes haiku")
+	assertErr(t, err, models.ErrNoRecord)
+
+	_, err = m.Users.Avatar(ctx, id)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Users.SetAvatar(ctx, id, "image/png", []byte("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second one replaces the first
+	err = m.Users.SetAvatar(ctx, id, "image/gif", []byte("gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := m.Users.Avatar(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, a.ContentType, "image/gif")
+	assert.Equal(t, string(a.Data), "gif")
+	assertRecent(t, a.UpdateTime)
+
+	u, err = m.Users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, u.Bio, "Writes haiku")
+	assert.Equal(t, u.HasAvatar, true)
+
+	err = m.Users.DeleteAvatar(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Users.Avatar(ctx, id)
+	assertErr(t, err, models.ErrNoRecord)
+
+	u, err = m.Users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, u.HasAvatar, false)
+
+	// the snippets of the profile are paged, without the expired ones and the ones of the others
+	bob := insertUser(t, m, "bob@example.com")
+	insertSnippet(t, m, bob, "Bob's", 7)
+	insertSnippet(t, m, id, "Expired", 0)
+
+	want := map[uuid.UUID]bool{}
+	for i := range 3 {
+		want[insertSnippet(t, m, id, fmt.Sprintf("Snippet %d", i), 7)] = true
+	}
+
+	first, err := m.Snippets.ForUser(ctx, id, "", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.Snippets.ForUser(ctx, id, "", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(first), 2)
+	assert.Equal(t, len(second), 1)
+
+	for _, s := range append(first, second...) {
+		if !want[s.ID] {
+			t.Errorf("got snippet %q; want one of the profile, once", s.Title)
+		}
+		delete(want, s.ID)
+	}
+
+	none, err := m.Snippets.ForUser(ctx, id, "", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(none), 0)
+}
+
+func testParallelSignups(t *testing.T, m Models) {
+	const signups = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, signups)
+
+	for i := 0; i < signups; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// the case differs, so that only the unique index on lower("email") can catch it
+			email := "alice@example.com"
+			if i%2 == 1 {
+				email = "ALICE@example.com"
+			}
+
+			_, err := m.Users.Insert(context.Background(), fmt.Sprintf("Alice %d", i), email, "pa$$word")
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var created, duplicates int
+
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, models.ErrDuplicateEmail):
+			duplicates++
+		default:
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	assert.Equal(t, created, 1)
+	assert.Equal(t, duplicates, signups-1)
+}
+
+func hashOf(t *testing.T, m Models, id uuid.UUID) string {
+	t.Helper()
+
+	u, err := m.Users.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(u.HashedPassword)
+}
+
+func testRehash(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	id := insertUser(t, m, "alice@example.com")
+	oldHash := hashOf(t, m, id)
+
+	if !strings.HasPrefix(oldHash, "$2a$") {
+		t.Fatalf("got hash %s; want bcrypt", oldHash)
+	}
+
+	upgraded := m.WithHasher(&password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	// a wrong password doesn't touch the hash
+	_, err := upgraded.Authenticate(ctx, "alice@example.com", "wrong")
+	assertErr(t, err, models.ErrInvalidCredentials)
+	assert.Equal(t, hashOf(t, m, id), oldHash)
+
+	authenticated, err := upgraded.Authenticate(ctx, "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authenticated, id)
+
+	newHash := hashOf(t, m, id)
+	if !strings.HasPrefix(newHash, "$argon2id$") {
+		t.Fatalf("got hash %s; want argon2id", newHash)
+	}
+
+	// the upgraded hash works and stays as it is
+	authenticated, err = upgraded.Authenticate(ctx, "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authenticated, id)
+	assert.Equal(t, hashOf(t, m, id), newHash)
+}
+
+func testUpdatePassword(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	id := insertUser(t, m, "alice@example.com")
+
+	err := m.Users.UpdatePassword(ctx, id, "wrong", "new pa$$word")
+	assertErr(t, err, models.ErrInvalidCredentials)
+
+	err = m.Users.UpdatePassword(ctx, uuid.New(), "pa$$word", "new pa$$word")
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.Users.UpdatePassword(ctx, id, "pa$$word", "new pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Users.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assertErr(t, err, models.ErrInvalidCredentials)
+
+	authenticated, err := m.Users.Authenticate(ctx, "alice@example.com", "new pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authenticated, id)
+}
+
+func insertUser(t *testing.T, m Models, email string) uuid.UUID {
+	t.Helper()
+
+	id, err := m.Users.Insert(context.Background(), email, email, "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func testUserSessions(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	bob := insertUser(t, m, "bob@example.com")
+
+	first, err := m.UserSessions.Insert(ctx, alice, "192.0.2.1", "Firefox", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.UserSessions.Get(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s.ID, first)
+	assert.Equal(t, s.UserID, alice)
+	assert.Equal(t, s.IP, "192.0.2.1")
+	assert.Equal(t, s.UserAgent, "Firefox")
+	assert.Equal(t, s.ExpireTime.Sub(s.CreateTime), time.Hour)
+	assertRecent(t, s.LastSeenTime)
+
+	second, err := m.UserSessions.Insert(ctx, alice, "192.0.2.2", "Chrome", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := m.UserSessions.Insert(ctx, alice, "192.0.2.3", "Safari", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.UserSessions.Get(ctx, expired)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.UserSessions.Touch(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := m.UserSessions.ForUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 2)
+
+	// bob can't revoke the session of alice
+	err = m.UserSessions.Delete(ctx, bob, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.UserSessions.Delete(ctx, alice, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.UserSessions.Get(ctx, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.UserSessions.Delete(ctx, alice, first)
+	assertErr(t, err, models.ErrNoRecord)
+
+	third, err := m.UserSessions.Insert(ctx, alice, "192.0.2.4", "Edge", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobs, err := m.UserSessions.Insert(ctx, bob, "192.0.2.5", "Firefox", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session of bob stays
+	n, err := m.UserSessions.DeleteOthers(ctx, alice, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+
+	_, err = m.UserSessions.Get(ctx, third)
+	assertErr(t, err, models.ErrNoRecord)
+
+	for _, id := range []uuid.UUID{second, bobs} {
+		_, err = m.UserSessions.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = m.UserSessions.Insert(ctx, alice, "192.0.2.6", "Safari", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = m.UserSessions.DeleteAllForUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 2)
+
+	_, err = m.UserSessions.Get(ctx, second)
+	assertErr(t, err, models.ErrNoRecord)
+
+	sessions, err = m.UserSessions.ForUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 0)
+}
+
+func testRememberTokens(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+	sessionID := uuid.New()
+
+	rt, err := m.RememberTokens.Insert(ctx, alice, sessionID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, rt.UserID, alice)
+	assert.Equal(t, rt.UserSessionID, sessionID)
+
+	_, err = m.RememberTokens.Rotate(ctx, "unknown", rt.Token)
+	assertErr(t, err, models.ErrNoRecord)
+
+	first, err := m.RememberTokens.Rotate(ctx, rt.Series, rt.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, first.Series, rt.Series)
+	assert.Equal(t, first.UserID, alice)
+	assert.Equal(t, first.UserSessionID, sessionID)
+
+	if first.Token == rt.Token {
+		t.Error("the token was not replaced")
+	}
+
+	// right after the rotation the old token is a request that lost the race, the series stays
+	_, err = m.RememberTokens.Rotate(ctx, rt.Series, rt.Token)
+	assertErr(t, err, models.ErrTokenRotated)
+
+	newSessionID := uuid.New()
+
+	err = m.RememberTokens.SetUserSession(ctx, rt.Series, newSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.RememberTokens.Rotate(ctx, rt.Series, first.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, second.UserSessionID, newSessionID)
+
+	// the token before the previous one can only be a stolen one, the whole series is revoked
+	stolen, err := m.RememberTokens.Rotate(ctx, rt.Series, rt.Token)
+	assertErr(t, err, models.ErrTokenReused)
+	assert.Equal(t, stolen.UserID, alice)
+
+	_, err = m.RememberTokens.Rotate(ctx, rt.Series, second.Token)
+	assertErr(t, err, models.ErrNoRecord)
+
+	err = m.RememberTokens.SetUserSession(ctx, rt.Series, uuid.New())
+	assertErr(t, err, models.ErrNoRecord)
+
+	expired, err := m.RememberTokens.Insert(ctx, alice, uuid.New(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RememberTokens.Rotate(ctx, expired.Series, expired.Token)
+	assertErr(t, err, models.ErrNoRecord)
+
+	bySession, err := m.RememberTokens.Insert(ctx, alice, sessionID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.RememberTokens.DeleteForSession(ctx, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RememberTokens.Rotate(ctx, bySession.Series, bySession.Token)
+	assertErr(t, err, models.ErrNoRecord)
+
+	byUser, err := m.RememberTokens.Insert(ctx, alice, uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.RememberTokens.DeleteAllForUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RememberTokens.Rotate(ctx, byUser.Series, byUser.Token)
+	assertErr(t, err, models.ErrNoRecord)
+}
+
+// testRememberTokenRace rotates one token from parallel requests, like the tabs of a browser restored at once.
+// One of them wins, the others lose the race without revoking the series
+func testRememberTokenRace(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, m, "alice@example.com")
+
+	rt, err := m.RememberTokens.Insert(ctx, alice, uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+
+	var wg sync.WaitGroup
+	results := make([]models.RememberToken, n)
+	errs := make([]error, n)
+
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = m.RememberTokens.Rotate(ctx, rt.Series, rt.Token)
+		}()
+	}
+
+	wg.Wait()
+
+	var won models.RememberToken
+	wins := 0
+
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			won = results[i]
+			wins++
+		case errors.Is(err, models.ErrTokenRotated):
+		default:
+			t.Errorf("got error %v; want nil or %v", err, models.ErrTokenRotated)
+		}
+	}
+
+	assert.Equal(t, wins, 1)
+
+	// the series survived with the token of the winner
+	_, err = m.RememberTokens.Rotate(ctx, rt.Series, won.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testHealth(t *testing.T, m Models) {
+	ctx := context.Background()
+
+	err := m.Health.Ping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := m.Health.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, version, models.SchemaVersion)
+}
@@ -0,0 +1,318 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type CollectionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID uuid.UUID, name string, visibility models.Visibility) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "collections" ("id", "user_id", "name", "visibility") values ($1, $2, $3, $4)`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id, userID, name, visibility)
+	if err != nil {
+		if uniqueViolation(err, "idx_collections_user_id_name") {
+			return uuid.UUID{}, models.ErrDuplicateName
+		} else {
+			return uuid.UUID{}, err
+		}
+	}
+
+	return id, nil
+}
+
+func (m *CollectionModel) Get(ctx context.Context, id uuid.UUID) (_ models.Collection, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "name", "visibility", "create_time" from "collections" where id = $1`
+
+	var c models.Collection
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&c.ID, &c.UserID, &c.Name, &c.Visibility, &c.CreateTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Collection{}, models.ErrNoRecord
+		} else {
+			return models.Collection{}, err
+		}
+	}
+
+	return c, nil
+}
+
+func (m *CollectionModel) ForUser(ctx context.Context, userID uuid.UUID) (_ []models.Collection, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "name", "visibility", "create_time" from "collections"
+	where user_id = $1 order by lower("name")`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var collections []models.Collection
+
+	for rows.Next() {
+		var c models.Collection
+
+		err = rows.Scan(&c.ID, &c.UserID, &c.Name, &c.Visibility, &c.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Update renames the collection and changes its visibility
+func (m *CollectionModel) Update(ctx context.Context, userID, id uuid.UUID, name string, visibility models.Visibility) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "collections" set "name" = $1, "visibility" = $2 where id = $3 and user_id = $4`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, name, visibility, id, userID)
+	if err != nil {
+		if uniqueViolation(err, "idx_collections_user_id_name") {
+			return models.ErrDuplicateName
+		} else {
+			return err
+		}
+	}
+
+	return expectAffected(result)
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from "collections" where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectAffected(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from "collection_snippets" where collection_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddSnippet puts the snippet at the end of the collection. Adding a snippet that is already there changes nothing,
+// models.ErrNoRecord means that the collection of the user or the snippet visible to the user doesn't exist
+func (m *CollectionModel) AddSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.AddSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "collection_snippets" ("collection_id", "snippet_id", "position")
+	select c.id, s.id, coalesce((select max(position) + 1 from "collection_snippets" where collection_id = c.id), 0)
+	from "collections" c, "snippets" s
+	where c.id = $1 and c.user_id = $2 and s.id = $3 and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = c.user_id)
+	on conflict do nothing`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	err = expectAffected(result)
+	if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	// nothing was inserted, either the snippet is already in the collection or one of them doesn't exist
+	var exists bool
+
+	err = m.DB.QueryRowContext(ctx, `select exists(select true from "collection_snippets" cs join "collections" c on c.id = cs.collection_id
+	where cs.collection_id = $1 and cs.snippet_id = $2 and c.user_id = $3)`, id, snippetID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, userID, id, snippetID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.RemoveSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "collection_snippets" where collection_id = $1 and snippet_id = $2
+	and exists(select true from "collections" where id = $1 and user_id = $3)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, snippetID, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Reorder moves the snippets to the front of the collection in the given order, see models.OrderSnippets
+func (m *CollectionModel) Reorder(ctx context.Context, userID, id uuid.UUID, snippetIDs []uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Reorder")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var owned bool
+
+	err = tx.QueryRowContext(ctx, `select exists(select true from "collections" where id = $1 and user_id = $2)`, id, userID).Scan(&owned)
+	if err != nil {
+		return err
+	}
+
+	if !owned {
+		return models.ErrNoRecord
+	}
+
+	rows, err := tx.QueryContext(ctx, `select "snippet_id" from "collection_snippets" where collection_id = $1 order by position`, id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var current []uuid.UUID
+
+	for rows.Next() {
+		var snippetID uuid.UUID
+
+		err = rows.Scan(&snippetID)
+		if err != nil {
+			return err
+		}
+
+		current = append(current, snippetID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	ordered, err := models.OrderSnippets(current, snippetIDs)
+	if err != nil {
+		return err
+	}
+
+	for position, snippetID := range ordered {
+		_, err = tx.ExecContext(ctx, `update "collection_snippets" set "position" = $1 where collection_id = $2 and snippet_id = $3`, position, id, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Snippets returns the snippets of the collection in their order, the expired ones are left out.
+// The private snippets of the owner stay, the viewer has to check Snippet.VisibleTo. The tag filters the snippets the same way as in the SnippetModel.Latest
+func (m *CollectionModel) Snippets(ctx context.Context, id uuid.UUID, tag string) (_ []models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CollectionModel.Snippets")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "collection_snippets" cs join "snippets" s on s.id = cs.snippet_id
+	where cs.collection_id = $1 and s.expire_time > current_timestamp and ` + snippetTagFilter("$2") + `
+	order by cs.position`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// expectAffected turns a change that didn't touch any row into models.ErrNoRecord
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
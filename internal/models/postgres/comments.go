@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type CommentModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Insert adds the comment to a snippet that is not expired. The parent must be a comment of the same snippet,
+// models.ErrNoRecord otherwise. models.ErrCommentsDisabled means the owner turned the comments off
+func (m *CommentModel) Insert(ctx context.Context, userID, snippetID uuid.UUID, parentID uuid.NullUUID, content string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "comments" ("id", "snippet_id", "user_id", "parent_id", "content")
+	select $1::uuid, s.id, $2::uuid, $3::uuid, $4::text from "snippets" s
+	where s.id = $5 and s.expire_time > current_timestamp and s.comments_enabled
+	and ($3::uuid is null or exists(select true from "comments" p where p.id = $3 and p.snippet_id = s.id))`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, parentID, content, snippetID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = expectAffected(result)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return uuid.UUID{}, err
+	}
+
+	// nothing was inserted, either the comments are turned off or the snippet or the parent doesn't exist
+	var enabled bool
+
+	err = m.DB.QueryRowContext(ctx, `select "comments_enabled" from "snippets" where id = $1 and expire_time > current_timestamp`, snippetID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.UUID{}, models.ErrNoRecord
+		} else {
+			return uuid.UUID{}, err
+		}
+	}
+
+	if !enabled {
+		return uuid.UUID{}, models.ErrCommentsDisabled
+	}
+
+	return uuid.UUID{}, models.ErrNoRecord
+}
+
+func (m *CommentModel) Get(ctx context.Context, id uuid.UUID) (_ models.Comment, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + commentColumns + ` from "comments" c join "users" u on u.id = c.user_id where c.id = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	if len(comments) == 0 {
+		return models.Comment{}, models.ErrNoRecord
+	}
+
+	return comments[0], nil
+}
+
+// ForSnippet returns the comments of the snippet from the oldest, see ThreadComments
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID uuid.UUID) (_ []models.Comment, err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.ForSnippet")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + commentColumns + ` from "comments" c join "users" u on u.id = c.user_id
+	where c.snippet_id = $1 order by c.create_time, c.id`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// Update changes the content of the comment of the user, the deleted comments can't be changed
+func (m *CommentModel) Update(ctx context.Context, userID, id uuid.UUID, content string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "comments" set "content" = $1, "edit_time" = current_timestamp
+	where id = $2 and user_id = $3 and delete_time is null`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, content, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Delete removes the content of the comment, the user is either the author or the owner of the snippet
+func (m *CommentModel) Delete(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "CommentModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "comments" set "content" = '', "delete_time" = current_timestamp
+	where id = $1 and delete_time is null
+	and (user_id = $2 or exists(select true from "snippets" s where s.id = "comments".snippet_id and s.user_id = $2))`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// commentColumns are read by scanComments, c is the comment and u is its author
+const commentColumns = `c."id", c."snippet_id", c."user_id", u."name", c."parent_id", c."content",
+	c.edit_time is not null, c.delete_time is not null, c."create_time"`
+
+func scanComments(rows *sql.Rows) ([]models.Comment, error) {
+	var comments []models.Comment
+
+	for rows.Next() {
+		var c models.Comment
+
+		err := rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.ParentID, &c.Content, &c.Edited, &c.Deleted, &c.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"snippetbox.doichevkostia.dev/internal/models"
+)
+
+// Migrate applies the migrations newer than the schema of the database, each in a transaction of its own, and returns
+// the version it started from. The PostgreSQL schema started with the version 1, there is no migration 0001
+func Migrate(ctx context.Context, db *sql.DB, migrations []models.Migration) (from int, err error) {
+	var versioned bool
+
+	err = db.QueryRowContext(ctx, `select to_regclass('"schema_version"') is not null`).Scan(&versioned)
+	if err != nil {
+		return 0, err
+	}
+
+	if !versioned {
+		return 0, models.ErrNoSchema
+	}
+
+	err = db.QueryRowContext(ctx, `select max("version") from "schema_version"`).Scan(&from)
+	if err != nil {
+		return 0, err
+	}
+
+	pending, err := models.Pending(migrations, from)
+	if err != nil {
+		return from, err
+	}
+
+	for _, m := range pending {
+		err = applyMigration(ctx, db, m)
+		if err != nil {
+			return from, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+
+	return from, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m models.Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, m.SQL)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,41 @@
+// Package postgres implements the model interfaces on top of PostgreSQL, the schema is in sql/schema_postgres.sql.
+// The queries are the same as the SQLite ones except for the placeholders and the time arithmetic
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// DriverName of the database/sql driver registered by the package
+const DriverName = "pgx"
+
+// endSpan finishes the span of a model method. ErrNoRecord is an expected outcome and doesn't fail the span
+func endSpan(span *trace.Span, err error) {
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
+
+// uniqueViolation reports whether the error is a violation of the unique index
+func uniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == index
+}
+
+// queryContext limits a single query to the timeout, zero means no limit
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type RememberTokenModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, userSessionID uuid.UUID, lifetime time.Duration) (_ models.RememberToken, err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	series, err := models.RandomToken(16)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	token, err := models.RandomToken(32)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	stmt := `insert into "remember_tokens" ("series", "user_id", "user_session_id", "token_hash", "create_time", "expire_time")
+	values ($1, $2, $3, $4, current_timestamp, current_timestamp + make_interval(secs => $5))`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, series, userID, userSessionID, models.HashToken(token), lifetime.Seconds())
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	return models.RememberToken{
+		Series:        series,
+		Token:         token,
+		UserID:        userID,
+		UserSessionID: userSessionID,
+	}, nil
+}
+
+// Rotate checks the token of the series and replaces it with a new one in one conditional update, so that only one
+// of the parallel requests with the same cookie wins. The token is returned with the user of the series
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, token string) (_ models.RememberToken, err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Rotate")
+	defer func() { endSpan(span, err) }()
+
+	newToken, err := models.RandomToken(32)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	tokenHash := models.HashToken(token)
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	defer tx.Rollback()
+
+	// the update goes first, the transaction takes the write lock before it reads anything
+	stmt := `update "remember_tokens" set "previous_token_hash" = "token_hash", "token_hash" = $1, "rotate_time" = current_timestamp
+	where series = $2 and token_hash = $3 and expire_time > current_timestamp`
+
+	result, err := tx.ExecContext(ctx, stmt, models.HashToken(newToken), series, tokenHash)
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	stmt = `select "user_id", "user_session_id", "create_time", "expire_time",
+	coalesce("previous_token_hash" = $1 and "rotate_time" > current_timestamp - make_interval(secs => $2), false)
+	from "remember_tokens" where series = $3 and expire_time > current_timestamp`
+
+	rt := models.RememberToken{Series: series}
+	var raced bool
+
+	err = tx.QueryRowContext(ctx, stmt, tokenHash, models.RememberRaceWindow.Seconds(), series).Scan(&rt.UserID, &rt.UserSessionID, &rt.CreateTime, &rt.ExpireTime, &raced)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RememberToken{}, models.ErrNoRecord
+		} else {
+			return models.RememberToken{}, err
+		}
+	}
+
+	if affected == 0 {
+		if raced {
+			return models.RememberToken{}, models.ErrTokenRotated
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from "remember_tokens" where series = $1`, series)
+		if err != nil {
+			return models.RememberToken{}, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return models.RememberToken{}, err
+		}
+
+		return models.RememberToken{UserID: rt.UserID}, models.ErrTokenReused
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.RememberToken{}, err
+	}
+
+	rt.Token = newToken
+
+	return rt, nil
+}
+
+// SetUserSession links the series to the session it started, the session is started after the rotation
+// because the user isn't known before it
+func (m *RememberTokenModel) SetUserSession(ctx context.Context, series string, userSessionID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.SetUserSession")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "remember_tokens" set "user_session_id" = $1 where series = $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userSessionID, series)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where series = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, series)
+	return err
+}
+
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, userSessionID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.DeleteForSession")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where user_session_id = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, userSessionID)
+	return err
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.DeleteAllForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where user_id = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID)
+	return err
+}
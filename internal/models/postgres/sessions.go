@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type UserSessionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *UserSessionModel) Insert(ctx context.Context, userID uuid.UUID, ip, userAgent string, lifetime time.Duration) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "user_sessions" ("id", "user_id", "ip", "user_agent", "create_time", "last_seen_time", "expire_time")
+	values ($1, $2, $3, $4, current_timestamp, current_timestamp, current_timestamp + make_interval(secs => $5))`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id, userID, ip, userAgent, lifetime.Seconds())
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return id, nil
+}
+
+// Get returns the session only while it is still active, revoked and expired sessions result in ErrNoRecord
+func (m *UserSessionModel) Get(ctx context.Context, id uuid.UUID) (_ models.UserSession, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "ip", "user_agent", "create_time", "last_seen_time", "expire_time" from "user_sessions"
+	where expire_time > current_timestamp and id = $1`
+
+	var s models.UserSession
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreateTime, &s.LastSeenTime, &s.ExpireTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.UserSession{}, models.ErrNoRecord
+		} else {
+			return models.UserSession{}, err
+		}
+	}
+
+	return s, nil
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.Touch")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "user_sessions" set "last_seen_time" = current_timestamp where id = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+func (m *UserSessionModel) ForUser(ctx context.Context, userID uuid.UUID) (_ []models.UserSession, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "id", "user_id", "ip", "user_agent", "create_time", "last_seen_time", "expire_time" from "user_sessions"
+	where expire_time > current_timestamp and user_id = $1 order by last_seen_time desc`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sessions []models.UserSession
+
+	for rows.Next() {
+		var s models.UserSession
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreateTime, &s.LastSeenTime, &s.ExpireTime)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete revokes a single session. The user id is required, so that one user can't end the session of another one
+func (m *UserSessionModel) Delete(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "user_sessions" where id = $1 and user_id = $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// DeleteOthers revokes every session of the user but the one with the id, the one the user is logged in with,
+// and returns the number of revoked sessions. The expired ones are left alone, they ended already
+func (m *UserSessionModel) DeleteOthers(ctx context.Context, userID, id uuid.UUID) (n int, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.DeleteOthers")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "user_sessions" where expire_time > current_timestamp and user_id = $1 and id <> $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+// DeleteAllForUser revokes every live session of the user and returns the number of revoked sessions
+func (m *UserSessionModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) (n int, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserSessionModel.DeleteAllForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "user_sessions" where expire_time > current_timestamp and user_id = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
@@ -0,0 +1,293 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"strings"
+	"time"
+)
+
+type SnippetModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *SnippetModel) Insert(ctx context.Context, userID uuid.UUID, title string, content string, format models.Format, visibility models.Visibility, tags []string, files []models.SnippetFile, expires int) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippets" (id, user_id, title, content, format, visibility, create_time, expire_time)
+	values ($1, $2, $3, $4, $5, $6, current_timestamp, current_timestamp + make_interval(days => $7))`
+
+	id := uuid.New()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmt, id, userID, title, content, format, visibility, expires)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	for _, tag := range models.NormalizeTags(tags) {
+		_, err = tx.ExecContext(ctx, `insert into "tags" ("id", "name") values ($1, $2) on conflict ("name") do nothing`, uuid.New(), tag)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into "snippet_tags" ("snippet_id", "tag_id") select $1::uuid, id from "tags" where "name" = $2`, id, tag)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+
+	for position, f := range files {
+		_, err = tx.ExecContext(ctx, `insert into "snippet_files" ("snippet_id", "filename", "language", "content", "position") values ($1, $2, $3, $4, $5)`,
+			id, f.Filename, f.Language, f.Content, position)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return id, nil
+}
+
+func (m *SnippetModel) Get(ctx context.Context, id uuid.UUID) (_ models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."format", s."comments_enabled", s."visibility", s."forked_from",
+	(select count(*) from "snippets" f where f.forked_from = s.id), s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.id = $1`
+
+	var s models.Snippet
+	var tags sql.NullString
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &tags, &s.Stars, &s.Format, &s.CommentsEnabled, &s.Visibility, &s.ForkedFrom, &s.Forks, &s.CreateTime, &s.ExpireTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Snippet{}, models.ErrNoRecord
+		} else {
+			return models.Snippet{}, err
+		}
+	}
+
+	s.Tags = splitTags(tags)
+
+	rows, err := m.DB.QueryContext(ctx, `select "filename", "language", "content" from "snippet_files" where snippet_id = $1 order by position`, id)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+
+	defer rows.Close()
+
+	s.Files, err = scanSnippetFiles(rows)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+
+	return s, nil
+}
+
+// Fork copies the snippet with its tags and files into the account of the user, the copy expires in the given days.
+// Only a snippet that Get returns and that is visible to the user can be forked, ErrNoRecord otherwise.
+// The copy keeps the visibility of the original, so that a fork doesn't list an unlisted or private snippet
+func (m *SnippetModel) Fork(ctx context.Context, userID, id uuid.UUID, expires int) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Fork")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippets" (id, user_id, title, content, format, visibility, forked_from, create_time, expire_time)
+	select $1::uuid, $2::uuid, title, content, format, visibility, id, current_timestamp, current_timestamp + make_interval(days => $3)
+	from "snippets" where id = $4 and expire_time > current_timestamp and (visibility <> 'private' or user_id = $2)`
+
+	newID := uuid.New()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, stmt, newID, userID, expires, id)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = expectAffected(result)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into "snippet_tags" ("snippet_id", "tag_id") select $1::uuid, tag_id from "snippet_tags" where snippet_id = $2`, newID, id)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into "snippet_files" ("snippet_id", "filename", "language", "content", "position")
+	select $1::uuid, "filename", "language", "content", "position" from "snippet_files" where snippet_id = $2`, newID, id)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return newID, nil
+}
+
+// SetCommentsEnabled turns the new comments on or off, only the owner of the snippet can do it
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userID, id uuid.UUID, enabled bool) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.SetCommentsEnabled")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "snippets" set "comments_enabled" = $1 where id = $2 and user_id = $3`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, enabled, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Latest returns the 10 newest public snippets, only the ones with the tag unless it is empty
+func (m *SnippetModel) Latest(ctx context.Context, tag string) (_ []models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Latest")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.visibility = 'public' and ` + snippetTagFilter("$1") + `
+	order by s.create_time desc limit 10`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// ForUser returns a page of the non-expired public snippets of the user, the newest first. Only the ones with the tag
+// unless it is empty
+func (m *SnippetModel) ForUser(ctx context.Context, userID uuid.UUID, tag string, limit, offset int) (_ []models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.visibility = 'public' and s.user_id = $1 and ` + snippetTagFilter("$2") + `
+	order by s.create_time desc, s.id limit $3 offset $4`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, tag, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// snippetTagsColumn aggregates the tags of the snippet s into one column, splitTags reads it
+const snippetTagsColumn = `(select string_agg(t."name", ',') from "snippet_tags" st join "tags" t on t.id = st.tag_id where st.snippet_id = s.id)`
+
+// snippetStarsColumn counts the stars of the snippet s
+const snippetStarsColumn = `(select count(*) from "stars" sr where sr.snippet_id = s.id)`
+
+// snippetTagFilter keeps the snippets s with the tag in the parameter, unless the tag is empty
+func snippetTagFilter(param string) string {
+	return `(` + param + `::text = '' or exists(select true from "snippet_tags" st join "tags" t on t.id = st.tag_id where st.snippet_id = s.id and t."name" = ` + param + `))`
+}
+
+// splitTags reads the tags aggregated into one column by snippetTagsColumn
+func splitTags(tags sql.NullString) []string {
+	if !tags.Valid {
+		return nil
+	}
+
+	return models.NormalizeTags(strings.Split(tags.String, ","))
+}
+
+// scanSnippets reads the rows of s."id", s."user_id", s."title", s."content", snippetTagsColumn, snippetStarsColumn, s."visibility", s."create_time", s."expire_time"
+func scanSnippets(rows *sql.Rows) ([]models.Snippet, error) {
+	var snippets []models.Snippet
+
+	for rows.Next() {
+		var s models.Snippet
+		var tags sql.NullString
+
+		err := rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &tags, &s.Stars, &s.Visibility, &s.CreateTime, &s.ExpireTime)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Tags = splitTags(tags)
+		snippets = append(snippets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// scanSnippetFiles reads the rows of "filename", "language", "content"
+func scanSnippetFiles(rows *sql.Rows) ([]models.SnippetFile, error) {
+	var files []models.SnippetFile
+
+	for rows.Next() {
+		var f models.SnippetFile
+
+		err := rows.Scan(&f.Filename, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type StarModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Toggle stars the snippet or takes the star back, and returns the new state with the number of the stars.
+// Only a snippet that is not expired and that is visible to the user can be starred, models.ErrNoRecord otherwise
+func (m *StarModel) Toggle(ctx context.Context, userID, snippetID uuid.UUID) (_ bool, _ int, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.Toggle")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from "stars" where user_id = $1 and snippet_id = $2`, userID, snippetID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	err = expectAffected(result)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return false, 0, err
+	}
+
+	starred := err != nil // there was no star to take back
+
+	if starred {
+		result, err = tx.ExecContext(ctx, `insert into "stars" ("user_id", "snippet_id")
+		select $1::uuid, s.id from "snippets" s where s.id = $2 and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = $1)
+		on conflict do nothing`, userID, snippetID)
+		if err != nil {
+			return false, 0, err
+		}
+
+		err = expectAffected(result)
+		if errors.Is(err, models.ErrNoRecord) {
+			// either there is no such snippet, or a parallel request of the user starred it first and the conflict did nothing
+			var exists bool
+
+			err = tx.QueryRowContext(ctx, `select exists(select true from "stars" where user_id = $1 and snippet_id = $2)`, userID, snippetID).Scan(&exists)
+			if err != nil {
+				return false, 0, err
+			}
+
+			if !exists {
+				return false, 0, models.ErrNoRecord
+			}
+		} else if err != nil {
+			return false, 0, err
+		}
+	}
+
+	var stars int
+
+	err = tx.QueryRowContext(ctx, `select count(*) from "stars" where snippet_id = $1`, snippetID).Scan(&stars)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return starred, stars, tx.Commit()
+}
+
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID uuid.UUID) (_ bool, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.Starred")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select exists(select true from "stars" where user_id = $1 and snippet_id = $2)`
+
+	var starred bool
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, snippetID).Scan(&starred)
+	if err != nil {
+		return false, err
+	}
+
+	return starred, nil
+}
+
+// ForUser returns the snippets the user starred and can still see, the latest star first. Only the ones with the tag
+// unless it is empty
+func (m *StarModel) ForUser(ctx context.Context, userID uuid.UUID, tag string) (_ []models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "stars" my join "snippets" s on s.id = my.snippet_id
+	where my.user_id = $1 and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = my.user_id) and ` + snippetTagFilter("$2") + `
+	order by my.create_time desc`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// MostStarred returns the 10 public snippets with the most stars given in the last days, the newer snippet wins a tie.
+// The Stars of the snippets are all of their stars
+func (m *StarModel) MostStarred(ctx context.Context, days int) (_ []models.Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.MostStarred")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s join (
+		select "snippet_id", count(*) as "recent" from "stars" where create_time > current_timestamp - make_interval(days => $1) group by "snippet_id"
+	) r on r.snippet_id = s.id
+	where s.expire_time > current_timestamp and s.visibility = 'public'
+	order by r.recent desc, s.create_time desc limit 10`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, days)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"strings"
+	"time"
+)
+
+type TagModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Search returns the most used tags of the public snippets that start with the prefix, for the autocomplete
+func (m *TagModel) Search(ctx context.Context, prefix string, limit int) (_ []models.Tag, err error) {
+	ctx, span := m.Tracer.Start(ctx, "TagModel.Search")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select t."name", count(*) from "tags" t
+	join "snippet_tags" st on st.tag_id = t.id
+	join "snippets" s on s.id = st.snippet_id
+	where t."name" like $1 escape '\' and s.expire_time > current_timestamp and s.visibility = 'public'
+	group by t."name" order by count(*) desc, t."name" limit $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, likePrefix(strings.ToLower(strings.TrimSpace(prefix))), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tags []models.Tag
+
+	for rows.Next() {
+		var t models.Tag
+
+		err = rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// likePrefix is the pattern of a like query for the values that start with the prefix, "_" is a valid character of a tag
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
@@ -0,0 +1,311 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+type UserModel struct {
+	Hasher       password.Hasher
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *UserModel) Insert(ctx context.Context, name, email, plaintext string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	hashedPassword, err := m.hash(ctx, plaintext)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	stmt := `insert into "users" ("id", "name", "email", "hashed_password")
+	values ($1, $2, $3, $4)`
+
+	id := uuid.New()
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// the unique indexes decide, a check before the insert would race with the parallel signups
+	_, err = m.DB.ExecContext(ctx, stmt, id, name, email, hashedPassword)
+	if err != nil {
+		if uniqueViolation(err, "idx_users_email") {
+			return uuid.UUID{}, models.ErrDuplicateEmail
+		} else if uniqueViolation(err, "idx_users_name") {
+			return uuid.UUID{}, models.ErrDuplicateName
+		} else {
+			return uuid.UUID{}, err
+		}
+	}
+
+	return id, nil
+}
+
+func (m *UserModel) Authenticate(ctx context.Context, email, plaintext string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Authenticate")
+	defer func() { endSpan(span, err) }()
+
+	usr, err := m.ByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return uuid.UUID{}, models.ErrInvalidCredentials
+		}
+
+		// a timeout or a failed query isn't a wrong password
+		return uuid.UUID{}, err
+	}
+
+	hash := string(usr.HashedPassword)
+
+	ok, err := m.verify(ctx, hash, plaintext)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if !ok {
+		return uuid.UUID{}, models.ErrInvalidCredentials
+	}
+
+	// the password is known only now, it is the chance to upgrade the hash.
+	// A failed upgrade doesn't fail the login, the error is on the span and the next login tries again
+	if m.Hasher.NeedsRehash(hash) {
+		m.rehash(ctx, usr.ID, hash, plaintext)
+	}
+
+	return usr.ID, nil
+}
+
+// rehash replaces the hash with the one of the current hasher, unless the password was changed in the meantime
+func (m *UserModel) rehash(ctx context.Context, id uuid.UUID, oldHash, plaintext string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.rehash")
+	defer func() { endSpan(span, err) }()
+
+	newHash, err := m.hash(ctx, plaintext)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update "users" set "hashed_password" = $1 where "id" = $2 and "hashed_password" = $3`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, newHash, id, oldHash)
+	return err
+}
+
+func (m *UserModel) Exists(ctx context.Context, id uuid.UUID) (_ bool, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Exists")
+	defer func() { endSpan(span, err) }()
+
+	var exists bool
+	stmt := `select exists(select true from "users" where id = $1)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
+	return exists, err
+}
+
+func (m *UserModel) Get(ctx context.Context, id uuid.UUID) (_ models.User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where u."id" = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, id))
+}
+
+func (m *UserModel) ByEmail(ctx context.Context, email string) (_ models.User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.ByEmail")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where lower(u."email") = lower($1)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, email))
+}
+
+// ByName finds the user by the name regardless of the case, the same as the unique index
+func (m *UserModel) ByName(ctx context.Context, name string) (_ models.User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.ByName")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where lower(u."name") = lower($1)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, name))
+}
+
+// userColumns are the columns of the user u read by scanUser
+const userColumns = `u."id", u."name", u."email", u."hashed_password", u."admin", u."default_expires", u."bio", u."suspended",
+	exists(select true from "user_avatars" a where a.user_id = u.id), u."create_time"`
+
+func scanUser(row *sql.Row) (models.User, error) {
+	var u models.User
+
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.HashedPassword, &u.Admin, &u.DefaultExpires, &u.Bio, &u.Suspended, &u.HasAvatar, &u.CreateTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, models.ErrNoRecord
+		} else {
+			return models.User{}, err
+		}
+	}
+
+	return u, nil
+}
+
+// UpdatePassword checks the current password before replacing it, so that a stolen session isn't enough to take over the account
+func (m *UserModel) UpdatePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdatePassword")
+	defer func() { endSpan(span, err) }()
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ok, err := m.verify(ctx, string(usr.HashedPassword), currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return models.ErrInvalidCredentials
+	}
+
+	newHash, err := m.hash(ctx, newPassword)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update "users" set "hashed_password" = $1 where "id" = $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, newHash, id)
+	return err
+}
+
+// UpdateDefaultExpires changes the expiry of the forks and the preselected one of the new snippets
+func (m *UserModel) UpdateDefaultExpires(ctx context.Context, id uuid.UUID, expires int) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdateDefaultExpires")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "users" set "default_expires" = $1 where "id" = $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, expires, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (m *UserModel) UpdateBio(ctx context.Context, id uuid.UUID, bio string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdateBio")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "users" set "bio" = $1 where "id" = $2`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, bio, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (m *UserModel) Avatar(ctx context.Context, id uuid.UUID) (_ models.Avatar, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Avatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "content_type", "data", "update_time" from "user_avatars" where "user_id" = $1`
+
+	var a models.Avatar
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&a.ContentType, &a.Data, &a.UpdateTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Avatar{}, models.ErrNoRecord
+		} else {
+			return models.Avatar{}, err
+		}
+	}
+
+	return a, nil
+}
+
+// SetAvatar adds the avatar of the user or replaces the existing one
+func (m *UserModel) SetAvatar(ctx context.Context, id uuid.UUID, contentType string, data []byte) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.SetAvatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "user_avatars" ("user_id", "content_type", "data", "update_time")
+	values ($1, $2, $3, current_timestamp)
+	on conflict ("user_id") do update set "content_type" = excluded."content_type", "data" = excluded."data", "update_time" = excluded."update_time"`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id, contentType, data)
+	return err
+}
+
+// DeleteAvatar removes the avatar of the user, without an avatar it does nothing
+func (m *UserModel) DeleteAvatar(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.DeleteAvatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "user_avatars" where "user_id" = $1`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+func (m *UserModel) hash(ctx context.Context, plaintext string) (string, error) {
+	_, span := m.Tracer.Start(ctx, "Hasher.Hash")
+	hash, err := m.Hasher.Hash(plaintext)
+	endSpan(span, err)
+
+	return hash, err
+}
+
+func (m *UserModel) verify(ctx context.Context, hash, plaintext string) (bool, error) {
+	_, span := m.Tracer.Start(ctx, "password.Verify")
+	ok, err := password.Verify(hash, plaintext)
+	endSpan(span, err)
+
+	return ok, err
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/models"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// firstDay is the first of the last days in models.DayFormat
+func firstDay(days int) string {
+	return time.Now().UTC().AddDate(0, 0, 1-days).Format(models.DayFormat)
+}
+
+type ViewModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Insert counts the views, the repeated views of a visitor on the same day and the views of the snippets
+// that don't exist are ignored
+func (m *ViewModel) Insert(ctx context.Context, views []models.View) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "ViewModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippet_views" ("snippet_id", "day", "visitor")
+	select s.id, $1::date, $2::text from "snippets" s where s.id = $3
+	on conflict do nothing`
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, v := range views {
+		_, err = tx.ExecContext(ctx, stmt, v.Day(), v.Visitor, v.SnippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Stats returns the views of the snippet with the views of the last days
+func (m *ViewModel) Stats(ctx context.Context, snippetID uuid.UUID, days int) (_ models.ViewStats, err error) {
+	ctx, span := m.Tracer.Start(ctx, "ViewModel.Stats")
+	defer func() { endSpan(span, err) }()
+
+	var stats models.ViewStats
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, `select count(*) from "snippet_views" where snippet_id = $1`, snippetID).Scan(&stats.Total)
+	if err != nil {
+		return models.ViewStats{}, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select "day", count(*) from "snippet_views" where snippet_id = $1 and day >= $2::date group by "day"`,
+		snippetID, firstDay(days))
+	if err != nil {
+		return models.ViewStats{}, err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[string]int)
+
+	for rows.Next() {
+		var day time.Time
+		var views int
+
+		err = rows.Scan(&day, &views)
+		if err != nil {
+			return models.ViewStats{}, err
+		}
+
+		counts[day.Format(models.DayFormat)] = views
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.ViewStats{}, err
+	}
+
+	stats.Daily = models.DailyStats(counts, days)
+
+	return stats, nil
+}
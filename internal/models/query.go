@@ -0,0 +1,16 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// queryContext limits a single query to the timeout, zero means no limit. The drivers abort the query
+// when the deadline passes and return context.DeadlineExceeded
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
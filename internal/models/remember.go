@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// RememberTokenModelInterface implements the persistent login. The series identifies a device and stays the same,
+// the token is replaced on every use. Only a hash of the token is stored.
+//
+// If a token of an existing series doesn't match, the old token was used by someone else after it was rotated.
+// In that case the series is revoked and ErrTokenReused is returned together with the id of the affected user.
+// The exception is the token replaced within RememberRaceWindow: the parallel requests of one browser send the same
+// cookie, the one that lost the race gets ErrTokenRotated and the series stays
+type RememberTokenModelInterface interface {
+	Insert(ctx context.Context, userID, userSessionID uuid.UUID, lifetime time.Duration) (RememberToken, error)
+	Rotate(ctx context.Context, series, token string) (RememberToken, error)
+	SetUserSession(ctx context.Context, series string, userSessionID uuid.UUID) error
+	Delete(ctx context.Context, series string) error
+	DeleteForSession(ctx context.Context, userSessionID uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// RememberRaceWindow is how long the previous token of a series is a lost race and not a reuse, the cookie of the request
+// that won is on its way to the browser
+const RememberRaceWindow = 30 * time.Second
+
+type RememberToken struct {
+	Series        string
+	Token         string // plain text value, it is known only right after the creation or rotation
+	UserID        uuid.UUID
+	UserSessionID uuid.UUID
+	CreateTime    time.Time
+	ExpireTime    time.Time
+}
+
+type RememberTokenModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, userSessionID uuid.UUID, lifetime time.Duration) (_ RememberToken, err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	series, err := RandomToken(16)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	stmt := `insert into "remember_tokens" ("series", "user_id", "user_session_id", "token_hash", "create_time", "expire_time")
+	values (?, ?, ?, ?, current_timestamp, datetime(current_timestamp, ?))`
+
+	expiration := fmt.Sprintf("+%d seconds", int(lifetime.Seconds()))
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, series, userID, userSessionID, HashToken(token), expiration)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	return RememberToken{
+		Series:        series,
+		Token:         token,
+		UserID:        userID,
+		UserSessionID: userSessionID,
+	}, nil
+}
+
+// Rotate checks the token of the series and replaces it with a new one in one conditional update, so that only one
+// of the parallel requests with the same cookie wins. The token is returned with the user of the series
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, token string) (_ RememberToken, err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Rotate")
+	defer func() { endSpan(span, err) }()
+
+	newToken, err := RandomToken(32)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	tokenHash := HashToken(token)
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	defer tx.Rollback()
+
+	// the update goes first, the transaction takes the write lock before it reads anything
+	stmt := `update "remember_tokens" set "previous_token_hash" = "token_hash", "token_hash" = ?, "rotate_time" = current_timestamp
+	where series = ? and token_hash = ? and expire_time > current_timestamp`
+
+	result, err := tx.ExecContext(ctx, stmt, HashToken(newToken), series, tokenHash)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	stmt = `select "user_id", "user_session_id", "create_time", "expire_time",
+	coalesce("previous_token_hash" = ? and "rotate_time" > datetime(current_timestamp, ?), false)
+	from "remember_tokens" where series = ? and expire_time > current_timestamp`
+
+	rt := RememberToken{Series: series}
+	var raced bool
+
+	window := fmt.Sprintf("-%d seconds", int(RememberRaceWindow.Seconds()))
+
+	err = tx.QueryRowContext(ctx, stmt, tokenHash, window, series).Scan(&rt.UserID, &rt.UserSessionID, &rt.CreateTime, &rt.ExpireTime, &raced)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RememberToken{}, ErrNoRecord
+		} else {
+			return RememberToken{}, err
+		}
+	}
+
+	if affected == 0 {
+		if raced {
+			return RememberToken{}, ErrTokenRotated
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from "remember_tokens" where series = ?`, series)
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		return RememberToken{UserID: rt.UserID}, ErrTokenReused
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	rt.Token = newToken
+
+	return rt, nil
+}
+
+// SetUserSession links the series to the session it started, the session is started after the rotation
+// because the user isn't known before it
+func (m *RememberTokenModel) SetUserSession(ctx context.Context, series string, userSessionID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.SetUserSession")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "remember_tokens" set "user_session_id" = ? where series = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userSessionID, series)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where series = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, series)
+	return err
+}
+
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, userSessionID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.DeleteForSession")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where user_session_id = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, userSessionID)
+	return err
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "RememberTokenModel.DeleteAllForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "remember_tokens" where user_id = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// RandomToken returns size random bytes encoded with the URL-safe base64
+func RandomToken(size int) (string, error) {
+	b := make([]byte, size)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is how the remember tokens are stored, a hex encoded SHA-256
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,38 +1,125 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
 	"time"
 )
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (uuid.UUID, error)
-	Get(id uuid.UUID) (Snippet, error)
-	Latest() ([]Snippet, error)
+	Insert(ctx context.Context, userID uuid.UUID, title string, content string, format Format, visibility Visibility, tags []string, files []SnippetFile, expires int) (uuid.UUID, error)
+	Get(ctx context.Context, id uuid.UUID) (Snippet, error)
+	Fork(ctx context.Context, userID, id uuid.UUID, expires int) (uuid.UUID, error)
+	SetCommentsEnabled(ctx context.Context, userID, id uuid.UUID, enabled bool) error
+	Latest(ctx context.Context, tag string) ([]Snippet, error)
+	ForUser(ctx context.Context, userID uuid.UUID, tag string, limit, offset int) ([]Snippet, error)
 }
 
 type Snippet struct {
-	ID         uuid.UUID
-	Title      string
-	Content    string
-	CreateTime time.Time
-	ExpireTime time.Time
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	Title           string
+	Content         string
+	Format          Format        // only Get loads it
+	Tags            []string      // normalized, see NormalizeTags
+	Files           []SnippetFile // in their order, only Get loads them
+	ForkedFrom      uuid.NullUUID // the original of a fork, only Get loads it
+	Forks           int           // the number of the forks, only Get counts them
+	Stars           int           // the number of the stars
+	CommentsEnabled bool          // only Get loads it
+	Visibility      Visibility
+	CreateTime      time.Time
+	ExpireTime      time.Time
+}
+
+// VisibleTo reports whether the user can see the snippet, the zero id is an anonymous user.
+// The same as for the collections, only the public snippets are listed
+func (s Snippet) VisibleTo(userID uuid.UUID) bool {
+	return s.Visibility != VisibilityPrivate || s.UserID == userID
+}
+
+// ListedTo reports whether the snippet may appear in a listing shown to the user. The unlisted snippets
+// are listed only to their owner, the others need the link
+func (s Snippet) ListedTo(userID uuid.UUID) bool {
+	return s.Visibility == VisibilityPublic || s.UserID == userID
+}
+
+// Format decides how the content of a snippet is shown
+type Format string
+
+const (
+	FormatPlain    Format = "plain"
+	FormatMarkdown Format = "markdown" // rendered into sanitized HTML
+	FormatCode     Format = "code"     // highlighted
+)
+
+// Formats are the valid formats in the order of the form
+var Formats = []Format{FormatPlain, FormatMarkdown, FormatCode}
+
+// SnippetFile is one file of a multi-file snippet, the filenames are unique within the snippet
+type SnippetFile struct {
+	Filename string
+	Language string
+	Content  string
 }
 
 type SnippetModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
 }
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (uuid.UUID, error) {
-	stmt := `insert into "snippets" (id, title, content, create_time, expire_time)
-	values (?, ?, ?, current_timestamp, datetime(current_timestamp, ?))`
+func (m *SnippetModel) Insert(ctx context.Context, userID uuid.UUID, title string, content string, format Format, visibility Visibility, tags []string, files []SnippetFile, expires int) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippets" (id, user_id, title, content, format, visibility, create_time, expire_time)
+	values (?, ?, ?, ?, ?, ?, current_timestamp, datetime(current_timestamp, ?))`
 
 	id := uuid.New()
 	expiration := fmt.Sprintf("+%d days", expires)
-	_, err := m.DB.Exec(stmt, id, title, content, expiration)
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmt, id, userID, title, content, format, visibility, expiration)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	for _, tag := range NormalizeTags(tags) {
+		_, err = tx.ExecContext(ctx, `insert into "tags" ("id", "name") values (?, ?) on conflict ("name") do nothing`, uuid.New(), tag)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into "snippet_tags" ("snippet_id", "tag_id") select ?, id from "tags" where "name" = ?`, id, tag)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+
+	for position, f := range files {
+		_, err = tx.ExecContext(ctx, `insert into "snippet_files" ("snippet_id", "filename", "language", "content", "position") values (?, ?, ?, ?, ?)`,
+			id, f.Filename, f.Language, f.Content, position)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return uuid.UUID{}, err
 	}
@@ -40,14 +127,21 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (uuid.U
 	return id, nil
 }
 
-func (m *SnippetModel) Get(id uuid.UUID) (Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id uuid.UUID) (_ Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Get")
+	defer func() { endSpan(span, err) }()
 
-	stmt := `select "id", "title", "content", "create_time", "expire_time" from "snippets"
-	where expire_time > current_timestamp and id = ?`
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."format", s."comments_enabled", s."visibility", s."forked_from",
+	(select count(*) from "snippets" f where f.forked_from = s.id), s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.id = ?`
 
 	var s Snippet
+	var tags sql.NullString
 
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.CreateTime, &s.ExpireTime)
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &tags, &s.Stars, &s.Format, &s.CommentsEnabled, &s.Visibility, &s.ForkedFrom, &s.Forks, &s.CreateTime, &s.ExpireTime)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Snippet{}, ErrNoRecord
@@ -56,36 +150,191 @@ func (m *SnippetModel) Get(id uuid.UUID) (Snippet, error) {
 		}
 	}
 
+	s.Tags = splitTags(tags)
+
+	rows, err := m.DB.QueryContext(ctx, `select "filename", "language", "content" from "snippet_files" where snippet_id = ? order by position`, id)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	defer rows.Close()
+
+	s.Files, err = scanSnippetFiles(rows)
+	if err != nil {
+		return Snippet{}, err
+	}
+
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]Snippet, error) {
-	stmt := `select "id", "title", "content", "create_time", "expire_time" from "snippets"
-	where expire_time > current_timestamp order by create_time desc limit 10`
+// Fork copies the snippet with its tags and files into the account of the user, the copy expires in the given days.
+// Only a snippet that Get returns and that is visible to the user can be forked, ErrNoRecord otherwise.
+// The copy keeps the visibility of the original, so that a fork doesn't list an unlisted or private snippet
+func (m *SnippetModel) Fork(ctx context.Context, userID, id uuid.UUID, expires int) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Fork")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippets" (id, user_id, title, content, format, visibility, forked_from, create_time, expire_time)
+	select ?, ?, title, content, format, visibility, id, current_timestamp, datetime(current_timestamp, ?)
+	from "snippets" where id = ? and expire_time > current_timestamp and (visibility <> 'private' or user_id = ?)`
 
-	rows, err := m.DB.Query(stmt)
+	newID := uuid.New()
+	expiration := fmt.Sprintf("+%d days", expires)
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, stmt, newID, userID, expiration, id, userID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = expectAffected(result)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into "snippet_tags" ("snippet_id", "tag_id") select ?, tag_id from "snippet_tags" where snippet_id = ?`, newID, id)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into "snippet_files" ("snippet_id", "filename", "language", "content", "position")
+	select ?, "filename", "language", "content", "position" from "snippet_files" where snippet_id = ?`, newID, id)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return newID, nil
+}
+
+// SetCommentsEnabled turns the new comments on or off, only the owner of the snippet can do it
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userID, id uuid.UUID, enabled bool) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.SetCommentsEnabled")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "snippets" set "comments_enabled" = ? where id = ? and user_id = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, enabled, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// Latest returns the 10 newest public snippets, only the ones with the tag unless it is empty
+func (m *SnippetModel) Latest(ctx context.Context, tag string) (_ []Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.Latest")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.visibility = 'public' and ` + snippetTagFilter + `
+	order by s.create_time desc limit 10`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, tag, tag)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
+	return scanSnippets(rows)
+}
+
+// ForUser returns a page of the non-expired public snippets of the user, the newest first. Only the ones with the tag
+// unless it is empty
+func (m *SnippetModel) ForUser(ctx context.Context, userID uuid.UUID, tag string, limit, offset int) (_ []Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "SnippetModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s where s.expire_time > current_timestamp and s.visibility = 'public' and s.user_id = ? and ` + snippetTagFilter + `
+	order by s.create_time desc, s.id limit ? offset ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, tag, tag, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// snippetTagsColumn aggregates the tags of the snippet s into one column, splitTags reads it
+const snippetTagsColumn = `(select group_concat(t."name") from "snippet_tags" st join "tags" t on t.id = st.tag_id where st.snippet_id = s.id)`
+
+// snippetStarsColumn counts the stars of the snippet s
+const snippetStarsColumn = `(select count(*) from "stars" sr where sr.snippet_id = s.id)`
+
+// snippetTagFilter keeps the snippets s with the tag, unless the tag is empty. It takes the tag twice
+const snippetTagFilter = `(? = '' or exists(select true from "snippet_tags" st join "tags" t on t.id = st.tag_id where st.snippet_id = s.id and t."name" = ?))`
+
+// scanSnippets reads the rows of s."id", s."user_id", s."title", s."content", snippetTagsColumn, snippetStarsColumn, s."visibility", s."create_time", s."expire_time"
+func scanSnippets(rows *sql.Rows) ([]Snippet, error) {
 	var snippets []Snippet
 
 	for rows.Next() {
 		var s Snippet
+		var tags sql.NullString
 
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.CreateTime, &s.ExpireTime)
+		err := rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &tags, &s.Stars, &s.Visibility, &s.CreateTime, &s.ExpireTime)
 		if err != nil {
 			return nil, err
 		}
 
+		s.Tags = splitTags(tags)
 		snippets = append(snippets, s)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
 	return snippets, nil
 }
+
+// scanSnippetFiles reads the rows of "filename", "language", "content"
+func scanSnippetFiles(rows *sql.Rows) ([]SnippetFile, error) {
+	var files []SnippetFile
+
+	for rows.Next() {
+		var f SnippetFile
+
+		err := rows.Scan(&f.Filename, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
@@ -0,0 +1,11 @@
+//go:build cgo
+
+package models_test
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sqliteDrivers = append(sqliteDrivers, "sqlite3")
+}
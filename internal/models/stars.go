@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// StarModelInterface stores the snippets the users starred, a user stars a snippet at most once.
+// The stars of the expired snippets are kept but left out the same way as the snippets themselves
+type StarModelInterface interface {
+	Toggle(ctx context.Context, userID, snippetID uuid.UUID) (starred bool, stars int, err error)
+	Starred(ctx context.Context, userID, snippetID uuid.UUID) (bool, error)
+	ForUser(ctx context.Context, userID uuid.UUID, tag string) ([]Snippet, error)
+	MostStarred(ctx context.Context, days int) ([]Snippet, error)
+}
+
+type StarModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Toggle stars the snippet or takes the star back, and returns the new state with the number of the stars.
+// Only a snippet that is not expired and that is visible to the user can be starred, ErrNoRecord otherwise
+func (m *StarModel) Toggle(ctx context.Context, userID, snippetID uuid.UUID) (_ bool, _ int, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.Toggle")
+	defer func() { endSpan(span, err) }()
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from "stars" where user_id = ? and snippet_id = ?`, userID, snippetID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	err = expectAffected(result)
+	if err != nil && !errors.Is(err, ErrNoRecord) {
+		return false, 0, err
+	}
+
+	starred := err != nil // there was no star to take back
+
+	if starred {
+		result, err = tx.ExecContext(ctx, `insert into "stars" ("user_id", "snippet_id")
+		select ?, s.id from "snippets" s where s.id = ? and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = ?)
+		on conflict do nothing`, userID, snippetID, userID)
+		if err != nil {
+			return false, 0, err
+		}
+
+		err = expectAffected(result)
+		if errors.Is(err, ErrNoRecord) {
+			// either there is no such snippet, or a parallel request of the user starred it first and the conflict did nothing
+			var exists bool
+
+			err = tx.QueryRowContext(ctx, `select exists(select true from "stars" where user_id = ? and snippet_id = ?)`, userID, snippetID).Scan(&exists)
+			if err != nil {
+				return false, 0, err
+			}
+
+			if !exists {
+				return false, 0, ErrNoRecord
+			}
+		} else if err != nil {
+			return false, 0, err
+		}
+	}
+
+	var stars int
+
+	err = tx.QueryRowContext(ctx, `select count(*) from "stars" where snippet_id = ?`, snippetID).Scan(&stars)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return starred, stars, tx.Commit()
+}
+
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID uuid.UUID) (_ bool, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.Starred")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select exists(select true from "stars" where user_id = ? and snippet_id = ?)`
+
+	var starred bool
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, snippetID).Scan(&starred)
+	if err != nil {
+		return false, err
+	}
+
+	return starred, nil
+}
+
+// ForUser returns the snippets the user starred and can still see, the latest star first. Only the ones with the tag
+// unless it is empty
+func (m *StarModel) ForUser(ctx context.Context, userID uuid.UUID, tag string) (_ []Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "stars" my join "snippets" s on s.id = my.snippet_id
+	where my.user_id = ? and s.expire_time > current_timestamp and (s.visibility <> 'private' or s.user_id = my.user_id) and ` + snippetTagFilter + `
+	order by my.create_time desc`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, tag, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// MostStarred returns the 10 public snippets with the most stars given in the last days, the newer snippet wins a tie.
+// The Stars of the snippets are all of their stars
+func (m *StarModel) MostStarred(ctx context.Context, days int) (_ []Snippet, err error) {
+	ctx, span := m.Tracer.Start(ctx, "StarModel.MostStarred")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select s."id", s."user_id", s."title", s."content", ` + snippetTagsColumn + `, ` + snippetStarsColumn + `, s."visibility", s."create_time", s."expire_time"
+	from "snippets" s join (
+		select "snippet_id", count(*) as "recent" from "stars" where create_time > datetime(current_timestamp, ?) group by "snippet_id"
+	) r on r.snippet_id = s.id
+	where s.expire_time > current_timestamp and s.visibility = 'public'
+	order by r.recent desc, s.create_time desc limit 10`
+
+	since := fmt.Sprintf("-%d days", days)
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, since)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"strings"
+	"time"
+)
+
+// TagModelInterface looks up the tags of the snippets, the tags themselves are set with the snippet
+type TagModelInterface interface {
+	Search(ctx context.Context, prefix string, limit int) ([]Tag, error)
+}
+
+type Tag struct {
+	Name  string
+	Count int // of the snippets that are not expired
+}
+
+// NormalizeTags lowercases and trims the tags, drops the empty ones and the duplicates, and sorts the rest.
+// Every model stores the tags normalized, so that "Go" and "go" are the same tag
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized)
+}
+
+// splitTags reads the tags aggregated into one column by snippetTagsColumn
+func splitTags(tags sql.NullString) []string {
+	if !tags.Valid {
+		return nil
+	}
+
+	return NormalizeTags(strings.Split(tags.String, ","))
+}
+
+// likePrefix is the pattern of a like query for the values that start with the prefix, "_" is a valid character of a tag
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+type TagModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Search returns the most used tags of the public snippets that start with the prefix, for the autocomplete
+func (m *TagModel) Search(ctx context.Context, prefix string, limit int) (_ []Tag, err error) {
+	ctx, span := m.Tracer.Start(ctx, "TagModel.Search")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select t."name", count(*) from "tags" t
+	join "snippet_tags" st on st.tag_id = t.id
+	join "snippets" s on s.id = st.snippet_id
+	where t."name" like ? escape '\' and s.expire_time > current_timestamp and s.visibility = 'public'
+	group by t."name" order by count(*) desc, t."name" limit ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, likePrefix(strings.ToLower(strings.TrimSpace(prefix))), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tags []Tag
+
+	for rows.Next() {
+		var t Tag
+
+		err = rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
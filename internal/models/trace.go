@@ -0,0 +1,15 @@
+package models
+
+import (
+	"errors"
+	"snippetbox.doichevkostia.dev/internal/trace"
+)
+
+// endSpan finishes the span of a model method. ErrNoRecord is an expected outcome and doesn't fail the span
+func endSpan(span *trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNoRecord) {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
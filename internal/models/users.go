@@ -1,44 +1,64 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"snippetbox.doichevkostia.dev/internal/password"
+	"snippetbox.doichevkostia.dev/internal/trace"
 	"time"
 )
 
 type UserModelInterface interface {
-	Insert(name, email, password string) (uuid.UUID, error)
-	Authenticate(email, password string) (uuid.UUID, error)
-	Exists(id uuid.UUID) (bool, error)
+	Insert(ctx context.Context, name, email, password string) (uuid.UUID, error)
+	Authenticate(ctx context.Context, email, password string) (uuid.UUID, error)
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+	Get(ctx context.Context, id uuid.UUID) (User, error)
+	UpdatePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) error
+	UpdateDefaultExpires(ctx context.Context, id uuid.UUID, expires int) error
+	ByName(ctx context.Context, name string) (User, error)
+	UpdateBio(ctx context.Context, id uuid.UUID, bio string) error
+	Avatar(ctx context.Context, id uuid.UUID) (Avatar, error)
+	SetAvatar(ctx context.Context, id uuid.UUID, contentType string, data []byte) error
+	DeleteAvatar(ctx context.Context, id uuid.UUID) error
 }
 
+// DefaultExpires is the default expiry of a new user in days, the same as the default of the column
+const DefaultExpires = 365
+
 type User struct {
 	ID             uuid.UUID
 	Name           string
 	Email          string
 	HashedPassword []byte
+	Admin          bool
+	DefaultExpires int // in days
+	Bio            string
+	Suspended      bool // the profile is hidden
+	HasAvatar      bool
 	CreateTime     time.Time
 }
 
+// Avatar is the image of the profile of a user
+type Avatar struct {
+	ContentType string
+	Data        []byte
+	UpdateTime  time.Time
+}
+
 type UserModel struct {
-	PasswordCost int
+	Hasher       password.Hasher
 	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
 }
 
-func (m *UserModel) Insert(name, email, password string) (uuid.UUID, error) {
-	// TODO: lock the table to prevent race conditions
-	exists, err := m.EmailExists(email)
-	if err != nil {
-		return uuid.UUID{}, err
-	}
-
-	if exists {
-		return uuid.UUID{}, ErrDuplicateEmail
-	}
+func (m *UserModel) Insert(ctx context.Context, name, email, plaintext string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Insert")
+	defer func() { endSpan(span, err) }()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), m.PasswordCost)
+	hashedPassword, err := m.hash(ctx, plaintext)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
@@ -47,46 +67,137 @@ func (m *UserModel) Insert(name, email, password string) (uuid.UUID, error) {
 	values (?, ?, ?, ?)`
 
 	id := uuid.New()
-	_, err = m.DB.Exec(stmt, id, name, email, string(hashedPassword))
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// the unique indexes decide, a check before the insert would race with the parallel signups
+	_, err = m.DB.ExecContext(ctx, stmt, id, name, email, hashedPassword)
 	if err != nil {
-		return uuid.UUID{}, err
+		if uniqueViolation(err, "idx_users_email") {
+			return uuid.UUID{}, ErrDuplicateEmail
+		} else if uniqueViolation(err, "idx_users_name") {
+			return uuid.UUID{}, ErrDuplicateName
+		} else {
+			return uuid.UUID{}, err
+		}
 	}
 
 	return id, nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (uuid.UUID, error) {
-	usr, err := m.ByEmail(email)
+func (m *UserModel) Authenticate(ctx context.Context, email, plaintext string) (_ uuid.UUID, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Authenticate")
+	defer func() { endSpan(span, err) }()
 
+	usr, err := m.ByEmail(ctx, email)
 	if err != nil {
-		return uuid.UUID{}, ErrInvalidCredentials
+		if errors.Is(err, ErrNoRecord) {
+			return uuid.UUID{}, ErrInvalidCredentials
+		}
+
+		// a timeout or a failed query isn't a wrong password
+		return uuid.UUID{}, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(usr.HashedPassword, []byte(password))
+	hash := string(usr.HashedPassword)
+
+	ok, err := m.verify(ctx, hash, plaintext)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return uuid.UUID{}, ErrInvalidCredentials
-		} else {
-			return uuid.UUID{}, err
-		}
+		return uuid.UUID{}, err
+	}
+
+	if !ok {
+		return uuid.UUID{}, ErrInvalidCredentials
+	}
+
+	// the password is known only now, it is the chance to upgrade the hash.
+	// A failed upgrade doesn't fail the login, the error is on the span and the next login tries again
+	if m.Hasher.NeedsRehash(hash) {
+		m.rehash(ctx, usr.ID, hash, plaintext)
 	}
 
 	return usr.ID, nil
 }
 
-func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
+// rehash replaces the hash with the one of the current hasher, unless the password was changed in the meantime
+func (m *UserModel) rehash(ctx context.Context, id uuid.UUID, oldHash, plaintext string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.rehash")
+	defer func() { endSpan(span, err) }()
+
+	newHash, err := m.hash(ctx, plaintext)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update "users" set "hashed_password" = ? where "id" = ? and "hashed_password" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, newHash, id, oldHash)
+	return err
+}
+
+func (m *UserModel) Exists(ctx context.Context, id uuid.UUID) (_ bool, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Exists")
+	defer func() { endSpan(span, err) }()
+
 	var exists bool
 	stmt := `select exists(select true from "users" where id = ?)`
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
 
-func (m *UserModel) ByEmail(email string) (User, error) {
-	stmt := `select "id", "name", "email", "hashed_password", "create_time" from "users" where email = ?`
+func (m *UserModel) Get(ctx context.Context, id uuid.UUID) (_ User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where u."id" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, id))
+}
+
+func (m *UserModel) ByEmail(ctx context.Context, email string) (_ User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.ByEmail")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where lower(u."email") = lower(?)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, email))
+}
+
+// ByName finds the user by the name regardless of the case, the same as the unique index
+func (m *UserModel) ByName(ctx context.Context, name string) (_ User, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.ByName")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select ` + userColumns + ` from "users" u where lower(u."name") = lower(?)`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, stmt, name))
+}
+
+// userColumns are the columns of the user u read by scanUser
+const userColumns = `u."id", u."name", u."email", u."hashed_password", u."admin", u."default_expires", u."bio", u."suspended",
+	exists(select true from "user_avatars" a where a.user_id = u.id), u."create_time"`
 
+func scanUser(row *sql.Row) (User, error) {
 	var u User
-	err := m.DB.QueryRow(stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.HashedPassword, &u.CreateTime)
+
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.HashedPassword, &u.Admin, &u.DefaultExpires, &u.Bio, &u.Suspended, &u.HasAvatar, &u.CreateTime)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, ErrNoRecord
@@ -98,14 +209,139 @@ func (m *UserModel) ByEmail(email string) (User, error) {
 	return u, nil
 }
 
-func (m *UserModel) EmailExists(email string) (bool, error) {
-	stmt := `select count(*) from "users" where email = ?`
+// UpdatePassword checks the current password before replacing it, so that a stolen session isn't enough to take over the account
+func (m *UserModel) UpdatePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdatePassword")
+	defer func() { endSpan(span, err) }()
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ok, err := m.verify(ctx, string(usr.HashedPassword), currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	newHash, err := m.hash(ctx, newPassword)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update "users" set "hashed_password" = ? where "id" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, newHash, id)
+	return err
+}
+
+// UpdateDefaultExpires changes the expiry of the forks and the preselected one of the new snippets
+func (m *UserModel) UpdateDefaultExpires(ctx context.Context, id uuid.UUID, expires int) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdateDefaultExpires")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `update "users" set "default_expires" = ? where "id" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, expires, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (m *UserModel) UpdateBio(ctx context.Context, id uuid.UUID, bio string) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.UpdateBio")
+	defer func() { endSpan(span, err) }()
 
-	var count int
-	err := m.DB.QueryRow(stmt, email).Scan(&count)
+	stmt := `update "users" set "bio" = ? where "id" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, bio, id)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	return count > 0, nil
+	return expectAffected(result)
+}
+
+func (m *UserModel) Avatar(ctx context.Context, id uuid.UUID) (_ Avatar, err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.Avatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `select "content_type", "data", "update_time" from "user_avatars" where "user_id" = ?`
+
+	var a Avatar
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&a.ContentType, &a.Data, &a.UpdateTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Avatar{}, ErrNoRecord
+		} else {
+			return Avatar{}, err
+		}
+	}
+
+	return a, nil
+}
+
+// SetAvatar adds the avatar of the user or replaces the existing one
+func (m *UserModel) SetAvatar(ctx context.Context, id uuid.UUID, contentType string, data []byte) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.SetAvatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "user_avatars" ("user_id", "content_type", "data", "update_time")
+	values (?, ?, ?, current_timestamp)
+	on conflict ("user_id") do update set "content_type" = excluded."content_type", "data" = excluded."data", "update_time" = excluded."update_time"`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id, contentType, data)
+	return err
+}
+
+// DeleteAvatar removes the avatar of the user, without an avatar it does nothing
+func (m *UserModel) DeleteAvatar(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "UserModel.DeleteAvatar")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `delete from "user_avatars" where "user_id" = ?`
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+func (m *UserModel) hash(ctx context.Context, plaintext string) (string, error) {
+	_, span := m.Tracer.Start(ctx, "Hasher.Hash")
+	hash, err := m.Hasher.Hash(plaintext)
+	endSpan(span, err)
+
+	return hash, err
+}
+
+func (m *UserModel) verify(ctx context.Context, hash, plaintext string) (bool, error) {
+	_, span := m.Tracer.Start(ctx, "password.Verify")
+	ok, err := password.Verify(hash, plaintext)
+	endSpan(span, err)
+
+	return ok, err
 }
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"snippetbox.doichevkostia.dev/internal/trace"
+	"time"
+)
+
+// ViewModelInterface counts the views of the snippets, a visitor is counted once a day.
+// The views are written in batches by a background writer, not by the requests
+type ViewModelInterface interface {
+	Insert(ctx context.Context, views []View) error
+	Stats(ctx context.Context, snippetID uuid.UUID, days int) (ViewStats, error)
+}
+
+type View struct {
+	SnippetID uuid.UUID
+	Visitor   string // a keyed hash of the visitor, the key isn't stored
+	Time      time.Time
+}
+
+// Day is the day of the view in UTC, the views of a visitor are counted once per day
+func (v View) Day() string {
+	return v.Time.UTC().Format(DayFormat)
+}
+
+// DayFormat is the format of the days of the views
+const DayFormat = "2006-01-02"
+
+type ViewStats struct {
+	Total int          // the visitors of all the days
+	Daily []DailyViews // the last days, the oldest first, the days without views included
+}
+
+type DailyViews struct {
+	Day   time.Time
+	Views int
+}
+
+// DailyStats returns the views of the days up to today in UTC, counts has the views by the day in DayFormat
+func DailyStats(counts map[string]int, days int) []DailyViews {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	daily := make([]DailyViews, days)
+
+	for i := range daily {
+		day := today.AddDate(0, 0, i-days+1)
+		daily[i] = DailyViews{Day: day, Views: counts[day.Format(DayFormat)]}
+	}
+
+	return daily
+}
+
+// firstDay is the first of the last days in DayFormat
+func firstDay(days int) string {
+	return time.Now().UTC().AddDate(0, 0, 1-days).Format(DayFormat)
+}
+
+type ViewModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Tracer       *trace.Tracer
+}
+
+// Insert counts the views, the repeated views of a visitor on the same day and the views of the snippets
+// that don't exist are ignored
+func (m *ViewModel) Insert(ctx context.Context, views []View) (err error) {
+	ctx, span := m.Tracer.Start(ctx, "ViewModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `insert into "snippet_views" ("snippet_id", "day", "visitor")
+	select s.id, ?, ? from "snippets" s where s.id = ?
+	on conflict do nothing`
+
+	// the whole transaction is limited as one query
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, v := range views {
+		_, err = tx.ExecContext(ctx, stmt, v.Day(), v.Visitor, v.SnippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Stats returns the views of the snippet with the views of the last days
+func (m *ViewModel) Stats(ctx context.Context, snippetID uuid.UUID, days int) (_ ViewStats, err error) {
+	ctx, span := m.Tracer.Start(ctx, "ViewModel.Stats")
+	defer func() { endSpan(span, err) }()
+
+	var stats ViewStats
+
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, `select count(*) from "snippet_views" where snippet_id = ?`, snippetID).Scan(&stats.Total)
+	if err != nil {
+		return ViewStats{}, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select "day", count(*) from "snippet_views" where snippet_id = ? and day >= ? group by "day"`,
+		snippetID, firstDay(days))
+	if err != nil {
+		return ViewStats{}, err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[string]int)
+
+	for rows.Next() {
+		var day string
+		var views int
+
+		err = rows.Scan(&day, &views)
+		if err != nil {
+			return ViewStats{}, err
+		}
+
+		counts[day] = views
+	}
+
+	if err = rows.Err(); err != nil {
+		return ViewStats{}, err
+	}
+
+	stats.Daily = DailyStats(counts, days)
+
+	return stats, nil
+}
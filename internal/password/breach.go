@@ -0,0 +1,69 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+// BreachList is a set of the passwords known from the breaches. The comparison ignores the case,
+// if "password" is on the list, "Password" is just as easy to guess
+type BreachList struct {
+	passwords map[string]struct{}
+}
+
+// DefaultBreachList has the most common passwords, it is used when no list is configured
+func DefaultBreachList() *BreachList {
+	l, err := ParseBreachList(strings.NewReader(commonPasswords))
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+// ReadBreachList reads the list from a file
+func ReadBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseBreachList(f)
+}
+
+// ParseBreachList reads one password per line. The empty lines and the lines starting with # are skipped
+func ParseBreachList(r io.Reader) (*BreachList, error) {
+	l := &BreachList{passwords: make(map[string]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		l.passwords[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *BreachList) Contains(password string) bool {
+	_, ok := l.passwords[strings.ToLower(password)]
+	return ok
+}
+
+func (l *BreachList) Len() int {
+	return len(l.passwords)
+}
@@ -0,0 +1,36 @@
+// Package password hashes the passwords and verifies them against the hashes of every supported algorithm.
+//
+// The hashes are self-describing. Argon2id uses the PHC string format, $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>,
+// and bcrypt has its own $2a$<cost>$... one. So a stored hash tells how to verify it and whether it is outdated,
+// the hasher can be changed without invalidating the existing passwords
+package password
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownHash = errors.New("password: unknown hash format")
+	ErrInvalidHash = errors.New("password: invalid hash")
+)
+
+// Hasher makes the new hashes with one algorithm and its parameters
+type Hasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether the hash was made with another algorithm or other parameters,
+	// it should be replaced the next time the password is known
+	NeedsRehash(hash string) bool
+}
+
+// Verify compares the password with the hash made by any of the hashers
+func Verify(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		return verifyArgon2id(hash, password)
+	case isBcrypt(hash):
+		return verifyBcrypt(hash, password)
+	default:
+		return false, ErrUnknownHash
+	}
+}
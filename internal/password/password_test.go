@@ -0,0 +1,168 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"strings"
+	"testing"
+)
+
+// fastArgon2id keeps the tests fast, the defaults take tens of milliseconds per hash
+var fastArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func mustHash(t *testing.T, h Hasher, password string) string {
+	t.Helper()
+
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestVerify(t *testing.T) {
+	argon2idHash := mustHash(t, &fastArgon2id, "pa$$word")
+	bcryptHash := mustHash(t, &Bcrypt{Cost: bcrypt.MinCost}, "pa$$word")
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		wantOK   bool
+		wantErr  error
+	}{
+		{
+			name:     "Argon2id",
+			hash:     argon2idHash,
+			password: "pa$$word",
+			wantOK:   true,
+		},
+		{
+			name:     "Argon2id wrong password",
+			hash:     argon2idHash,
+			password: "Pa$$word",
+		},
+		{
+			name:     "Bcrypt",
+			hash:     bcryptHash,
+			password: "pa$$word",
+			wantOK:   true,
+		},
+		{
+			name:     "Bcrypt wrong password",
+			hash:     bcryptHash,
+			password: "Pa$$word",
+		},
+		{
+			name:     "Unknown format",
+			hash:     "pa$$word",
+			password: "pa$$word",
+			wantErr:  ErrUnknownHash,
+		},
+		{
+			name:     "Corrupted argon2id",
+			hash:     strings.Replace(argon2idHash, "m=64", "m=x", 1),
+			password: "pa$$word",
+			wantErr:  ErrInvalidHash,
+		},
+		{
+			name:     "Zero argon2id memory",
+			hash:     strings.Replace(argon2idHash, "m=64", "m=0", 1),
+			password: "pa$$word",
+			wantErr:  ErrInvalidHash,
+		},
+		{
+			name:     "Zero argon2id iterations",
+			hash:     strings.Replace(argon2idHash, "t=1", "t=0", 1),
+			password: "pa$$word",
+			wantErr:  ErrInvalidHash,
+		},
+		{
+			name:     "Zero argon2id parallelism",
+			hash:     strings.Replace(argon2idHash, "p=1", "p=0", 1),
+			password: "pa$$word",
+			wantErr:  ErrInvalidHash,
+		},
+		{
+			name:     "Unsupported argon2id version",
+			hash:     strings.Replace(argon2idHash, "v=19", "v=16", 1),
+			password: "pa$$word",
+			wantErr:  ErrInvalidHash,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := Verify(tt.hash, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, ok, tt.wantOK)
+		})
+	}
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash := mustHash(t, &fastArgon2id, "pa$$word")
+
+	assert.StringContains(t, hash, "$argon2id$v=19$m=64,t=1,p=1$")
+
+	// the salt is random
+	if hash == mustHash(t, &fastArgon2id, "pa$$word") {
+		t.Error("got the same hash twice")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2idHash := mustHash(t, &fastArgon2id, "pa$$word")
+	bcryptHash := mustHash(t, &Bcrypt{Cost: bcrypt.MinCost}, "pa$$word")
+
+	moreMemory := fastArgon2id
+	moreMemory.Memory *= 2
+
+	longerKey := fastArgon2id
+	longerKey.KeyLength = 64
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{name: "Same argon2id", hasher: &fastArgon2id, hash: argon2idHash, want: false},
+		{name: "Argon2id memory", hasher: &moreMemory, hash: argon2idHash, want: true},
+		{name: "Argon2id key length", hasher: &longerKey, hash: argon2idHash, want: true},
+		{name: "Bcrypt to argon2id", hasher: &fastArgon2id, hash: bcryptHash, want: true},
+		{name: "Same bcrypt", hasher: &Bcrypt{Cost: bcrypt.MinCost}, hash: bcryptHash, want: false},
+		{name: "Bcrypt cost", hasher: &Bcrypt{Cost: bcrypt.MinCost + 1}, hash: bcryptHash, want: true},
+		{name: "Argon2id to bcrypt", hasher: &Bcrypt{Cost: bcrypt.MinCost}, hash: argon2idHash, want: true},
+		{name: "Garbage", hasher: &fastArgon2id, hash: "garbage", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.hasher.NeedsRehash(tt.hash), tt.want)
+		})
+	}
+}
+
+func TestBreachList(t *testing.T) {
+	l, err := ParseBreachList(strings.NewReader("# comment\n\nhunter2\n  Correct Horse  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, l.Len(), 2)
+	assert.Equal(t, l.Contains("hunter2"), true)
+	assert.Equal(t, l.Contains("HUNTER2"), true)
+	assert.Equal(t, l.Contains("correct horse"), true)
+	assert.Equal(t, l.Contains("# comment"), false)
+	assert.Equal(t, l.Contains("hunter3"), false)
+
+	d := DefaultBreachList()
+	assert.Equal(t, d.Contains("Password123"), true)
+	assert.Equal(t, d.Contains("an old silent pond"), false)
+}
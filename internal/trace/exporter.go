@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes every span as a JSON line. It doesn't need a collector,
+// the output can be read as is or shipped elsewhere later
+type WriterExporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// NewFileExporter appends the spans to the file at path
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := NewWriterExporter(f)
+	exporter.closer = f
+
+	return exporter, nil
+}
+
+type jsonSpan struct {
+	Name         string         `json:"name"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	StartTime    time.Time      `json:"start_time"`
+	EndTime      time.Time      `json:"end_time"`
+	DurationMS   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Status       Status         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (e *WriterExporter) Export(span SpanData) error {
+	data := jsonSpan{
+		Name:       span.Name,
+		TraceID:    span.SpanContext.TraceID.String(),
+		SpanID:     span.SpanContext.SpanID.String(),
+		StartTime:  span.StartTime,
+		EndTime:    span.EndTime,
+		DurationMS: float64(span.EndTime.Sub(span.StartTime).Microseconds()) / 1000,
+		Attributes: span.Attributes,
+		Status:     span.Status,
+		Error:      span.Error,
+	}
+
+	if span.ParentSpanID.IsValid() {
+		data.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.encoder.Encode(data)
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+
+	return nil
+}
+
+// MemoryExporter keeps the ended spans, it is meant for the tests
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *MemoryExporter) Export(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func (e *MemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	spans := make([]SpanData, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	flagSampled = 0x01
+)
+
+// Extract reads the traceparent and tracestate headers. The second value reports whether the headers were valid
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(traceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	sc.TraceState = header.Get(tracestateHeader)
+	sc.Remote = true
+
+	return sc, true
+}
+
+// Inject writes the span context from ctx to the headers of an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	header.Set(traceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		header.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses the header as described in https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("trace: malformed traceparent %q", value)
+	}
+
+	version, err := decodeHex(parts[0], 1)
+	if err != nil || version[0] == 0xff {
+		return SpanContext{}, fmt.Errorf("trace: invalid traceparent version %q", parts[0])
+	}
+
+	// the future versions may append fields, but version 00 has exactly four
+	if version[0] == 0 && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("trace: malformed traceparent %q", value)
+	}
+
+	var sc SpanContext
+
+	traceID, err := decodeHex(parts[1], len(sc.TraceID))
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("trace: invalid trace id %q", parts[1])
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, err := decodeHex(parts[2], len(sc.SpanID))
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("trace: invalid parent id %q", parts[2])
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("trace: invalid trace flags %q", parts[3])
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("trace: all zero ids in traceparent %q", value)
+	}
+
+	return sc, nil
+}
+
+// decodeHex accepts only the lowercase hex of exactly n bytes
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != n*2 || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("trace: expected %d lowercase hex characters", n*2)
+	}
+
+	return hex.DecodeString(s)
+}
+
+// Transport propagates the trace context to the requests made by an http.Client
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	r = r.Clone(r.Context())
+	Inject(r.Context(), r.Header)
+
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,161 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"snippetbox.doichevkostia.dev/internal/assert"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantErr     bool
+		wantSampled bool
+	}{
+		{
+			name:        "Sampled",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantSampled: true,
+		},
+		{
+			name:  "Not sampled",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:        "Future version with more fields",
+			value:       "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like",
+			wantSampled: true,
+		},
+		{
+			name:    "Version 00 with more fields",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantErr: true,
+		},
+		{
+			name:    "Forbidden version",
+			value:   "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Zero trace id",
+			value:   "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Zero parent id",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			wantErr: true,
+		},
+		{
+			name:    "Uppercase",
+			value:   "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Short trace id",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Empty",
+			value:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+
+			assert.Equal(t, err != nil, tt.wantErr)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, sc.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+			assert.Equal(t, sc.SpanID.String(), "00f067aa0ba902b7")
+			assert.Equal(t, sc.Sampled, tt.wantSampled)
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	ctx, span := tracer.Start(context.Background(), "outgoing")
+
+	header := http.Header{}
+	Inject(ctx, header)
+	span.End()
+
+	assert.Equal(t, header.Get("traceparent"), FormatTraceparent(span.SpanContext()))
+
+	sc, ok := Extract(header)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, sc.TraceID, span.SpanContext().TraceID)
+	assert.Equal(t, sc.SpanID, span.SpanContext().SpanID)
+	assert.Equal(t, sc.Remote, true)
+}
+
+func TestSpanHierarchy(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+
+	ctx, parent := tracer.Start(ctx, "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	assert.Equal(t, len(spans), 2)
+
+	assert.Equal(t, spans[0].Name, "child")
+	assert.Equal(t, spans[0].SpanContext.TraceID, remote.TraceID)
+	assert.Equal(t, spans[0].ParentSpanID, parent.SpanContext().SpanID)
+	assert.Equal(t, spans[0].Attributes["key"], any("value"))
+	assert.Equal(t, spans[0].Status, StatusError)
+	assert.Equal(t, spans[0].Error, "boom")
+
+	assert.Equal(t, spans[1].Name, "parent")
+	assert.Equal(t, spans[1].ParentSpanID, remote.SpanID)
+}
+
+func TestNotSampled(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), remote), "span")
+	span.End()
+
+	assert.Equal(t, len(exporter.Spans()), 0)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.Start(context.Background(), "noop")
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("boom"))
+	span.End()
+
+	assert.Equal(t, SpanContextFromContext(ctx).IsValid(), false)
+}
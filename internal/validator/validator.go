@@ -57,3 +57,18 @@ func MinChars(value string, n int) bool {
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+func MaxCount[T any](values []T, n int) bool {
+	return len(values) <= n
+}
+
+// AllowedChars reports whether every character of the value is one of the allowed ones
+func AllowedChars(value string, allowed string) bool {
+	for _, r := range value {
+		if !strings.ContainsRune(allowed, r) {
+			return false
+		}
+	}
+
+	return true
+}
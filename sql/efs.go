@@ -0,0 +1,8 @@
+// Package sql embeds the migrations of the schemas. A new database is created from schema.sql or
+// schema_postgres.sql, an existing one is upgraded with the migrations, see the -migrate flag
+package sql
+
+import "embed"
+
+//go:embed "migrations"
+var Migrations embed.FS
@@ -0,0 +1,10 @@
+-- The names and the emails are unique regardless of the case. The migration fails if two users differ only in the
+-- case, rename one of them first
+
+insert into "schema_version" ("version") values (2);
+
+alter table "users" drop constraint "users_name_key";
+drop index "idx_users_email";
+
+create unique index "idx_users_name" on "users" (lower("name"));
+create unique index "idx_users_email" on "users" (lower("email"));
@@ -0,0 +1,32 @@
+-- Every snippet is owned by a user. The existing snippets go to the oldest admin, or to the oldest user if there are
+-- no admins. The migration fails if there are snippets but no users, sign up first. Adds the collections of the
+-- snippets
+
+insert into "schema_version" ("version") values (3);
+
+alter table "snippets" add column "user_id" uuid references "users" ("id") on delete cascade;
+
+update "snippets" set "user_id" = (select "id" from "users" order by "admin" desc, "create_time", "id" limit 1);
+
+alter table "snippets" alter column "user_id" set not null;
+
+create index "idx_snippets_user_id" on "snippets" ("user_id");
+
+create table "collections" (
+    "id" uuid primary key,
+    "user_id" uuid not null references "users" ("id") on delete cascade,
+    "name" text not null,
+    "visibility" text not null default 'private' check ("visibility" in ('public', 'unlisted', 'private')),
+    "create_time" timestamptz not null default current_timestamp
+);
+
+create unique index "idx_collections_user_id_name" on "collections" ("user_id", lower("name"));
+
+create table "collection_snippets" (
+    "collection_id" uuid not null references "collections" ("id") on delete cascade,
+    "snippet_id" uuid not null references "snippets" ("id") on delete cascade,
+    "position" integer not null
+);
+
+create unique index "idx_collection_snippets_collection_id_snippet_id" on "collection_snippets" ("collection_id", "snippet_id");
+create index "idx_collection_snippets_snippet_id" on "collection_snippets" ("snippet_id");
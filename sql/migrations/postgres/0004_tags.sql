@@ -0,0 +1,18 @@
+-- Adds the tags of the snippets
+
+insert into "schema_version" ("version") values (4);
+
+create table "tags" (
+    "id" uuid primary key,
+    "name" text not null
+);
+
+create unique index "idx_tags_name" on "tags" ("name");
+
+create table "snippet_tags" (
+    "snippet_id" uuid not null references "snippets" ("id") on delete cascade,
+    "tag_id" uuid not null references "tags" ("id") on delete cascade
+);
+
+create unique index "idx_snippet_tags_snippet_id_tag_id" on "snippet_tags" ("snippet_id", "tag_id");
+create index "idx_snippet_tags_tag_id" on "snippet_tags" ("tag_id");
@@ -0,0 +1,9 @@
+-- Adds the forks and the default expiry of the users
+
+insert into "schema_version" ("version") values (6);
+
+alter table "users" add column "default_expires" integer not null default 365;
+
+alter table "snippets" add column "forked_from" uuid references "snippets" ("id") on delete set null;
+
+create index "idx_snippets_forked_from" on "snippets" ("forked_from");
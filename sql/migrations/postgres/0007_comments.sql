@@ -0,0 +1,19 @@
+-- Adds the comments
+
+insert into "schema_version" ("version") values (7);
+
+alter table "snippets" add column "comments_enabled" boolean not null default true;
+
+create table "comments" (
+    "id" uuid primary key,
+    "snippet_id" uuid not null references "snippets" ("id") on delete cascade,
+    "user_id" uuid not null references "users" ("id") on delete cascade,
+    "parent_id" uuid references "comments" ("id") on delete cascade,
+    "content" text not null,
+    "create_time" timestamptz not null default current_timestamp,
+    "edit_time" timestamptz,
+    "delete_time" timestamptz
+);
+
+create index "idx_comments_snippet_id" on "comments" ("snippet_id");
+create index "idx_comments_parent_id" on "comments" ("parent_id");
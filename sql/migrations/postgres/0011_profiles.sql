@@ -0,0 +1,13 @@
+-- Adds the public profiles of the users
+
+insert into "schema_version" ("version") values (11);
+
+alter table "users" add column "bio" text not null default '';
+alter table "users" add column "suspended" boolean not null default false;
+
+create table "user_avatars" (
+    "user_id" uuid primary key references "users" ("id") on delete cascade,
+    "content_type" text not null,
+    "data" bytea not null,
+    "update_time" timestamptz not null default current_timestamp
+);
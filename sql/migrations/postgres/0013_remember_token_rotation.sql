@@ -0,0 +1,6 @@
+-- Keeps the token before the last rotation of a persistent login
+
+insert into "schema_version" ("version") values (13);
+
+alter table "remember_tokens" add column "previous_token_hash" text;
+alter table "remember_tokens" add column "rotate_time" timestamptz;
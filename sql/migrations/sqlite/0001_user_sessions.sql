@@ -0,0 +1,34 @@
+-- The database of the first release has no "schema_version" table, it is the version 0.
+-- Adds the admins, the user sessions and the persistent logins
+
+create table "schema_version" (
+    "version" integer not null
+);
+
+insert into "schema_version" ("version") values (1);
+
+alter table "users" add column "admin" boolean not null default false;
+
+create table "user_sessions" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "ip" text not null,
+    "user_agent" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "last_seen_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_user_sessions_user_id" on "user_sessions" ("user_id");
+
+create table "remember_tokens" (
+    "series" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "user_session_id" text not null,
+    "token_hash" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_remember_tokens_user_id" on "remember_tokens" ("user_id");
+create index "idx_remember_tokens_user_session_id" on "remember_tokens" ("user_session_id");
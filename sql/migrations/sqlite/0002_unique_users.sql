@@ -0,0 +1,23 @@
+-- The names and the emails are unique regardless of the case. The table is rebuilt to drop the case-sensitive unique
+-- constraint of the name. The migration fails if two users differ only in the case, rename one of them first
+
+insert into "schema_version" ("version") values (2);
+
+create table "new_users" (
+    "id" text primary key,
+    "name" text not null,
+    "email" text not null,
+    "hashed_password" text not null,
+    "admin" boolean not null default false,
+    "create_time" timestamp not null default current_timestamp
+);
+
+insert into "new_users" ("id", "name", "email", "hashed_password", "admin", "create_time")
+select "id", "name", "email", "hashed_password", "admin", "create_time" from "users";
+
+drop table "users";
+
+alter table "new_users" rename to "users";
+
+create unique index "idx_users_name" on "users" (lower("name"));
+create unique index "idx_users_email" on "users" (lower("email"));
@@ -0,0 +1,44 @@
+-- Every snippet is owned by a user. The table is rebuilt to add the not null "user_id", the existing snippets go to
+-- the oldest admin, or to the oldest user if there are no admins. The migration fails if there are snippets but no
+-- users, sign up first. Adds the collections of the snippets
+
+insert into "schema_version" ("version") values (3);
+
+create table "new_snippets" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "title" text not null,
+    "content" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+insert into "new_snippets" ("id", "user_id", "title", "content", "create_time", "expire_time")
+select "id", (select "id" from "users" order by "admin" desc, "create_time", "id" limit 1), "title", "content", "create_time", "expire_time"
+from "snippets";
+
+drop table "snippets";
+
+alter table "new_snippets" rename to "snippets";
+
+create index "idx_snippets_create_time" on "snippets" ("create_time");
+create index "idx_snippets_user_id" on "snippets" ("user_id");
+
+create table "collections" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "name" text not null,
+    "visibility" text not null default 'private' check ("visibility" in ('public', 'unlisted', 'private')),
+    "create_time" timestamp not null default current_timestamp
+);
+
+create unique index "idx_collections_user_id_name" on "collections" ("user_id", lower("name"));
+
+create table "collection_snippets" (
+    "collection_id" text not null references "collections" ("id") on delete cascade,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "position" integer not null
+);
+
+create unique index "idx_collection_snippets_collection_id_snippet_id" on "collection_snippets" ("collection_id", "snippet_id");
+create index "idx_collection_snippets_snippet_id" on "collection_snippets" ("snippet_id");
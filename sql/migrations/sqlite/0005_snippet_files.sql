@@ -0,0 +1,13 @@
+-- Adds the files of the multi-file snippets
+
+insert into "schema_version" ("version") values (5);
+
+create table "snippet_files" (
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "filename" text not null,
+    "language" text not null,
+    "content" text not null,
+    "position" integer not null
+);
+
+create unique index "idx_snippet_files_snippet_id_filename" on "snippet_files" ("snippet_id", "filename");
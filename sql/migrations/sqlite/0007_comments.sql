@@ -0,0 +1,19 @@
+-- Adds the comments
+
+insert into "schema_version" ("version") values (7);
+
+alter table "snippets" add column "comments_enabled" boolean not null default true;
+
+create table "comments" (
+    "id" text primary key,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "parent_id" text references "comments" ("id") on delete cascade,
+    "content" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "edit_time" timestamp,
+    "delete_time" timestamp
+);
+
+create index "idx_comments_snippet_id" on "comments" ("snippet_id");
+create index "idx_comments_parent_id" on "comments" ("parent_id");
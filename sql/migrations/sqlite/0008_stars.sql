@@ -0,0 +1,12 @@
+-- Adds the stars
+
+insert into "schema_version" ("version") values (8);
+
+create table "stars" (
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "create_time" timestamp not null default current_timestamp
+);
+
+create unique index "idx_stars_user_id_snippet_id" on "stars" ("user_id", "snippet_id");
+create index "idx_stars_snippet_id_create_time" on "stars" ("snippet_id", "create_time");
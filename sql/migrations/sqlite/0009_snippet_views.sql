@@ -0,0 +1,11 @@
+-- Adds the daily views of the snippets
+
+insert into "schema_version" ("version") values (9);
+
+create table "snippet_views" (
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "day" text not null,
+    "visitor" text not null
+);
+
+create unique index "idx_snippet_views_snippet_id_day_visitor" on "snippet_views" ("snippet_id", "day", "visitor");
@@ -0,0 +1,5 @@
+-- Adds the formats of the snippets, the existing ones are plain text
+
+insert into "schema_version" ("version") values (10);
+
+alter table "snippets" add column "format" text not null default 'plain' check ("format" in ('plain', 'markdown', 'code'));
@@ -0,0 +1,5 @@
+-- Adds the visibility of the snippets, the existing ones are public
+
+insert into "schema_version" ("version") values (12);
+
+alter table "snippets" add column "visibility" text not null default 'public' check ("visibility" in ('public', 'unlisted', 'private'));
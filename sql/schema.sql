@@ -1,14 +1,13 @@
 -- driver SQLite
+-- Keep in sync with schema_postgres.sql. Every change needs a migration in migrations/sqlite, the existing databases
+-- are upgraded with -migrate
 
-create table "snippets" (
-    "id" text primary key,
-    "title" text not null,
-    "content" text not null,
-    "create_time" timestamp not null default current_timestamp,
-    "expire_time" timestamp not null
+-- The version is checked by /readyz, it has to match models.SchemaVersion
+create table "schema_version" (
+    "version" integer not null
 );
 
-create index "idx_snippets_create_time" on "snippets" ("create_time");
+insert into "schema_version" ("version") values (13);
 
 -- For the github.com/alexedwards/scs/v2
 create table "sessions" (
@@ -21,10 +20,167 @@ create index "idx_session_expiry" on "sessions" ("expiry");
 
 create table "users" (
     "id" text primary key,
-    "name" text not null unique,
+    "name" text not null,
     "email" text not null,
     "hashed_password" text not null,
+    -- there is no UI to grant the admin rights, use `update "users" set "admin" = true where ...`
+    "admin" boolean not null default false,
+    -- in days, the expiry of the forks and the preselected one of the new snippets, see models.DefaultExpires
+    "default_expires" integer not null default 365,
+    -- shown on the public profile, /u/{name}
+    "bio" text not null default '',
+    -- the profile of a suspended user is hidden, there is no UI to suspend, use `update "users" set "suspended" = true where ...`
+    "suspended" boolean not null default false,
+    "create_time" timestamp not null default current_timestamp
+);
+
+-- Both the names and the emails are unique regardless of the case, the lookups go through lower() to use the indexes.
+-- The model maps the violations of the indexes to models.ErrDuplicateName and models.ErrDuplicateEmail by their names
+create unique index "idx_users_name" on "users" (lower("name"));
+create unique index "idx_users_email" on "users" (lower("email"));
+
+-- The avatar of the profile is served by the app, the CSP doesn't allow the images of the other sites
+create table "user_avatars" (
+    "user_id" text primary key references "users" ("id") on delete cascade,
+    "content_type" text not null,
+    "data" blob not null,
+    "update_time" timestamp not null default current_timestamp
+);
+
+create table "snippets" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "title" text not null,
+    "content" text not null,
+    -- how the content is shown, the markdown is rendered into sanitized HTML
+    "format" text not null default 'plain' check ("format" in ('plain', 'markdown', 'code')),
+    -- the owner can turn off the new comments, the existing ones stay
+    "comments_enabled" boolean not null default true,
+    -- the unlisted and private snippets are left out of every listing, the private ones are seen only by the owner
+    "visibility" text not null default 'public' check ("visibility" in ('public', 'unlisted', 'private')),
+    -- the snippet this one is a copy of, the forks stay when the original expires
+    "forked_from" text references "snippets" ("id") on delete set null,
+    "create_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_snippets_create_time" on "snippets" ("create_time");
+create index "idx_snippets_user_id" on "snippets" ("user_id");
+create index "idx_snippets_forked_from" on "snippets" ("forked_from");
+
+-- The names are normalized by models.NormalizeTags, they are stored in the lower case
+create table "tags" (
+    "id" text primary key,
+    "name" text not null
+);
+
+create unique index "idx_tags_name" on "tags" ("name");
+
+create table "snippet_tags" (
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "tag_id" text not null references "tags" ("id") on delete cascade
+);
+
+create unique index "idx_snippet_tags_snippet_id_tag_id" on "snippet_tags" ("snippet_id", "tag_id");
+create index "idx_snippet_tags_tag_id" on "snippet_tags" ("tag_id");
+
+-- The files of a multi-file snippet in their order, the snippet content is the description of the files
+create table "snippet_files" (
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "filename" text not null,
+    "language" text not null,
+    "content" text not null,
+    "position" integer not null
+);
+
+-- The filenames are the names of the entries of the zip download
+create unique index "idx_snippet_files_snippet_id_filename" on "snippet_files" ("snippet_id", "filename");
+
+-- The comments are threaded by the parent. A deleted comment keeps its row without the content,
+-- so that the replies to it stay in the thread
+create table "comments" (
+    "id" text primary key,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "parent_id" text references "comments" ("id") on delete cascade,
+    "content" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "edit_time" timestamp,
+    "delete_time" timestamp
+);
+
+create index "idx_comments_snippet_id" on "comments" ("snippet_id");
+create index "idx_comments_parent_id" on "comments" ("parent_id");
+
+create table "stars" (
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
     "create_time" timestamp not null default current_timestamp
 );
 
-create index "idx_users_email" on "users" ("email");
+-- A user stars a snippet at most once, the toggle relies on it
+create unique index "idx_stars_user_id_snippet_id" on "stars" ("user_id", "snippet_id");
+create index "idx_stars_snippet_id_create_time" on "stars" ("snippet_id", "create_time");
+
+-- A visitor is counted once a day. The visitor is an HMAC of the IP address and the day with a daily key that is only
+-- in the memory of the app, neither the address nor the key is stored
+create table "snippet_views" (
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "day" text not null, -- YYYY-MM-DD in UTC
+    "visitor" text not null
+);
+
+create unique index "idx_snippet_views_snippet_id_day_visitor" on "snippet_views" ("snippet_id", "day", "visitor");
+
+-- Metadata of the logins, the session data itself is in the "sessions" table
+create table "user_sessions" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "ip" text not null,
+    "user_agent" text not null,
+    "create_time" timestamp not null default current_timestamp,
+    "last_seen_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_user_sessions_user_id" on "user_sessions" ("user_id");
+
+-- Persistent logins ("remember me"). The series identifies a device, the token is rotated on every use
+create table "remember_tokens" (
+    "series" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "user_session_id" text not null,
+    "token_hash" text not null, -- hex encoded SHA-256 of the token
+    -- the token before the last rotation, its use right after the rotation is a parallel request and not a theft
+    "previous_token_hash" text,
+    "rotate_time" timestamp,
+    "create_time" timestamp not null default current_timestamp,
+    "expire_time" timestamp not null
+);
+
+create index "idx_remember_tokens_user_id" on "remember_tokens" ("user_id");
+create index "idx_remember_tokens_user_session_id" on "remember_tokens" ("user_session_id");
+
+-- Named groups of snippets of a user. A collection only links to the snippets, the expired ones stay linked
+-- and are filtered out on read. The private collections are visible only to the owner, the unlisted ones
+-- to everyone with the link
+create table "collections" (
+    "id" text primary key,
+    "user_id" text not null references "users" ("id") on delete cascade,
+    "name" text not null,
+    "visibility" text not null default 'private' check ("visibility" in ('public', 'unlisted', 'private')),
+    "create_time" timestamp not null default current_timestamp
+);
+
+-- The model maps the violation to models.ErrDuplicateName
+create unique index "idx_collections_user_id_name" on "collections" ("user_id", lower("name"));
+
+create table "collection_snippets" (
+    "collection_id" text not null references "collections" ("id") on delete cascade,
+    "snippet_id" text not null references "snippets" ("id") on delete cascade,
+    "position" integer not null
+);
+
+-- A snippet is in a collection at most once, adding it again is not an error
+create unique index "idx_collection_snippets_collection_id_snippet_id" on "collection_snippets" ("collection_id", "snippet_id");
+create index "idx_collection_snippets_snippet_id" on "collection_snippets" ("snippet_id");
@@ -5,4 +5,121 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+var tagsInput = document.getElementById("tags");
+var tagSuggestions = document.getElementById("tag-suggestions");
+if (tagsInput && tagSuggestions) {
+	var tagsTimer;
+	tagsInput.addEventListener("input", function () {
+		clearTimeout(tagsTimer);
+		tagsTimer = setTimeout(suggestTags, 200);
+	});
+}
+
+// suggestTags completes the last tag of the input, the options keep the tags before it
+function suggestTags() {
+	var value = tagsInput.value;
+	var start = Math.max(value.lastIndexOf(","), value.lastIndexOf(" ")) + 1;
+	var head = value.slice(0, start);
+	var prefix = value.slice(start).trim();
+	if (prefix === "") {
+		tagSuggestions.replaceChildren();
+		return;
+	}
+
+	fetch("/tags?prefix=" + encodeURIComponent(prefix))
+		.then(function (response) {
+			if (!response.ok) {
+				throw new Error(response.statusText);
+			}
+			return response.json();
+		})
+		.then(function (data) {
+			var options = [];
+			for (var i = 0; i < data.tags.length; i++) {
+				var option = document.createElement("option");
+				option.value = head + data.tags[i].name;
+				option.textContent = data.tags[i].name + " (" + data.tags[i].count + ")";
+				options.push(option);
+			}
+			tagSuggestions.replaceChildren.apply(tagSuggestions, options);
+		})
+		.catch(function () {
+			tagSuggestions.replaceChildren();
+		});
+}
+
+// the "Add file" button copies the last file of the form with the next index, files[1].filename, ...
+var addFile = document.getElementById("add-file");
+if (addFile) {
+	addFile.addEventListener("click", function () {
+		var files = document.querySelectorAll("#files fieldset.file");
+		var last = files[files.length - 1];
+		var copy = last.cloneNode(true);
+
+		var errors = copy.querySelectorAll(".error");
+		for (var i = 0; i < errors.length; i++) {
+			errors[i].remove();
+		}
+
+		var fields = copy.querySelectorAll("[name]");
+		for (var j = 0; j < fields.length; j++) {
+			var field = fields[j];
+			field.name = field.name.replace(/^files\[\d+\]/, "files[" + files.length + "]");
+			if (field.tagName === "SELECT") {
+				field.selectedIndex = 0;
+			} else {
+				field.value = "";
+			}
+		}
+
+		last.after(copy);
+	});
+}
+
+// the star forms toggle without reloading the page, the form still works without the script
+var starForms = document.querySelectorAll("form.star");
+for (var k = 0; k < starForms.length; k++) {
+	starForms[k].addEventListener("submit", toggleStar);
+}
+
+// toggleStar falls back to the form only when the star wasn't toggled, a resubmit after the toggle would undo it
+function toggleStar(event) {
+	event.preventDefault();
+	var form = event.currentTarget;
+	var button = form.querySelector("button");
+	button.disabled = true;
+
+	fetch(form.action, {
+		method: "POST",
+		headers: {"Accept": "application/json"},
+		body: new URLSearchParams(new FormData(form))
+	})
+		.then(function (response) {
+			if (!response.ok) {
+				form.submit();
+				return;
+			}
+			// the star is toggled, an unreadable response reloads the state from the page instead
+			return response.json().then(function (data) {
+				showStar(button, data);
+			}, function () {
+				window.location.reload();
+			});
+		}, function () {
+			form.submit();
+		})
+		.finally(function () {
+			button.disabled = false;
+		});
+}
+
+// showStar syncs the button and the counts with the state from the server
+function showStar(button, data) {
+	button.textContent = data.starred ? "Unstar" : "Star";
+	var counts = document.querySelectorAll(".stars");
+	for (var i = 0; i < counts.length; i++) {
+		counts[i].textContent = data.stars;
+	}
+}